@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// jsonWebKey holds the members of a JWK (RFC 7517) needed to rebuild RSA, EC, Ed25519 and symmetric keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// loadJWKS reads the signature verification keys from a JWKS file, skipping keys meant for encryption
+func loadJWKS(path string) ([]verificationKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set jsonWebKeySet
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("%s is not a JWKS document: %w", path, err)
+	}
+
+	var keys []verificationKey
+	for i, jwk := range set.Keys {
+		if jwk.Use == "enc" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %d (kid [%s]): %w", path, i, jwk.Kid, err)
+		}
+		keys = append(keys, verificationKey{kid: jwk.Kid, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s holds no signing keys", path)
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (any, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		return jwk.ecdsaKey()
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve [%s]", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		k, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(k) == 0 {
+			return nil, errors.New("invalid symmetric key")
+		}
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type [%s]", jwk.Kty)
+	}
+}
+
+func (jwk jsonWebKey) ecdsaKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var checker ecdh.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve, checker = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, checker = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, checker = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported EC curve [%s]", jwk.Crv)
+	}
+
+	x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+	y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+	size := (curve.Params().BitSize + 7) / 8
+	if errX != nil || errY != nil || len(x) != size || len(y) != size {
+		return nil, errors.New("invalid EC coordinates")
+	}
+	//ecdh rejects points that are not on the curve
+	if _, err := checker.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, fmt.Errorf("invalid EC public key: %w", err)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lengebretsen/go-practice/conf"
+)
+
+// minHMACSecretLength is the smallest secret accepted for signing tokens, matching the output size of SHA-256
+const minHMACSecretLength = 32
+
+// supportedMethods are the signing algorithms tokens may use. "none" is never accepted
+var supportedMethods = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+type verificationKey struct {
+	kid string
+	key any
+}
+
+// TokenVerifier validates JWT bearer tokens and maps their claims to roles
+type TokenVerifier struct {
+	keys        []verificationKey
+	parser      *jwt.Parser
+	rolesClaim  string
+	roleMapping map[string]Role
+}
+
+// LoadTokenVerifier builds a TokenVerifier from the auth.jwt config section. It returns nil when neither HMAC
+// secrets nor JWKS files are configured, leaving JWT authentication off
+func LoadTokenVerifier(cfg conf.JWTConfig) (*TokenVerifier, error) {
+	var problems []string
+
+	var keys []verificationKey
+	for i, secret := range cfg.HMACSecrets {
+		if len(secret.Secret) < minHMACSecretLength {
+			problems = append(problems, fmt.Sprintf("auth.jwt.hmacSecrets[%d] must be at least %d bytes", i, minHMACSecretLength))
+			continue
+		}
+		keys = append(keys, verificationKey{kid: secret.Kid, key: []byte(secret.Secret)})
+	}
+	for _, path := range cfg.JWKSFiles {
+		fileKeys, err := loadJWKS(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("auth.jwt.jwksFiles: %v", err))
+			continue
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	//viper lower cases map keys, so claim values are matched case insensitively
+	roleMapping := make(map[string]Role)
+	for value, name := range cfg.RoleMapping {
+		role, err := ParseRole(name)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("auth.jwt.roleMapping[%s]: %v", value, err))
+			continue
+		}
+		roleMapping[strings.ToLower(value)] = role
+	}
+
+	rolesClaim := cfg.RolesClaim
+	if rolesClaim == "" {
+		problems = append(problems, "auth.jwt.rolesClaim is required")
+	}
+	leeway := cfg.Leeway
+	if leeway < 0 {
+		problems = append(problems, "auth.jwt.leeway must not be negative")
+	}
+	if len(problems) > 0 {
+		return nil, errors.New("invalid jwt config:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(supportedMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if issuer := cfg.Issuer; issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience := cfg.Audience; audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+
+	return &TokenVerifier{
+		keys:        keys,
+		parser:      jwt.NewParser(options...),
+		rolesClaim:  rolesClaim,
+		roleMapping: roleMapping,
+	}, nil
+}
+
+// Verify checks the token's signature and registered claims and returns the principal it was issued to. Tokens
+// without a subject are rejected, as callers are told apart by it for logs, rate limits and quotas
+func (v *TokenVerifier) Verify(token string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keyFunc); err != nil {
+		return Principal{}, err
+	}
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return Principal{}, err
+	}
+	if subject == "" {
+		return Principal{}, errors.New("token has no sub claim")
+	}
+	return Principal{Id: "jwt:" + subject, Name: subject, Roles: v.roles(claims)}, nil
+}
+
+// keyFunc picks the configured keys that could have signed the token: those with a matching kid, when the token
+// names one, and of a type that fits the token's algorithm so an RSA public key can never be used as an HMAC secret
+func (v *TokenVerifier) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	var candidates []jwt.VerificationKey
+	for _, k := range v.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if compatible(token.Method, k.key) {
+			candidates = append(candidates, k.key)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("no %s key configured for kid [%s]", token.Method.Alg(), kid)
+	case 1:
+		return candidates[0], nil
+	default:
+		return jwt.VerificationKeySet{Keys: candidates}, nil
+	}
+}
+
+func compatible(method jwt.SigningMethod, key any) bool {
+	switch key.(type) {
+	case []byte:
+		_, ok := method.(*jwt.SigningMethodHMAC)
+		return ok
+	case *rsa.PublicKey:
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*jwt.SigningMethodEd25519)
+		return ok
+	}
+	return false
+}
+
+// roles reads the roles claim, which may be a list or a space separated string and may be nested using dots (e.g.
+// realm_access.roles), keeping the values that are role names or appear in auth.jwt.roleMapping
+func (v *TokenVerifier) roles(claims jwt.MapClaims) []Role {
+	var value any = map[string]any(claims)
+	for _, part := range strings.Split(v.rolesClaim, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+
+	var names []string
+	switch value := value.(type) {
+	case string:
+		names = strings.Fields(value)
+	case []any:
+		for _, item := range value {
+			if name, ok := item.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var roles []Role
+	for _, name := range names {
+		if role, ok := v.roleMapping[strings.ToLower(name)]; ok {
+			roles = append(roles, role)
+		} else if role, err := ParseRole(name); err == nil {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lengebretsen/go-practice/conf"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// writeJWKS saves key's public half as a JWKS document under kid and returns its path
+func writeJWKS(t *testing.T, kid string, key *rsa.PrivateKey) string {
+	doc, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, doc, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestTokenVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := LoadTokenVerifier(conf.JWTConfig{
+		HMACSecrets: []conf.HMACSecret{{Kid: "shared", Secret: testSecret}},
+		JWKSFiles:   []string{writeJWKS(t, "rsa-1", rsaKey)},
+		Issuer:      "https://idp.example.com",
+		RolesClaim:  "realm_access.roles",
+		RoleMapping: map[string]string{"API.Writers": "editor"},
+	})
+	assert.Equal(t, err, nil)
+
+	claims := func(roles ...any) jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":          "alice",
+			"iss":          "https://idp.example.com",
+			"exp":          jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			"realm_access": map[string]any{"roles": roles},
+		}
+	}
+	expired := claims("admin")
+	expired["exp"] = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	wrongIssuer := claims("admin")
+	wrongIssuer["iss"] = "https://evil.example.com"
+	noExpiry := claims("admin")
+	delete(noExpiry, "exp")
+	noSubject := claims("admin")
+	delete(noSubject, "sub")
+	emptySubject := claims("admin")
+	emptySubject["sub"] = ""
+
+	type test struct {
+		token       string
+		wantedRoles []Role
+		wantedErr   bool
+	}
+
+	tests := []test{
+		{token: sign(t, jwt.SigningMethodHS256, "shared", []byte(testSecret), claims("reader")), wantedRoles: []Role{RoleReader}},
+		//a token without a kid is checked against every key of the right type
+		{token: sign(t, jwt.SigningMethodHS512, "", []byte(testSecret), claims("admin", "unknown")), wantedRoles: []Role{RoleAdmin}},
+		{token: sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("api.writers")), wantedRoles: []Role{RoleEditor}},
+		{token: sign(t, jwt.SigningMethodPS384, "rsa-1", rsaKey, claims()), wantedRoles: nil},
+		{token: sign(t, jwt.SigningMethodHS256, "shared", []byte("another-secret-another-secret-00"), claims("admin")), wantedErr: true},
+		{token: sign(t, jwt.SigningMethodHS256, "missing", []byte(testSecret), claims("admin")), wantedErr: true},
+		//an HMAC token keyed with the RSA public key must not verify against the JWKS key
+		{token: sign(t, jwt.SigningMethodHS256, "rsa-1", rsaKey.N.Bytes(), claims("admin")), wantedErr: true},
+		{token: sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, claims("admin")), wantedErr: true},
+		{token: sign(t, jwt.SigningMethodHS256, "shared", []byte(testSecret), expired), wantedErr: true},
+		{token: sign(t, jwt.SigningMethodHS256, "shared", []byte(testSecret), wrongIssuer), wantedErr: true},
+		{token: sign(t, jwt.SigningMethodHS256, "shared", []byte(testSecret), noExpiry), wantedErr: true},
+		//every token without a subject would otherwise be the same caller
+		{token: sign(t, jwt.SigningMethodHS256, "shared", []byte(testSecret), noSubject), wantedErr: true},
+		{token: sign(t, jwt.SigningMethodHS256, "shared", []byte(testSecret), emptySubject), wantedErr: true},
+	}
+
+	for _, testCase := range tests {
+		principal, err := verifier.Verify(testCase.token)
+		assert.Equal(t, err != nil, testCase.wantedErr)
+		if !testCase.wantedErr {
+			assert.Equal(t, principal, Principal{Id: "jwt:alice", Name: "alice", Roles: testCase.wantedRoles})
+		}
+	}
+}
+
+func TestLoadTokenVerifierConfig(t *testing.T) {
+	cfg := conf.JWTConfig{RolesClaim: "roles"}
+	verifier, err := LoadTokenVerifier(cfg)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, verifier == nil, true)
+
+	cfg.HMACSecrets = []conf.HMACSecret{{Secret: "short"}}
+	cfg.RoleMapping = map[string]string{"superuser": "root"}
+	cfg.Leeway = -time.Second
+	_, err = LoadTokenVerifier(cfg)
+	assert.Equal(t, err.Error(), "invalid jwt config:\n"+
+		"  - auth.jwt.hmacSecrets[0] must be at least 32 bytes\n"+
+		"  - auth.jwt.roleMapping[superuser]: unknown role [root], must be one of reader, editor or admin\n"+
+		"  - auth.jwt.leeway must not be negative")
+}
+
+func TestPrincipalCan(t *testing.T) {
+	editor := Principal{Roles: []Role{RoleEditor}}
+	assert.Equal(t, editor.Can(RoleReader), true)
+	assert.Equal(t, editor.Can(RoleEditor), true)
+	assert.Equal(t, editor.Can(RoleAdmin), false)
+	assert.Equal(t, Principal{}.Can(RoleReader), false)
+}
@@ -0,0 +1,45 @@
+package auth
+
+import "fmt"
+
+// Role is a level of access to the API. Each role may do everything the roles below it can
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleReader: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// ParseRole checks that name is one of the known roles
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role [%s], must be one of %s, %s or %s", name, RoleReader, RoleEditor, RoleAdmin)
+	}
+	return role, nil
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	//Id uniquely identifies the caller across requests, it is empty for anonymous callers
+	Id    string
+	Name  string
+	Roles []Role
+}
+
+// Can reports whether the principal holds role or one that outranks it
+func (p Principal) Can(role Role) bool {
+	for _, held := range p.Roles {
+		if roleRanks[held] >= roleRanks[role] {
+			return true
+		}
+	}
+	return false
+}
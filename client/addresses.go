@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// AddressInput holds the fields of an address to create or update. Type is one of HOME, WORK or OTHER
+type AddressInput struct {
+	UserId uuid.UUID `json:"userId"`
+	Street string    `json:"street"`
+	City   string    `json:"city"`
+	State  string    `json:"state"`
+	Zip    string    `json:"zip"`
+	Type   string    `json:"type"`
+}
+
+// ListAddresses returns a page of addresses
+func (c *Client) ListAddresses(ctx context.Context, opts ListOptions) (Page[models.Address], error) {
+	var body pageBody[models.Address]
+	if err := c.call(ctx, http.MethodGet, apiPrefix+"/addresses/", opts.query(), nil, &body); err != nil {
+		return Page[models.Address]{}, err
+	}
+	return body.page(), nil
+}
+
+// Addresses iterates over every address, starting at the page opts picks
+func (c *Client) Addresses(opts ListOptions) *Iterator[models.Address] {
+	return NewIterator(opts, c.ListAddresses)
+}
+
+// GetAddress returns the address with id
+func (c *Client) GetAddress(ctx context.Context, id uuid.UUID) (models.Address, error) {
+	var addr models.Address
+	err := c.call(ctx, http.MethodGet, idPath("/addresses/", id), nil, nil, &addr)
+	return addr, err
+}
+
+// CreateAddress stores a new address for an existing user and returns it with its id
+func (c *Client) CreateAddress(ctx context.Context, input AddressInput) (models.Address, error) {
+	var addr models.Address
+	err := c.call(ctx, http.MethodPost, apiPrefix+"/addresses/", nil, input, &addr)
+	return addr, err
+}
+
+// UpdateAddress replaces the fields of the address with id
+func (c *Client) UpdateAddress(ctx context.Context, id uuid.UUID, input AddressInput) (models.Address, error) {
+	var addr models.Address
+	err := c.call(ctx, http.MethodPut, idPath("/addresses/", id), nil, input, &addr)
+	return addr, err
+}
+
+// DeleteAddress deletes the address with id
+func (c *Client) DeleteAddress(ctx context.Context, id uuid.UUID) error {
+	return c.call(ctx, http.MethodDelete, idPath("/addresses/", id), nil, nil, nil)
+}
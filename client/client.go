@@ -0,0 +1,244 @@
+// Package client is a typed Go client for the go-practice REST API. It calls the /v2 user and address routes and
+// returns models.User and models.Address, reporting failures as *Error decoded from the API's problem responses
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// Defaults used for the Config fields left zero
+const (
+	DefaultTimeout    = 30 * time.Second
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+// apiPrefix is the version of the routes the client calls
+const apiPrefix = "/v2"
+
+// API is the set of calls Client makes, so code using the client can be tested against clienttest.Fake
+type API interface {
+	ListUsers(ctx context.Context, opts ListOptions) (Page[models.User], error)
+	Users(opts ListOptions) *Iterator[models.User]
+	GetUser(ctx context.Context, id uuid.UUID) (models.User, error)
+	CreateUser(ctx context.Context, input UserInput) (models.User, error)
+	UpdateUser(ctx context.Context, id uuid.UUID, input UserInput) (models.User, error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	ListUserAddresses(ctx context.Context, userId uuid.UUID, opts ListOptions) (Page[models.Address], error)
+	UserAddresses(userId uuid.UUID, opts ListOptions) *Iterator[models.Address]
+
+	ListAddresses(ctx context.Context, opts ListOptions) (Page[models.Address], error)
+	Addresses(opts ListOptions) *Iterator[models.Address]
+	GetAddress(ctx context.Context, id uuid.UUID) (models.Address, error)
+	CreateAddress(ctx context.Context, input AddressInput) (models.Address, error)
+	UpdateAddress(ctx context.Context, id uuid.UUID, input AddressInput) (models.Address, error)
+	DeleteAddress(ctx context.Context, id uuid.UUID) error
+}
+
+// Config holds where the API is and how to call it
+type Config struct {
+	//BaseURL is the scheme and host the API is served on, e.g. http://localhost:8080
+	BaseURL string
+	//APIKey is sent in the X-API-Key header when set
+	APIKey string
+	//Token is sent as a bearer token when set, for JWTs issued by the configured identity provider
+	Token string
+	//Timeout bounds each attempt of a call, including reading the response
+	Timeout time.Duration
+	//MaxRetries is how many times a call is retried after a 429, a 5xx or a network error. Negative disables retries
+	MaxRetries int
+	//MinBackoff and MaxBackoff bound the jittered exponential wait between attempts. A Retry-After longer than
+	//MaxBackoff is not waited for, the call fails instead
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	//HTTPClient sends the requests, leave nil for a client with Timeout set
+	HTTPClient *http.Client
+}
+
+// Client calls the API described by its Config. It is safe for concurrent use
+type Client struct {
+	baseURL *url.URL
+	cfg     Config
+	http    *http.Client
+}
+
+var _ API = (*Client)(nil)
+
+// New checks cfg and returns a client for it
+func New(cfg Config) (*Client, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL [%s]: %w", cfg.BaseURL, err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base URL [%s]: must be an absolute http or https URL", cfg.BaseURL)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: cfg.Timeout}
+	}
+	return &Client{baseURL: baseURL, cfg: cfg, http: httpClient}, nil
+}
+
+// call sends a request to path, retrying it as the Config allows, and decodes a successful JSON response into out.
+// POSTs carry an Idempotency-Key, the same on every attempt, so retrying them cannot create duplicates
+func (c *Client) call(ctx context.Context, method string, path string, query url.Values, body any, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("encoding request body: %w", err)
+		}
+	}
+	target := c.baseURL.JoinPath(path)
+	target.RawQuery = query.Encode()
+	//JoinPath drops the trailing slash the collection routes are registered with
+	if strings.HasSuffix(path, "/") && !strings.HasSuffix(target.Path, "/") {
+		target.Path += "/"
+	}
+	var idempotencyKey string
+	if method == http.MethodPost {
+		idempotencyKey = uuid.NewString()
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, target.String(), payload, idempotencyKey, out)
+		if err == nil || attempt >= c.cfg.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		wait, retry := c.retryWait(err, attempt)
+		if !retry {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends one request and decodes its response
+func (c *Client) attempt(ctx context.Context, method string, target string, payload []byte, idempotencyKey string, out any) error {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", c.cfg.APIKey)
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// retryWait reports whether a call that failed with err should be tried again, and how long to wait first
+func (c *Client) retryWait(err error, attempt int) (time.Duration, bool) {
+	var apiErr *Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &apiErr):
+		if apiErr.Status != http.StatusTooManyRequests && apiErr.Status < http.StatusInternalServerError {
+			return 0, false
+		}
+	case !errors.As(err, &urlErr):
+		//only failures to get a response are retried, not responses that could not be decoded
+		return 0, false
+	}
+
+	backoff := c.cfg.MaxBackoff
+	if shift := c.cfg.MinBackoff << attempt; shift > 0 && shift < backoff {
+		backoff = shift
+	}
+	//full jitter keeps clients that failed together from retrying together
+	wait := time.Duration(rand.Int63n(int64(backoff)) + 1)
+	if apiErr != nil && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > c.cfg.MaxBackoff {
+			return 0, false
+		}
+		wait = max(wait, apiErr.RetryAfter)
+	}
+	return wait, true
+}
+
+// decodeError reads the problem details of a failed response, falling back to the status when the body holds none
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" || mediaType == "application/json" {
+		//a body that is not a problem leaves the fields empty, filled from the status below
+		_ = json.NewDecoder(resp.Body).Decode(apiErr)
+	}
+	apiErr.Status = resp.StatusCode
+	if apiErr.Title == "" {
+		apiErr.Title = http.StatusText(resp.StatusCode)
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// idPath is the path of the record id under collection
+func idPath(collection string, id uuid.UUID) string {
+	return apiPrefix + collection + id.String()
+}
@@ -0,0 +1,330 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/client"
+	"github.com/lengebretsen/go-practice/client/clienttest"
+	"github.com/lengebretsen/go-practice/controllers"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+// memoryStore implements the user and address repositories in memory, so the real router can serve the client
+type memoryStore struct {
+	mu        sync.Mutex
+	users     []models.User
+	addresses []models.Address
+}
+
+func (s *memoryStore) SelectAllUsers(context.Context) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.users), nil
+}
+
+func (s *memoryStore) SelectOneUser(_ context.Context, id uuid.UUID) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Id == id {
+			return user, nil
+		}
+	}
+	return models.User{}, models.ErrModelNotFound
+}
+
+func (s *memoryStore) SelectUsersByIds(_ context.Context, ids []uuid.UUID) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []models.User
+	for _, user := range s.users {
+		if slices.Contains(ids, user.Id) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (s *memoryStore) InsertUser(_ context.Context, user models.User) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = append(s.users, user)
+	return user, nil
+}
+
+func (s *memoryStore) UpdateUser(_ context.Context, user models.User) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.users, func(u models.User) bool { return u.Id == user.Id })
+	if i < 0 {
+		return models.User{}, models.ErrModelNotFound
+	}
+	s.users[i] = user
+	return user, nil
+}
+
+func (s *memoryStore) DeleteUser(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.users, func(u models.User) bool { return u.Id == id })
+	if i < 0 {
+		return models.ErrModelNotFound
+	}
+	s.users = slices.Delete(s.users, i, i+1)
+	s.addresses = slices.DeleteFunc(s.addresses, func(addr models.Address) bool { return addr.UserId == id })
+	return nil
+}
+
+func (s *memoryStore) FetchAddresses(context.Context) ([]models.Address, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.addresses), nil
+}
+
+func (s *memoryStore) FetchOneAddress(_ context.Context, id uuid.UUID) (models.Address, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, addr := range s.addresses {
+		if addr.Id == id {
+			return addr, nil
+		}
+	}
+	return models.Address{}, models.ErrModelNotFound
+}
+
+func (s *memoryStore) InsertAddress(_ context.Context, addr models.Address) (models.Address, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addresses = append(s.addresses, addr)
+	return addr, nil
+}
+
+func (s *memoryStore) UpdateAddress(_ context.Context, addr models.Address) (models.Address, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.addresses, func(a models.Address) bool { return a.Id == addr.Id })
+	if i < 0 {
+		return models.Address{}, models.ErrModelNotFound
+	}
+	s.addresses[i] = addr
+	return addr, nil
+}
+
+func (s *memoryStore) DeleteAddress(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.addresses, func(a models.Address) bool { return a.Id == id })
+	if i < 0 {
+		return models.ErrModelNotFound
+	}
+	s.addresses = slices.Delete(s.addresses, i, i+1)
+	return nil
+}
+
+func (s *memoryStore) FindAddressesByUserId(ctx context.Context, userId uuid.UUID) ([]models.Address, error) {
+	return s.FindAddressesByUserIds(ctx, []uuid.UUID{userId})
+}
+
+func (s *memoryStore) FindAddressesByUserIds(_ context.Context, userIds []uuid.UUID) ([]models.Address, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := []models.Address{}
+	for _, addr := range s.addresses {
+		if slices.Contains(userIds, addr.UserId) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// apiKey is the bootstrap key of the router newServer starts
+const apiKey = "client-test-key"
+
+// newServer serves the real router over store
+func newServer(t *testing.T, store *memoryStore) *httptest.Server {
+	router := controllers.SetupRouter(controllers.RouterConfig{})
+	router.Use(controllers.Authenticate(controllers.AuthConfig{BootstrapKey: apiKey}))
+	controllers.RegisterRoutes(router, store, store, controllers.RouteVersions{})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newClient(t *testing.T, cfg client.Config) *client.Client {
+	c, err := client.New(cfg)
+	assert.Equal(t, err, nil)
+	return c
+}
+
+// TestContract runs the same calls against a client of the real router and against the fake, so the fake keeps
+// answering as the API does
+func TestContract(t *testing.T) {
+	apis := map[string]func(t *testing.T) client.API{
+		"client": func(t *testing.T) client.API {
+			return newClient(t, client.Config{BaseURL: newServer(t, &memoryStore{}).URL, APIKey: apiKey})
+		},
+		"fake": func(t *testing.T) client.API {
+			return clienttest.NewFake(nil, nil)
+		},
+	}
+
+	for name, newAPI := range apis {
+		t.Run(name, func(t *testing.T) {
+			api := newAPI(t)
+			ctx := context.Background()
+
+			var users []models.User
+			for _, input := range []client.UserInput{{FirstName: "Pat", LastName: "Smith"}, {FirstName: "Sam", LastName: "Jones"}, {FirstName: "Kim", LastName: "Lee"}} {
+				user, err := api.CreateUser(ctx, input)
+				assert.Equal(t, err, nil)
+				assert.Equal(t, user.FirstName, input.FirstName)
+				users = append(users, user)
+			}
+			got, err := api.GetUser(ctx, users[1].Id)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, got, users[1])
+
+			updated, err := api.UpdateUser(ctx, users[2].Id, client.UserInput{FirstName: "Kim", LastName: "Park"})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, updated, models.User{Id: users[2].Id, FirstName: "Kim", LastName: "Park"})
+			users[2] = updated
+
+			page, err := api.ListUsers(ctx, client.ListOptions{Limit: 2, Offset: 1})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, page, client.Page[models.User]{Items: users[1:], Limit: 2, Offset: 1, Total: 3})
+
+			//the iterator follows the pages to the end of the list
+			var iterated []models.User
+			it := api.Users(client.ListOptions{Limit: 2})
+			for it.Next(ctx) {
+				iterated = append(iterated, it.Value())
+			}
+			assert.Equal(t, it.Err(), nil)
+			assert.Equal(t, iterated, users)
+
+			home, err := api.CreateAddress(ctx, client.AddressInput{UserId: users[0].Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"})
+			assert.Equal(t, err, nil)
+			work, err := api.UpdateAddress(ctx, home.Id, client.AddressInput{UserId: users[0].Id, Street: "2 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "WORK"})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, work, models.Address{Id: home.Id, UserId: users[0].Id, Street: "2 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "WORK"})
+			gotAddr, err := api.GetAddress(ctx, home.Id)
+			assert.Equal(t, err, nil)
+			assert.Equal(t, gotAddr, work)
+
+			addrs, err := api.ListUserAddresses(ctx, users[0].Id, client.ListOptions{})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, addrs, client.Page[models.Address]{Items: []models.Address{work}, Limit: 50, Total: 1})
+			var iteratedAddrs []models.Address
+			addrIt := api.Addresses(client.ListOptions{})
+			for addrIt.Next(ctx) {
+				iteratedAddrs = append(iteratedAddrs, addrIt.Value())
+			}
+			assert.Equal(t, addrIt.Err(), nil)
+			assert.Equal(t, iteratedAddrs, []models.Address{work})
+
+			//failures come back as typed errors carrying the problem code
+			_, err = api.CreateAddress(ctx, client.AddressInput{UserId: uuid.New(), Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"})
+			assert.Equal(t, errors.Is(err, client.ErrNotFound), true)
+			assert.Equal(t, code(err), client.CodeUserNotFound)
+
+			_, err = api.CreateAddress(ctx, client.AddressInput{UserId: users[0].Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "CABIN"})
+			assert.Equal(t, errors.Is(err, client.ErrInvalidRequest), true)
+			var apiErr *client.Error
+			errors.As(err, &apiErr)
+			assert.Equal(t, apiErr.Errors, []client.FieldError{{Field: "type", Code: "oneof", Message: "must be one of HOME, WORK, OTHER"}})
+
+			_, err = api.ListAddresses(ctx, client.ListOptions{Limit: 101})
+			assert.Equal(t, code(err), client.CodeInvalidQuery)
+
+			assert.Equal(t, api.DeleteUser(ctx, users[0].Id), nil)
+			_, err = api.GetAddress(ctx, home.Id)
+			assert.Equal(t, code(err), client.CodeAddressNotFound)
+			assert.Equal(t, code(api.DeleteAddress(ctx, home.Id)), client.CodeAddressNotFound)
+			_, err = api.ListUserAddresses(ctx, users[0].Id, client.ListOptions{})
+			assert.Equal(t, code(err), client.CodeUserNotFound)
+		})
+	}
+}
+
+func code(err error) string {
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ""
+}
+
+func TestClientAuthentication(t *testing.T) {
+	server := newServer(t, &memoryStore{})
+
+	_, err := newClient(t, client.Config{BaseURL: server.URL}).ListUsers(context.Background(), client.ListOptions{})
+	assert.Equal(t, errors.Is(err, client.ErrUnauthorized), true)
+	assert.Equal(t, code(err), client.CodeUnauthorized)
+
+	//the key is accepted as a bearer token as well
+	_, err = newClient(t, client.Config{BaseURL: server.URL, Token: apiKey}).ListUsers(context.Background(), client.ListOptions{})
+	assert.Equal(t, err, nil)
+}
+
+func TestClientRetries(t *testing.T) {
+	type test struct {
+		failures    []int
+		retryAfter  string
+		maxRetries  int
+		wantedErr   error
+		wantedCalls int32
+	}
+
+	tests := []test{
+		{failures: []int{503, 502}, wantedCalls: 3},
+		{failures: []int{429}, retryAfter: "0", wantedCalls: 2},
+		{failures: []int{500, 500, 500, 500}, wantedErr: client.ErrServer, wantedCalls: 4},
+		{failures: []int{500}, maxRetries: -1, wantedErr: client.ErrServer, wantedCalls: 1},
+		//a wait longer than MaxBackoff, such as for a spent daily quota, is not retried
+		{failures: []int{429}, retryAfter: "3600", wantedErr: client.ErrRateLimited, wantedCalls: 1},
+		{failures: []int{409}, wantedErr: client.ErrConflict, wantedCalls: 1},
+	}
+
+	for _, testCase := range tests {
+		router := newServer(t, &memoryStore{}).Config.Handler
+		var calls atomic.Int32
+		keys := map[string]bool{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			call := calls.Add(1)
+			keys[r.Header.Get("Idempotency-Key")] = true
+			if int(call) <= len(testCase.failures) {
+				if testCase.retryAfter != "" {
+					w.Header().Set("Retry-After", testCase.retryAfter)
+				}
+				w.WriteHeader(testCase.failures[call-1])
+				return
+			}
+			router.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+
+		c := newClient(t, client.Config{BaseURL: server.URL, APIKey: apiKey, MaxRetries: testCase.maxRetries,
+			MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+		_, err := c.CreateUser(context.Background(), client.UserInput{FirstName: "Pat", LastName: "Smith"})
+
+		assert.Equal(t, errors.Is(err, testCase.wantedErr), true)
+		assert.Equal(t, calls.Load(), testCase.wantedCalls)
+		//every attempt of a POST carries the same key so the API applies it once
+		assert.Equal(t, len(keys), 1)
+	}
+}
+
+func TestNewRejectsRelativeBaseURL(t *testing.T) {
+	_, err := client.New(client.Config{BaseURL: "localhost:8080"})
+	assert.Equal(t, err != nil, true)
+}
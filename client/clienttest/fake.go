@@ -0,0 +1,318 @@
+// Package clienttest provides an in-memory stand-in for the API client, for tests of code that calls the API
+package clienttest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/client"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// Paging limits of the API, which the fake applies in the same way
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// addressTypes are the values the API accepts for an address's type
+var addressTypes = []string{"HOME", "WORK", "OTHER"}
+
+// Fake is a client.API holding users and addresses in memory. It answers with the same errors as the API for missing
+// records, out of range pages and missing fields, but does not check the API's format rules or roles
+type Fake struct {
+	//Err, when set, is returned by every call instead of its result
+	Err error
+
+	mu        sync.Mutex
+	users     []models.User
+	addresses []models.Address
+}
+
+var _ client.API = (*Fake)(nil)
+
+// NewFake returns a fake holding users and addresses
+func NewFake(users []models.User, addresses []models.Address) *Fake {
+	return &Fake{users: slices.Clone(users), addresses: slices.Clone(addresses)}
+}
+
+func (f *Fake) ListUsers(_ context.Context, opts client.ListOptions) (client.Page[models.User], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return client.Page[models.User]{}, f.Err
+	}
+	return page(f.users, opts)
+}
+
+func (f *Fake) Users(opts client.ListOptions) *client.Iterator[models.User] {
+	return client.NewIterator(opts, f.ListUsers)
+}
+
+func (f *Fake) GetUser(_ context.Context, id uuid.UUID) (models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return models.User{}, f.Err
+	}
+	i, err := f.findUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	return f.users[i], nil
+}
+
+func (f *Fake) CreateUser(_ context.Context, input client.UserInput) (models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return models.User{}, f.Err
+	}
+	if err := checkUser(input); err != nil {
+		return models.User{}, err
+	}
+	user := models.User{Id: uuid.New(), FirstName: input.FirstName, LastName: input.LastName}
+	f.users = append(f.users, user)
+	return user, nil
+}
+
+func (f *Fake) UpdateUser(_ context.Context, id uuid.UUID, input client.UserInput) (models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return models.User{}, f.Err
+	}
+	if err := checkUser(input); err != nil {
+		return models.User{}, err
+	}
+	i, err := f.findUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	f.users[i].FirstName, f.users[i].LastName = input.FirstName, input.LastName
+	return f.users[i], nil
+}
+
+func (f *Fake) DeleteUser(_ context.Context, id uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	i, err := f.findUser(id)
+	if err != nil {
+		return err
+	}
+	f.users = slices.Delete(f.users, i, i+1)
+	f.addresses = slices.DeleteFunc(f.addresses, func(addr models.Address) bool { return addr.UserId == id })
+	return nil
+}
+
+func (f *Fake) ListUserAddresses(_ context.Context, userId uuid.UUID, opts client.ListOptions) (client.Page[models.Address], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return client.Page[models.Address]{}, f.Err
+	}
+	if _, err := f.findUser(userId); err != nil {
+		return client.Page[models.Address]{}, err
+	}
+	var addrs []models.Address
+	for _, addr := range f.addresses {
+		if addr.UserId == userId {
+			addrs = append(addrs, addr)
+		}
+	}
+	return page(addrs, opts)
+}
+
+func (f *Fake) UserAddresses(userId uuid.UUID, opts client.ListOptions) *client.Iterator[models.Address] {
+	return client.NewIterator(opts, func(ctx context.Context, opts client.ListOptions) (client.Page[models.Address], error) {
+		return f.ListUserAddresses(ctx, userId, opts)
+	})
+}
+
+func (f *Fake) ListAddresses(_ context.Context, opts client.ListOptions) (client.Page[models.Address], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return client.Page[models.Address]{}, f.Err
+	}
+	return page(f.addresses, opts)
+}
+
+func (f *Fake) Addresses(opts client.ListOptions) *client.Iterator[models.Address] {
+	return client.NewIterator(opts, f.ListAddresses)
+}
+
+func (f *Fake) GetAddress(_ context.Context, id uuid.UUID) (models.Address, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return models.Address{}, f.Err
+	}
+	i, err := f.findAddress(id)
+	if err != nil {
+		return models.Address{}, err
+	}
+	return f.addresses[i], nil
+}
+
+func (f *Fake) CreateAddress(_ context.Context, input client.AddressInput) (models.Address, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return models.Address{}, f.Err
+	}
+	if err := checkAddress(input); err != nil {
+		return models.Address{}, err
+	}
+	if _, err := f.findUser(input.UserId); err != nil {
+		return models.Address{}, err
+	}
+	addr := address(uuid.New(), input)
+	f.addresses = append(f.addresses, addr)
+	return addr, nil
+}
+
+func (f *Fake) UpdateAddress(_ context.Context, id uuid.UUID, input client.AddressInput) (models.Address, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return models.Address{}, f.Err
+	}
+	if err := checkAddress(input); err != nil {
+		return models.Address{}, err
+	}
+	if _, err := f.findUser(input.UserId); err != nil {
+		return models.Address{}, err
+	}
+	i, err := f.findAddress(id)
+	if err != nil {
+		return models.Address{}, err
+	}
+	f.addresses[i] = address(id, input)
+	return f.addresses[i], nil
+}
+
+func (f *Fake) DeleteAddress(_ context.Context, id uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	i, err := f.findAddress(id)
+	if err != nil {
+		return err
+	}
+	f.addresses = slices.Delete(f.addresses, i, i+1)
+	return nil
+}
+
+func (f *Fake) findUser(id uuid.UUID) (int, error) {
+	i := slices.IndexFunc(f.users, func(user models.User) bool { return user.Id == id })
+	if i < 0 {
+		return 0, notFound(client.CodeUserNotFound, "User not found", "user", id)
+	}
+	return i, nil
+}
+
+func (f *Fake) findAddress(id uuid.UUID) (int, error) {
+	i := slices.IndexFunc(f.addresses, func(addr models.Address) bool { return addr.Id == id })
+	if i < 0 {
+		return 0, notFound(client.CodeAddressNotFound, "Address not found", "address", id)
+	}
+	return i, nil
+}
+
+func address(id uuid.UUID, input client.AddressInput) models.Address {
+	return models.Address{
+		Id:     id,
+		UserId: input.UserId,
+		Street: input.Street,
+		City:   input.City,
+		State:  input.State,
+		Zip:    input.Zip,
+		Type:   input.Type,
+	}
+}
+
+// page cuts the page opts picks from records, as the API's list routes do
+func page[T any](records []T, opts client.ListOptions) (client.Page[T], error) {
+	limit := opts.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit < 1 || limit > maxPageSize {
+		return client.Page[T]{}, invalidQuery(fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+	}
+	if opts.Offset < 0 {
+		return client.Page[T]{}, invalidQuery("offset cannot be negative")
+	}
+	start := min(opts.Offset, len(records))
+	end := min(start+limit, len(records))
+	return client.Page[T]{Items: slices.Clone(records[start:end]), Limit: limit, Offset: opts.Offset, Total: len(records)}, nil
+}
+
+func checkUser(input client.UserInput) error {
+	var fieldErrs []client.FieldError
+	fieldErrs = required(fieldErrs, "firstName", input.FirstName)
+	fieldErrs = required(fieldErrs, "lastName", input.LastName)
+	return invalidBody(fieldErrs)
+}
+
+func checkAddress(input client.AddressInput) error {
+	var fieldErrs []client.FieldError
+	if input.UserId == uuid.Nil {
+		fieldErrs = append(fieldErrs, client.FieldError{Field: "userId", Code: "required", Message: "is required"})
+	}
+	fieldErrs = required(fieldErrs, "street", input.Street)
+	fieldErrs = required(fieldErrs, "city", input.City)
+	fieldErrs = required(fieldErrs, "state", input.State)
+	fieldErrs = required(fieldErrs, "zip", input.Zip)
+	fieldErrs = required(fieldErrs, "type", input.Type)
+	if input.Type != "" && !slices.Contains(addressTypes, input.Type) {
+		fieldErrs = append(fieldErrs, client.FieldError{Field: "type", Code: "oneof", Message: "must be one of " + strings.Join(addressTypes, ", ")})
+	}
+	return invalidBody(fieldErrs)
+}
+
+func required(fieldErrs []client.FieldError, field string, value string) []client.FieldError {
+	if strings.TrimSpace(value) == "" {
+		return append(fieldErrs, client.FieldError{Field: field, Code: "required", Message: "is required"})
+	}
+	return fieldErrs
+}
+
+func invalidBody(fieldErrs []client.FieldError) error {
+	if len(fieldErrs) == 0 {
+		return nil
+	}
+	return problem(http.StatusBadRequest, client.CodeInvalidRequestBody, "Invalid request body",
+		"Request body is malformed or failed validation", fieldErrs)
+}
+
+func invalidQuery(detail string) error {
+	return problem(http.StatusBadRequest, client.CodeInvalidQuery, "Invalid query parameter", detail, nil)
+}
+
+func notFound(code string, title string, resource string, id uuid.UUID) error {
+	return problem(http.StatusNotFound, code, title, fmt.Sprintf("No %s exists with Id [%s]", resource, id), nil)
+}
+
+// problem is the error the API answers with for code
+func problem(status int, code string, title string, detail string, fieldErrs []client.FieldError) *client.Error {
+	return &client.Error{
+		Type:   "/problems/" + strings.ReplaceAll(strings.ToLower(code), "_", "-"),
+		Title:  title,
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		Errors: fieldErrs,
+	}
+}
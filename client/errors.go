@@ -0,0 +1,90 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Problem codes the user and address routes answer with, found in Error.Code
+const (
+	CodeInvalidId            = "INVALID_ID"
+	CodeInvalidRequestBody   = "INVALID_REQUEST_BODY"
+	CodeInvalidQuery         = "INVALID_QUERY_PARAMETER"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeForbidden            = "FORBIDDEN"
+	CodeUserNotFound         = "USER_NOT_FOUND"
+	CodeAddressNotFound      = "ADDRESS_NOT_FOUND"
+	CodeConflict             = "CONFLICT"
+	CodeIdempotencyKeyInUse  = "IDEMPOTENCY_KEY_IN_USE"
+	CodeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeQuotaExceeded        = "QUOTA_EXCEEDED"
+	CodeInternal             = "INTERNAL_ERROR"
+	CodeUnavailable          = "SERVICE_UNAVAILABLE"
+)
+
+// Errors matched by errors.Is against an *Error of the corresponding status
+var (
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrForbidden      = errors.New("forbidden")
+	ErrNotFound       = errors.New("not found")
+	ErrConflict       = errors.New("conflict")
+	ErrRateLimited    = errors.New("rate limited")
+	ErrServer         = errors.New("server error")
+)
+
+// FieldError describes one field of a request body that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is a call the API answered with an error status, decoded from its RFC 7807 problem details
+type Error struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance"`
+	Code      string       `json:"code"`
+	RequestId string       `json:"requestId"`
+	Errors    []FieldError `json:"errors"`
+	//RetryAfter is how long the API asked the client to wait before trying again, zero when it did not say
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("%d %s", e.Status, e.Title)
+	if e.Code != "" {
+		message += " (" + e.Code + ")"
+	}
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	return message
+}
+
+// Is matches the sentinel error for the status of e, so callers can check errors.Is(err, client.ErrNotFound)
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrInvalidRequest:
+		return e.Status == http.StatusBadRequest || e.Status == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict
+	case ErrRateLimited:
+		return e.Status == http.StatusTooManyRequests
+	case ErrServer:
+		return e.Status >= http.StatusInternalServerError
+	}
+	return false
+}
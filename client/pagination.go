@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// ListOptions picks the page of a list to return
+type ListOptions struct {
+	//Limit is the number of records per page, at most 100. Zero leaves it to the API, which returns 50
+	Limit int
+	//Offset is the number of records to skip
+	Offset int
+}
+
+func (o ListOptions) query() url.Values {
+	query := url.Values{}
+	if o.Limit != 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset != 0 {
+		query.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return query
+}
+
+// Page is one page of a list along with where it sits in the whole list
+type Page[T any] struct {
+	Items  []T
+	Limit  int
+	Offset int
+	//Total is the number of records in the whole list
+	Total int
+}
+
+// pageBody is the envelope the /v2 routes return lists in
+type pageBody[T any] struct {
+	Data       []T `json:"data"`
+	Pagination struct {
+		Limit  int `json:"limit"`
+		Offset int `json:"offset"`
+		Total  int `json:"total"`
+	} `json:"pagination"`
+}
+
+func (b pageBody[T]) page() Page[T] {
+	return Page[T]{Items: b.Data, Limit: b.Pagination.Limit, Offset: b.Pagination.Offset, Total: b.Pagination.Total}
+}
+
+// Iterator walks every record of a list one page at a time:
+//
+//	it := c.Users(client.ListOptions{})
+//	for it.Next(ctx) {
+//		user := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+type Iterator[T any] struct {
+	list    func(ctx context.Context, opts ListOptions) (Page[T], error)
+	opts    ListOptions
+	items   []T
+	current T
+	done    bool
+	err     error
+}
+
+// NewIterator returns an iterator over the pages list returns, starting at the page opts picks
+func NewIterator[T any](opts ListOptions, list func(ctx context.Context, opts ListOptions) (Page[T], error)) *Iterator[T] {
+	return &Iterator[T]{list: list, opts: opts}
+}
+
+// Next advances to the next record, fetching the next page when the current one is used up. It returns false at the
+// end of the list or when fetching a page failed, which Err then reports
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	for len(it.items) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.list(ctx, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.items = page.Items
+		it.opts.Offset = page.Offset + len(page.Items)
+		it.done = len(page.Items) == 0 || it.opts.Offset >= page.Total
+	}
+	it.current, it.items = it.items[0], it.items[1:]
+	return true
+}
+
+// Value is the record Next advanced to
+func (it *Iterator[T]) Value() T {
+	return it.current
+}
+
+// Err is the error that stopped the iteration, nil when it reached the end of the list
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
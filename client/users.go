@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// UserInput holds the fields of a user to create or update
+type UserInput struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+// ListUsers returns a page of users
+func (c *Client) ListUsers(ctx context.Context, opts ListOptions) (Page[models.User], error) {
+	var body pageBody[models.User]
+	if err := c.call(ctx, http.MethodGet, apiPrefix+"/users/", opts.query(), nil, &body); err != nil {
+		return Page[models.User]{}, err
+	}
+	return body.page(), nil
+}
+
+// Users iterates over every user, starting at the page opts picks
+func (c *Client) Users(opts ListOptions) *Iterator[models.User] {
+	return NewIterator(opts, c.ListUsers)
+}
+
+// GetUser returns the user with id
+func (c *Client) GetUser(ctx context.Context, id uuid.UUID) (models.User, error) {
+	var user models.User
+	err := c.call(ctx, http.MethodGet, idPath("/users/", id), nil, nil, &user)
+	return user, err
+}
+
+// CreateUser stores a new user and returns it with its id
+func (c *Client) CreateUser(ctx context.Context, input UserInput) (models.User, error) {
+	var user models.User
+	err := c.call(ctx, http.MethodPost, apiPrefix+"/users/", nil, input, &user)
+	return user, err
+}
+
+// UpdateUser replaces the fields of the user with id
+func (c *Client) UpdateUser(ctx context.Context, id uuid.UUID, input UserInput) (models.User, error) {
+	var user models.User
+	err := c.call(ctx, http.MethodPut, idPath("/users/", id), nil, input, &user)
+	return user, err
+}
+
+// DeleteUser deletes the user with id along with its addresses. It needs the admin role
+func (c *Client) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return c.call(ctx, http.MethodDelete, idPath("/users/", id), nil, nil, nil)
+}
+
+// ListUserAddresses returns a page of the addresses of the user with userId
+func (c *Client) ListUserAddresses(ctx context.Context, userId uuid.UUID, opts ListOptions) (Page[models.Address], error) {
+	var body pageBody[models.Address]
+	if err := c.call(ctx, http.MethodGet, idPath("/users/", userId)+"/addresses", opts.query(), nil, &body); err != nil {
+		return Page[models.Address]{}, err
+	}
+	return body.page(), nil
+}
+
+// UserAddresses iterates over every address of the user with userId, starting at the page opts picks
+func (c *Client) UserAddresses(userId uuid.UUID, opts ListOptions) *Iterator[models.Address] {
+	return NewIterator(opts, func(ctx context.Context, opts ListOptions) (Page[models.Address], error) {
+		return c.ListUserAddresses(ctx, userId, opts)
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lengebretsen/go-practice/client"
+	"github.com/spf13/cobra"
+)
+
+func newAddressesCommand(a *app) *cobra.Command {
+	addresses := &cobra.Command{
+		Use:     "addresses",
+		Aliases: []string{"address", "addr"},
+		Short:   "List, show, create, update and delete addresses",
+	}
+
+	var list listFlags
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List addresses",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			records, err := collect(cmd, list, api.Addresses)
+			if err != nil {
+				return err
+			}
+			return printList(a, addressTable, records)
+		},
+	}
+	list.register(listCmd)
+
+	getCmd := &cobra.Command{
+		Use:   "get ID",
+		Short: "Show an address",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId(args[0])
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			addr, err := api.GetAddress(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			return printOne(a, addressTable, addr)
+		},
+	}
+
+	var input addressFlags
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create an address for an existing user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			body, err := input.merge(cmd, client.AddressInput{})
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			addr, err := api.CreateAddress(cmd.Context(), body)
+			if err != nil {
+				return err
+			}
+			return printOne(a, addressTable, addr)
+		},
+	}
+	input.register(createCmd)
+	for _, name := range []string{"user-id", "street", "city", "state", "zip", "type"} {
+		createCmd.MarkFlagRequired(name)
+	}
+
+	var update addressFlags
+	updateCmd := &cobra.Command{
+		Use:   "update ID",
+		Short: "Update an address, keeping the fields whose flags are not set",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId(args[0])
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			current, err := api.GetAddress(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			body, err := update.merge(cmd, client.AddressInput{
+				UserId: current.UserId,
+				Street: current.Street,
+				City:   current.City,
+				State:  current.State,
+				Zip:    current.Zip,
+				Type:   current.Type,
+			})
+			if err != nil {
+				return err
+			}
+			addr, err := api.UpdateAddress(cmd.Context(), id, body)
+			if err != nil {
+				return err
+			}
+			return printOne(a, addressTable, addr)
+		},
+	}
+	update.register(updateCmd)
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete ID...",
+		Short: "Delete addresses",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids, err := parseIds(args)
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				if err := api.DeleteAddress(cmd.Context(), id); err != nil {
+					return err
+				}
+				fmt.Fprintf(a.out, "deleted address %s\n", id)
+			}
+			return nil
+		},
+	}
+
+	addresses.AddCommand(listCmd, getCmd, createCmd, updateCmd, deleteCmd)
+	return addresses
+}
+
+// addressFlags are the fields of an address given on the command line
+type addressFlags struct {
+	userId string
+	street string
+	city   string
+	state  string
+	zip    string
+	kind   string
+}
+
+func (f *addressFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.userId, "user-id", "", "id of the user the address belongs to")
+	cmd.Flags().StringVar(&f.street, "street", "", "street")
+	cmd.Flags().StringVar(&f.city, "city", "", "city")
+	cmd.Flags().StringVar(&f.state, "state", "", "state")
+	cmd.Flags().StringVar(&f.zip, "zip", "", "zip code")
+	cmd.Flags().StringVar(&f.kind, "type", "", "type: HOME, WORK or OTHER")
+}
+
+// merge sets the fields of input whose flags were given
+func (f *addressFlags) merge(cmd *cobra.Command, input client.AddressInput) (client.AddressInput, error) {
+	changed := cmd.Flags().Changed
+	if changed("user-id") {
+		id, err := parseId(f.userId)
+		if err != nil {
+			return input, err
+		}
+		input.UserId = id
+	}
+	for _, field := range []struct {
+		flag  string
+		value string
+		dest  *string
+	}{
+		{"street", f.street, &input.Street},
+		{"city", f.city, &input.City},
+		{"state", f.state, &input.State},
+		{"zip", f.zip, &input.Zip},
+		{"type", f.kind, &input.Type},
+	} {
+		if changed(field.flag) {
+			*field.dest = field.value
+		}
+	}
+	return input, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultProfile is used when neither the flags, the environment nor the file pick one
+const defaultProfile = "default"
+
+// defaultURL is where a profile without a url, or a missing profile file, points
+const defaultURL = "http://localhost:8080"
+
+// profile is how to reach one environment's API
+type profile struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"apiKey,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+	//Timeout and MaxRetries are left to the client's defaults when zero
+	Timeout    time.Duration `yaml:"timeout,omitempty"`
+	MaxRetries int           `yaml:"maxRetries,omitempty"`
+}
+
+// config is the profiles file, e.g.
+//
+//	current: staging
+//	profiles:
+//	  staging:
+//	    url: https://staging.example.com
+//	    apiKey: ...
+type config struct {
+	Current  string             `yaml:"current,omitempty"`
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// configFile is the profiles file the flags or environment point at, or the one in the user config directory
+func (a *app) configFile() string {
+	if a.configPath != "" {
+		return a.configPath
+	}
+	if path := os.Getenv("GPCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "gpctl.yml"
+	}
+	return filepath.Join(dir, "gpctl", "config.yml")
+}
+
+// selectedProfile is the profile named by the flags, the environment or the file, in that order
+func (a *app) selectedProfile(cfg config) string {
+	switch {
+	case a.profileName != "":
+		return a.profileName
+	case os.Getenv("GPCTL_PROFILE") != "":
+		return os.Getenv("GPCTL_PROFILE")
+	case cfg.Current != "":
+		return cfg.Current
+	default:
+		return defaultProfile
+	}
+}
+
+// loadConfig reads the profiles file at path, a missing file holding no profiles
+func loadConfig(path string) (config, error) {
+	cfg := config{Profiles: map[string]profile{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("reading profiles: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("reading profiles from %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]profile{}
+	}
+	return cfg, nil
+}
+
+// save writes the profiles file, readable only by its owner as it holds credentials
+func (cfg config) save(path string) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("saving profiles: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("saving profiles: %w", err)
+	}
+	return nil
+}
+
+// profile returns the profile called name. The default profile need not exist, pointing at a local server
+func (cfg config) profile(name string) (profile, error) {
+	p, ok := cfg.Profiles[name]
+	if !ok && name != defaultProfile {
+		return profile{}, fmt.Errorf("no profile named [%s], add it with gpctl profiles set", name)
+	}
+	if p.URL == "" {
+		p.URL = defaultURL
+	}
+	return p, nil
+}
+
+// names lists the profiles in alphabetical order
+func (cfg config) names() []string {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,96 @@
+// Command gpctl manages the users and addresses of a go-practice API from the command line
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lengebretsen/go-practice/client"
+	"github.com/spf13/cobra"
+)
+
+// app holds the global flags and what the commands write to
+type app struct {
+	configPath  string
+	profileName string
+	output      string
+	url         string
+	apiKey      string
+	token       string
+
+	out io.Writer
+	//newAPI builds the client for the selected profile, tests replace it with a fake
+	newAPI func() (client.API, error)
+}
+
+func main() {
+	a := &app{out: os.Stdout}
+	a.newAPI = a.profileClient
+	if err := a.rootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func (a *app) rootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:          "gpctl",
+		Short:        "Manage the users and addresses of a go-practice API",
+		SilenceUsage: true,
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			switch a.output {
+			case outputTable, outputJSON, outputYAML:
+				return nil
+			}
+			return fmt.Errorf("unknown output format [%s], use %s, %s or %s", a.output, outputTable, outputJSON, outputYAML)
+		},
+	}
+	root.SetOut(a.out)
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.configPath, "config", "", "profiles file (default $GPCTL_CONFIG or gpctl/config.yml in the user config directory)")
+	flags.StringVarP(&a.profileName, "profile", "p", "", "profile to use (default $GPCTL_PROFILE or the file's current profile)")
+	flags.StringVarP(&a.output, "output", "o", outputTable, "output format: table, json or yaml")
+	flags.StringVar(&a.url, "url", "", "base URL of the API, overriding the profile's")
+	flags.StringVar(&a.apiKey, "api-key", "", "API key, overriding the profile's")
+	flags.StringVar(&a.token, "token", "", "bearer token, overriding the profile's")
+
+	root.AddCommand(
+		newUsersCommand(a),
+		newAddressesCommand(a),
+		newImportCommand(a),
+		newExportCommand(a),
+		newProfilesCommand(a),
+	)
+	return root
+}
+
+// api builds the client for the selected profile
+func (a *app) api() (client.API, error) {
+	return a.newAPI()
+}
+
+// profileClient is the client for the selected profile with the URL and credential flags applied over it
+func (a *app) profileClient() (client.API, error) {
+	cfg, err := loadConfig(a.configFile())
+	if err != nil {
+		return nil, err
+	}
+	p, err := cfg.profile(a.selectedProfile(cfg))
+	if err != nil {
+		return nil, err
+	}
+	if a.url != "" {
+		p.URL = a.url
+	}
+	if a.apiKey != "" {
+		p.APIKey = a.apiKey
+	}
+	if a.token != "" {
+		p.Token = a.token
+	}
+	c, err := client.New(client.Config{BaseURL: p.URL, APIKey: p.APIKey, Token: p.Token, Timeout: p.Timeout, MaxRetries: p.MaxRetries})
+	if err != nil {
+		return nil, fmt.Errorf("profile [%s]: %w", a.selectedProfile(cfg), err)
+	}
+	return c, nil
+}
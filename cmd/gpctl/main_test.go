@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/client"
+	"github.com/lengebretsen/go-practice/client/clienttest"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+// run executes gpctl with args against api, returning what it printed
+func run(t *testing.T, api client.API, args ...string) (string, error) {
+	var out bytes.Buffer
+	a := &app{out: &out, newAPI: func() (client.API, error) { return api, nil }}
+	root := a.rootCommand()
+	root.SetArgs(args)
+	root.SetErr(&bytes.Buffer{})
+	err := root.Execute()
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	pat := models.User{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "Smith"}
+	sam := models.User{Id: uuid.MustParse("a3b2c1d0-0000-4000-8000-000000000001"), FirstName: "Sam", LastName: "Jones"}
+	home := models.Address{Id: uuid.MustParse("4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"), UserId: pat.Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"}
+
+	type test struct {
+		args        []string
+		wantedOut   string
+		wantedError string
+	}
+
+	tests := []test{
+		{args: []string{"users", "list"}, wantedOut: "" +
+			"ID                                    FIRST NAME  LAST NAME\n" +
+			"493adb28-9da1-4db8-893d-73cc2d7bd4ee  Pat         Smith\n" +
+			"a3b2c1d0-0000-4000-8000-000000000001  Sam         Jones\n"},
+		{args: []string{"users", "list", "--limit", "1", "--offset", "1", "-o", "json"}, wantedOut: `[
+  {
+    "id": "a3b2c1d0-0000-4000-8000-000000000001",
+    "firstName": "Sam",
+    "lastName": "Jones"
+  }
+]
+`},
+		{args: []string{"addresses", "get", home.Id.String(), "-o", "yaml"}, wantedOut: `id: 4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11
+userId: 493adb28-9da1-4db8-893d-73cc2d7bd4ee
+street: 1 Main St
+city: Boise
+state: ID
+zip: "83702"
+type: HOME
+`},
+		//fields without flags keep their current values
+		{args: []string{"users", "update", sam.Id.String(), "--last-name", "Lee", "-o", "yaml"},
+			wantedOut: "id: a3b2c1d0-0000-4000-8000-000000000001\nfirstName: Sam\nlastName: Lee\n"},
+		{args: []string{"addresses", "update", home.Id.String(), "--type", "WORK", "-o", "json"}, wantedOut: `{
+  "id": "4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11",
+  "userId": "493adb28-9da1-4db8-893d-73cc2d7bd4ee",
+  "street": "1 Main St",
+  "city": "Boise",
+  "state": "ID",
+  "zip": "83702",
+  "type": "WORK"
+}
+`},
+		{args: []string{"users", "addresses", sam.Id.String(), "-o", "json"}, wantedOut: "[]\n"},
+		{args: []string{"users", "delete", pat.Id.String()}, wantedOut: "deleted user 493adb28-9da1-4db8-893d-73cc2d7bd4ee\n"},
+		{args: []string{"users", "get", uuid.Nil.String()}, wantedError: "404 User not found (USER_NOT_FOUND): No user exists with Id [00000000-0000-0000-0000-000000000000]"},
+		{args: []string{"users", "get", "pat"}, wantedError: "[pat] is not a valid id: invalid UUID length: 3"},
+		{args: []string{"users", "create", "--first-name", "Pat"}, wantedError: `required flag(s) "last-name" not set`},
+		{args: []string{"users", "list", "-o", "xml"}, wantedError: "unknown output format [xml], use table, json or yaml"},
+	}
+
+	for _, testCase := range tests {
+		fake := clienttest.NewFake([]models.User{pat, sam}, []models.Address{home})
+		out, err := run(t, fake, testCase.args...)
+		if testCase.wantedError != "" {
+			assert.Equal(t, err.Error(), testCase.wantedError)
+			continue
+		}
+		assert.Equal(t, err, nil)
+		assert.Equal(t, out, testCase.wantedOut)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	pat := models.User{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "Smith"}
+	home := models.Address{Id: uuid.MustParse("4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"), UserId: pat.Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"}
+
+	for _, name := range []string{"dump.json", "dump.yaml"} {
+		path := filepath.Join(t.TempDir(), name)
+		out, err := run(t, clienttest.NewFake([]models.User{pat}, []models.Address{home}), "export", path)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, out, "exported 1 users and 1 addresses to "+path+"\n")
+
+		target := clienttest.NewFake(nil, nil)
+		out, err = run(t, target, "import", path)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, out, "imported 1 of 1 users and 1 of 1 addresses\n")
+
+		//the imported address belongs to the user created for the one in the file
+		users, _ := target.ListUsers(context.Background(), client.ListOptions{})
+		addrs, _ := target.ListAddresses(context.Background(), client.ListOptions{})
+		assert.Equal(t, len(users.Items), 1)
+		assert.Equal(t, users.Items[0].LastName, "Smith")
+		assert.Equal(t, addrs.Items[0].UserId, users.Items[0].Id)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gpctl", "config.yml")
+
+	_, err := run(t, nil, "--config", path, "profiles", "set", "local", "--url", "http://localhost:8080")
+	assert.Equal(t, err, nil)
+	_, err = run(t, nil, "--config", path, "profiles", "set", "staging", "--url", "https://staging.example.com", "--api-key", "secret", "--timeout", "10s")
+	assert.Equal(t, err, nil)
+	out, err := run(t, nil, "--config", path, "profiles", "use", "staging")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, out, "using profile staging\n")
+
+	out, err = run(t, nil, "--config", path, "profiles", "list")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, out, ""+
+		"CURRENT  NAME     URL                          AUTH\n"+
+		"         local    http://localhost:8080        none\n"+
+		"*        staging  https://staging.example.com  api key\n")
+
+	info, err := os.Stat(path)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+
+	//the selected profile, with the global flags over it, configures the client
+	a := &app{configPath: path, profileName: "local", apiKey: "override"}
+	_, err = a.profileClient()
+	assert.Equal(t, err, nil)
+	a.profileName = "production"
+	_, err = a.profileClient()
+	assert.Equal(t, err.Error(), "no profile named [production], add it with gpctl profiles set")
+}
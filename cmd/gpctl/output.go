@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lengebretsen/go-practice/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// table is how a kind of record is printed as a table
+type table[T any] struct {
+	headers []string
+	row     func(T) []string
+}
+
+var userTable = table[models.User]{
+	headers: []string{"ID", "FIRST NAME", "LAST NAME"},
+	row: func(user models.User) []string {
+		return []string{user.Id.String(), user.FirstName, user.LastName}
+	},
+}
+
+var addressTable = table[models.Address]{
+	headers: []string{"ID", "USER ID", "STREET", "CITY", "STATE", "ZIP", "TYPE"},
+	row: func(addr models.Address) []string {
+		return []string{addr.Id.String(), addr.UserId.String(), addr.Street, addr.City, addr.State, addr.Zip, addr.Type}
+	},
+}
+
+// printList writes records in the format the --output flag picks
+func printList[T any](a *app, t table[T], records []T) error {
+	if a.output != outputTable {
+		//lists are never null, so an empty result prints as []
+		return printDocument(a.out, a.output, append([]T{}, records...))
+	}
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(t.headers, "\t"))
+	for _, record := range records {
+		fmt.Fprintln(w, strings.Join(t.row(record), "\t"))
+	}
+	return w.Flush()
+}
+
+// printOne writes a single record in the format the --output flag picks
+func printOne[T any](a *app, t table[T], record T) error {
+	if a.output != outputTable {
+		return printDocument(a.out, a.output, record)
+	}
+	return printList(a, t, []T{record})
+}
+
+// printDocument writes value as JSON or YAML. YAML is converted from the JSON representation, so field names match
+// the API's
+func printDocument(w io.Writer, format string, value any) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case outputYAML:
+		doc, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		var node yaml.Node
+		if err := yaml.Unmarshal(doc, &node); err != nil {
+			return err
+		}
+		blockStyle(&node)
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		return fmt.Errorf("unknown output format [%s], use %s, %s or %s", format, outputTable, outputJSON, outputYAML)
+	}
+}
+
+// blockStyle clears the flow and quoting styles a node picks up from being parsed as JSON, leaving the encoder to
+// quote only the values that need it
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
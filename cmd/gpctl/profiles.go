@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newProfilesCommand(a *app) *cobra.Command {
+	profiles := &cobra.Command{
+		Use:     "profiles",
+		Aliases: []string{"profile"},
+		Short:   "Manage the profiles, one per environment, kept in the profiles file",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List profiles, marking the one in use",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(a.configFile())
+			if err != nil {
+				return err
+			}
+			selected := a.selectedProfile(cfg)
+			w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "CURRENT\tNAME\tURL\tAUTH")
+			for _, name := range cfg.names() {
+				p := cfg.Profiles[name]
+				current := ""
+				if name == selected {
+					current = "*"
+				}
+				auth := "none"
+				switch {
+				case p.Token != "":
+					auth = "token"
+				case p.APIKey != "":
+					auth = "api key"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", current, name, p.URL, auth)
+			}
+			return w.Flush()
+		},
+	}
+
+	var p profile
+	setCmd := &cobra.Command{
+		Use:   "set NAME",
+		Short: "Create a profile or change the settings whose flags are given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := a.configFile()
+			cfg, err := loadConfig(path)
+			if err != nil {
+				return err
+			}
+			existing := cfg.Profiles[args[0]]
+			changed := cmd.Flags().Changed
+			if changed("url") {
+				existing.URL = p.URL
+			}
+			if changed("api-key") {
+				existing.APIKey = p.APIKey
+			}
+			if changed("token") {
+				existing.Token = p.Token
+			}
+			if changed("timeout") {
+				existing.Timeout = p.Timeout
+			}
+			if changed("max-retries") {
+				existing.MaxRetries = p.MaxRetries
+			}
+			cfg.Profiles[args[0]] = existing
+			if cfg.Current == "" {
+				cfg.Current = args[0]
+			}
+			if err := cfg.save(path); err != nil {
+				return err
+			}
+			fmt.Fprintf(a.out, "saved profile %s to %s\n", args[0], path)
+			return nil
+		},
+	}
+	//the profile's own flags shadow the global overrides of the same names
+	setCmd.Flags().StringVar(&p.URL, "url", "", "base URL of the API, e.g. https://api.example.com")
+	setCmd.Flags().StringVar(&p.APIKey, "api-key", "", "API key to authenticate with")
+	setCmd.Flags().StringVar(&p.Token, "token", "", "bearer token to authenticate with")
+	setCmd.Flags().DurationVar(&p.Timeout, "timeout", 0, "timeout of each request, e.g. 10s")
+	setCmd.Flags().IntVar(&p.MaxRetries, "max-retries", 0, "retries of requests that fail with a 429 or 5xx, -1 to disable")
+
+	useCmd := &cobra.Command{
+		Use:   "use NAME",
+		Short: "Make NAME the profile used when --profile is not given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := a.configFile()
+			cfg, err := loadConfig(path)
+			if err != nil {
+				return err
+			}
+			if _, ok := cfg.Profiles[args[0]]; !ok {
+				return fmt.Errorf("no profile named [%s]", args[0])
+			}
+			cfg.Current = args[0]
+			if err := cfg.save(path); err != nil {
+				return err
+			}
+			fmt.Fprintf(a.out, "using profile %s\n", args[0])
+			return nil
+		},
+	}
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete NAME",
+		Short: "Remove a profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := a.configFile()
+			cfg, err := loadConfig(path)
+			if err != nil {
+				return err
+			}
+			if _, ok := cfg.Profiles[args[0]]; !ok {
+				return fmt.Errorf("no profile named [%s]", args[0])
+			}
+			delete(cfg.Profiles, args[0])
+			if cfg.Current == args[0] {
+				cfg.Current = ""
+			}
+			if err := cfg.save(path); err != nil {
+				return err
+			}
+			fmt.Fprintf(a.out, "deleted profile %s\n", args[0])
+			return nil
+		},
+	}
+
+	profiles.AddCommand(listCmd, setCmd, useCmd, deleteCmd)
+	return profiles
+}
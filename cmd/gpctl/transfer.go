@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/client"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// dataset is the document export writes and import reads
+type dataset struct {
+	Users     []models.User    `json:"users"`
+	Addresses []models.Address `json:"addresses"`
+}
+
+func newExportCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "export [FILE]",
+		Short: "Write every user and address to FILE, or to stdout",
+		Long: "Write every user and address to FILE as JSON, or as YAML when FILE ends in .yaml or .yml. Without FILE " +
+			"the document goes to stdout in the --output format, JSON when that is table.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			data := dataset{Users: []models.User{}, Addresses: []models.Address{}}
+			if data.Users, err = collect(cmd, listFlags{}, api.Users); err != nil {
+				return err
+			}
+			if data.Addresses, err = collect(cmd, listFlags{}, api.Addresses); err != nil {
+				return err
+			}
+
+			if len(args) == 0 {
+				format := a.output
+				if format == outputTable {
+					format = outputJSON
+				}
+				return printDocument(a.out, format, data)
+			}
+			var doc bytes.Buffer
+			if err := printDocument(&doc, fileFormat(args[0]), data); err != nil {
+				return err
+			}
+			if err := os.WriteFile(args[0], doc.Bytes(), 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintf(a.out, "exported %d users and %d addresses to %s\n", len(data.Users), len(data.Addresses), args[0])
+			return nil
+		},
+	}
+}
+
+func newImportCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "import FILE",
+		Short: "Create the users and addresses in FILE, as written by export",
+		Long: "Create the users and addresses in FILE, JSON or YAML as written by export, with - reading stdin. The " +
+			"API assigns new ids, and addresses of users in the file are attached to the new users. Addresses may also " +
+			"refer to users that already exist. Records are created one at a time, so a failure leaves the ones before " +
+			"it in place.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := readDataset(args[0])
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			users, addrs, err := importDataset(cmd.Context(), api, data)
+			fmt.Fprintf(a.out, "imported %d of %d users and %d of %d addresses\n", users, len(data.Users), addrs, len(data.Addresses))
+			return err
+		},
+	}
+}
+
+// importDataset creates the records of data, returning how many users and addresses were created
+func importDataset(ctx context.Context, api client.API, data dataset) (int, int, error) {
+	newIds := map[uuid.UUID]uuid.UUID{}
+	for i, user := range data.Users {
+		created, err := api.CreateUser(ctx, client.UserInput{FirstName: user.FirstName, LastName: user.LastName})
+		if err != nil {
+			return i, 0, fmt.Errorf("users[%d]: %w", i, err)
+		}
+		newIds[user.Id] = created.Id
+	}
+	for i, addr := range data.Addresses {
+		userId, ok := newIds[addr.UserId]
+		if !ok {
+			userId = addr.UserId
+		}
+		_, err := api.CreateAddress(ctx, client.AddressInput{
+			UserId: userId,
+			Street: addr.Street,
+			City:   addr.City,
+			State:  addr.State,
+			Zip:    addr.Zip,
+			Type:   addr.Type,
+		})
+		if err != nil {
+			return len(data.Users), i, fmt.Errorf("addresses[%d]: %w", i, err)
+		}
+	}
+	return len(data.Users), len(data.Addresses), nil
+}
+
+// readDataset reads a JSON or YAML dataset from path, or from stdin when path is -
+func readDataset(path string) (dataset, error) {
+	var data dataset
+	var doc []byte
+	var err error
+	if path == "-" {
+		doc, err = io.ReadAll(os.Stdin)
+	} else {
+		doc, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return data, err
+	}
+
+	if fileFormat(path) == outputYAML {
+		//converted to JSON so ids and field names are read as the API writes them
+		var value any
+		if err := yaml.Unmarshal(doc, &value); err != nil {
+			return data, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if doc, err = json.Marshal(value); err != nil {
+			return data, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	if err := json.Unmarshal(doc, &data); err != nil {
+		return data, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// fileFormat is the format a file's extension names, JSON unless it is .yaml or .yml
+func fileFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return outputYAML
+	default:
+		return outputJSON
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/client"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/spf13/cobra"
+)
+
+func newUsersCommand(a *app) *cobra.Command {
+	users := &cobra.Command{
+		Use:     "users",
+		Aliases: []string{"user"},
+		Short:   "List, show, create, update and delete users",
+	}
+
+	var list listFlags
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			records, err := collect(cmd, list, api.Users)
+			if err != nil {
+				return err
+			}
+			return printList(a, userTable, records)
+		},
+	}
+	list.register(listCmd)
+
+	getCmd := &cobra.Command{
+		Use:   "get ID",
+		Short: "Show a user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId(args[0])
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			user, err := api.GetUser(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			return printOne(a, userTable, user)
+		},
+	}
+
+	var input client.UserInput
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			user, err := api.CreateUser(cmd.Context(), input)
+			if err != nil {
+				return err
+			}
+			return printOne(a, userTable, user)
+		},
+	}
+	userFlags(createCmd, &input)
+	createCmd.MarkFlagRequired("first-name")
+	createCmd.MarkFlagRequired("last-name")
+
+	var update client.UserInput
+	updateCmd := &cobra.Command{
+		Use:   "update ID",
+		Short: "Update a user, keeping the fields whose flags are not set",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId(args[0])
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			current, err := api.GetUser(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			merged := client.UserInput{FirstName: current.FirstName, LastName: current.LastName}
+			if cmd.Flags().Changed("first-name") {
+				merged.FirstName = update.FirstName
+			}
+			if cmd.Flags().Changed("last-name") {
+				merged.LastName = update.LastName
+			}
+			user, err := api.UpdateUser(cmd.Context(), id, merged)
+			if err != nil {
+				return err
+			}
+			return printOne(a, userTable, user)
+		},
+	}
+	userFlags(updateCmd, &update)
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete ID...",
+		Short: "Delete users along with their addresses",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids, err := parseIds(args)
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				if err := api.DeleteUser(cmd.Context(), id); err != nil {
+					return err
+				}
+				fmt.Fprintf(a.out, "deleted user %s\n", id)
+			}
+			return nil
+		},
+	}
+
+	var addressList listFlags
+	addressesCmd := &cobra.Command{
+		Use:   "addresses ID",
+		Short: "List a user's addresses",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId(args[0])
+			if err != nil {
+				return err
+			}
+			api, err := a.api()
+			if err != nil {
+				return err
+			}
+			records, err := collect(cmd, addressList, func(opts client.ListOptions) *client.Iterator[models.Address] {
+				return api.UserAddresses(id, opts)
+			})
+			if err != nil {
+				return err
+			}
+			return printList(a, addressTable, records)
+		},
+	}
+	addressList.register(addressesCmd)
+
+	users.AddCommand(listCmd, getCmd, createCmd, updateCmd, deleteCmd, addressesCmd)
+	return users
+}
+
+func userFlags(cmd *cobra.Command, input *client.UserInput) {
+	cmd.Flags().StringVar(&input.FirstName, "first-name", "", "first name")
+	cmd.Flags().StringVar(&input.LastName, "last-name", "", "last name")
+}
+
+// maxPageSize is the largest page the API returns
+const maxPageSize = 100
+
+// listFlags are the paging flags of the list commands
+type listFlags struct {
+	limit  int
+	offset int
+}
+
+func (f *listFlags) register(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&f.limit, "limit", 0, "list at most this many records, 0 lists them all")
+	cmd.Flags().IntVar(&f.offset, "offset", 0, "skip this many records")
+}
+
+// collect walks the iterator iterate returns, fetching pages of up to maxPageSize records, until it has f.limit
+// records or the list ends
+func collect[T any](cmd *cobra.Command, f listFlags, iterate func(opts client.ListOptions) *client.Iterator[T]) ([]T, error) {
+	pageSize := maxPageSize
+	if f.limit > 0 {
+		pageSize = min(f.limit, maxPageSize)
+	}
+	records := []T{}
+	it := iterate(client.ListOptions{Limit: pageSize, Offset: f.offset})
+	for (f.limit == 0 || len(records) < f.limit) && it.Next(cmd.Context()) {
+		records = append(records, it.Value())
+	}
+	return records, it.Err()
+}
+
+func parseId(value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("[%s] is not a valid id: %w", value, err)
+	}
+	return id, nil
+}
+
+func parseIds(values []string) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, len(values))
+	for i, value := range values {
+		var err error
+		if ids[i], err = parseId(value); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
@@ -1,37 +1,296 @@
 package conf
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-func setDefaultConfig() {
+// envPrefix starts the name of every environment variable that overrides a setting, e.g. GOPRACTICE_DATABASE_PASS
+// for database.pass
+const envPrefix = "GOPRACTICE"
+
+// fileSuffix ends the name of an environment variable holding the path of a file to read a setting from instead,
+// e.g. GOPRACTICE_DATABASE_PASS_FILE=/run/secrets/db_pass
+const fileSuffix = "_FILE"
+
+// flagKeys maps the flags RegisterFlags adds to the settings they override
+var flagKeys = map[string]string{
+	"host":       "server.host",
+	"port":       "server.port",
+	"debug":      "server.debug",
+	"grpc-host":  "grpc.host",
+	"grpc-port":  "grpc.port",
+	"db-host":    "database.host",
+	"db-port":    "database.port",
+	"db-name":    "database.name",
+	"db-user":    "database.user",
+	"log-level":  "log.level",
+	"log-format": "log.format",
+}
+
+func setDefaultConfig(v *viper.Viper) {
 	//Database
-	viper.SetDefault("database.name", "go-practice")
-	viper.SetDefault("database.user", "gousr")
-	viper.SetDefault("database.pass", "gopass")
-	viper.SetDefault("database.host", "localhost")
-	viper.SetDefault("database.port", "3306")
+	v.SetDefault("database.name", "go-practice")
+	v.SetDefault("database.user", "gousr")
+	v.SetDefault("database.pass", "gopass")
+	v.SetDefault("database.host", "localhost")
+	v.SetDefault("database.port", 3306)
+	v.SetDefault("database.socket", "")
+	v.SetDefault("database.charset", "utf8mb4")
+	v.SetDefault("database.collation", "utf8mb4_general_ci")
+	v.SetDefault("database.parseTime", true)
+	v.SetDefault("database.dialTimeout", "5s")
+	v.SetDefault("database.readTimeout", "30s")
+	v.SetDefault("database.writeTimeout", "30s")
+	v.SetDefault("database.maxOpenConns", 10)
+	v.SetDefault("database.maxIdleConns", 10)
+	v.SetDefault("database.connMaxLifetime", "3m")
+	v.SetDefault("database.connMaxIdleTime", "1m")
+	v.SetDefault("database.poolSaturationThreshold", 0.9)
+	v.SetDefault("database.tls.enabled", false)
+	v.SetDefault("database.tls.caFile", "")
+	v.SetDefault("database.tls.certFile", "")
+	v.SetDefault("database.tls.keyFile", "")
+	v.SetDefault("database.tls.serverName", "")
+	v.SetDefault("database.tls.skipVerify", false)
+	v.SetDefault("database.replicas", []string{})
+	v.SetDefault("database.healthCheckInterval", "5s")
+	v.SetDefault("database.pingTimeout", "1s")
+	v.SetDefault("database.connectAttempts", 10)
+	v.SetDefault("database.connectBackoff", "250ms")
+	v.SetDefault("database.connectMaxBackoff", "5s")
 
 	//Gin server
-	viper.SetDefault("server.host", "localhost")
-	viper.SetDefault("server.port", "8080")
+	v.SetDefault("server.host", "localhost")
+	v.SetDefault("server.port", 8080)
+	v.SetDefault("server.readinessTimeout", "2s")
+	v.SetDefault("server.shutdownDelay", "0s")
+	v.SetDefault("server.drainTimeout", "15s")
+	v.SetDefault("server.debug", false)
+
+	//Authentication
+	v.SetDefault("auth.enabled", true)
+	v.SetDefault("auth.bootstrapKey", "")
+	v.SetDefault("auth.lastUsedInterval", "1m")
+	v.SetDefault("auth.jwt.hmacSecrets", []map[string]string{})
+	v.SetDefault("auth.jwt.jwksFiles", []string{})
+	v.SetDefault("auth.jwt.issuer", "")
+	v.SetDefault("auth.jwt.audience", "")
+	v.SetDefault("auth.jwt.rolesClaim", "roles")
+	v.SetDefault("auth.jwt.roleMapping", map[string]string{})
+	v.SetDefault("auth.jwt.leeway", "30s")
+
+	//API versions
+	v.SetDefault("api.unversioned.deprecatedAt", "")
+	v.SetDefault("api.unversioned.sunset", "")
+	v.SetDefault("api.v1.deprecatedAt", "")
+	v.SetDefault("api.v1.sunset", "")
+
+	//Rate limiting
+	v.SetDefault("rateLimit.enabled", true)
+	v.SetDefault("rateLimit.rate", 10)
+	v.SetDefault("rateLimit.burst", 20)
+	v.SetDefault("rateLimit.routes", []map[string]any{})
+
+	//Quotas
+	v.SetDefault("quota.enabled", true)
+	v.SetDefault("quota.daily", 10000)
+	v.SetDefault("quota.flushInterval", "10s")
+
+	//Idempotency keys
+	v.SetDefault("idempotency.enabled", true)
+	v.SetDefault("idempotency.window", "24h")
+	v.SetDefault("idempotency.lockTimeout", "1m")
+	v.SetDefault("idempotency.maxBodyBytes", 1<<20)
+
+	//Batch requests
+	v.SetDefault("batch.maxOperations", 500)
+
+	//GraphQL
+	v.SetDefault("graphql.maxDepth", 10)
+
+	//gRPC server
+	v.SetDefault("grpc.enabled", true)
+	v.SetDefault("grpc.host", "localhost")
+	v.SetDefault("grpc.port", 9090)
+	v.SetDefault("grpc.reflection", true)
+
+	//Logging
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", "json")
+	v.SetDefault("log.output", "stdout")
+
+	//Tracing
+	v.SetDefault("tracing.exporter", "none")
+	v.SetDefault("tracing.file", "traces.jsonl")
+	v.SetDefault("tracing.serviceName", "go-practice")
+	v.SetDefault("tracing.sampleRatio", 1.0)
+}
+
+// RegisterFlags adds --config and the flags that override single settings to flags. Secrets have no flags, as
+// command lines are visible to every user of the machine
+func RegisterFlags(flags *pflag.FlagSet) {
+	flags.String("config", "", "config file to read (default ./config.yml, which may be missing)")
+	flags.String("host", "", "host the REST API listens on, overrides server.host")
+	flags.Int("port", 0, "port the REST API listens on, overrides server.port")
+	flags.Bool("debug", false, "include internal error text in 5xx problem details, overrides server.debug")
+	flags.String("grpc-host", "", "host the gRPC server listens on, overrides grpc.host")
+	flags.Int("grpc-port", 0, "port the gRPC server listens on, overrides grpc.port")
+	flags.String("db-host", "", "MySQL host, overrides database.host")
+	flags.Int("db-port", 0, "MySQL port, overrides database.port")
+	flags.String("db-name", "", "MySQL database, overrides database.name")
+	flags.String("db-user", "", "MySQL user, overrides database.user")
+	flags.String("log-level", "", "debug, info, warn or error, overrides log.level")
+	flags.String("log-format", "", "json or text, overrides log.format")
 }
 
-func LoadConfig() {
-	setDefaultConfig()
+// Load builds the config from, in increasing precedence, the defaults, the config file, GOPRACTICE_ environment
+// variables or the files their _FILE variants name, and the flags RegisterFlags added to flags, which may be nil.
+// Every problem found is reported in the one error
+func Load(flags *pflag.FlagSet) (Config, error) {
+	v := viper.New()
+	setDefaultConfig(v)
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
+	var path string
+	if flags != nil {
+		path, _ = flags.GetString("config")
+	}
+	if path != "" {
+		v.SetConfigFile(path)
+	} else {
+		v.SetConfigName("config")
+		v.SetConfigType("yaml")
+		v.AddConfigPath(".")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		//only the default file is optional, one asked for by --config must exist
+		var notFound viper.ConfigFileNotFoundError
+		if path != "" || !errors.As(err, &notFound) {
+			return Config{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+		slog.Info("No config file found, loading with default configs")
+	}
 
-	err := viper.ReadInConfig()
-	if err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			println("No config file found, loading with default configs")
-		} else {
-			panic(fmt.Errorf("fatal error reading config file: %w", err))
+	for name, key := range flagKeys {
+		if flag := lookupFlag(flags, name); flag != nil {
+			if err := v.BindPFlag(key, flag); err != nil {
+				return Config{}, err
+			}
 		}
 	}
+
+	problems := readSecretFiles(v, flags)
+	splitLists(v)
+	var cfg Config
+	if err := v.Unmarshal(&cfg, viper.DecodeHook(decodeHook)); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		problems = append(problems, cfg.validate()...)
+	}
+	if len(problems) > 0 {
+		return Config{}, errors.New("invalid config:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	cfg.settings = v.AllSettings()
+	return cfg, nil
 }
+
+// readSecretFiles sets each setting whose _FILE environment variable is set to the contents of the file it names,
+// less trailing line breaks. A flag given for the setting still wins
+func readSecretFiles(v *viper.Viper, flags *pflag.FlagSet) []string {
+	var problems []string
+	//sorted so problems are always reported in the same order
+	keys := v.AllKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		env := envName(key)
+		path := os.Getenv(env + fileSuffix)
+		if path == "" {
+			continue
+		}
+		if _, set := os.LookupEnv(env); set {
+			problems = append(problems, fmt.Sprintf("%s and %s are both set, use one", env, env+fileSuffix))
+			continue
+		}
+		if flagChanged(flags, key) {
+			continue
+		}
+		value, err := os.ReadFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", env+fileSuffix, err))
+			continue
+		}
+		v.Set(key, strings.TrimRight(string(value), "\r\n"))
+	}
+	return problems
+}
+
+// splitLists turns list settings given as a single string, as environment variables and files give them, into the
+// lists they are meant as by splitting them on commas. Settings holds the lists as well as the decoded config then
+func splitLists(v *viper.Viper) {
+	defaults := viper.New()
+	setDefaultConfig(defaults)
+	for _, key := range v.AllKeys() {
+		value, isString := v.Get(key).(string)
+		if !isString || reflect.ValueOf(defaults.Get(key)).Kind() != reflect.Slice {
+			continue
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(key, items)
+	}
+}
+
+// envName is the environment variable that overrides key
+func envName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func lookupFlag(flags *pflag.FlagSet, name string) *pflag.Flag {
+	if flags == nil {
+		return nil
+	}
+	return flags.Lookup(name)
+}
+
+// flagChanged reports whether a flag overriding key was given
+func flagChanged(flags *pflag.FlagSet, key string) bool {
+	for name, flagKey := range flagKeys {
+		if strings.EqualFold(flagKey, key) {
+			if flag := lookupFlag(flags, name); flag != nil && flag.Changed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeHook converts the strings settings are written as into durations, times and lists. An empty time is left
+// zero, meaning not set
+var decodeHook = mapstructure.ComposeDecodeHookFunc(
+	func(from, to reflect.Type, data any) (any, error) {
+		if to == reflect.TypeOf(time.Time{}) && from.Kind() == reflect.String && data.(string) == "" {
+			return time.Time{}, nil
+		}
+		return data, nil
+	},
+	mapstructure.StringToTimeHookFunc(time.RFC3339),
+	mapstructure.StringToTimeDurationHookFunc(),
+	mapstructure.StringToSliceHookFunc(","),
+)
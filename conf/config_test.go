@@ -0,0 +1,137 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lengebretsen/go-practice/testing/assert"
+	"github.com/spf13/pflag"
+)
+
+// parseFlags registers the config flags and parses args with them
+func parseFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	//there is no config.yml in this package's directory, so only the defaults apply
+	cfg, err := Load(nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, cfg.Server.Addr(), "localhost:8080")
+	assert.Equal(t, cfg.GRPC.Addr(), "localhost:9090")
+	assert.Equal(t, cfg.Database.Port, 3306)
+	assert.Equal(t, cfg.Database.ConnMaxLifetime, 3*time.Minute)
+	assert.Equal(t, cfg.Database.Replicas, []string{})
+	assert.Equal(t, cfg.API.Unversioned.DeprecatedAt.IsZero(), true)
+	assert.Equal(t, cfg.API.Unversioned.Sunset.IsZero(), true)
+	assert.Equal(t, cfg.Auth.JWT.RolesClaim, "roles")
+
+	//the unversioned routes are deprecated by the repo's config.yml rather than by a built-in date
+	cfg, err = Load(parseFlags(t, "--config", "../config.yml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, cfg.API.Unversioned.DeprecatedAt, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC))
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "service.yaml", `
+server:
+  host: "0.0.0.0"
+  port: 8000
+database:
+  host: "mysql"
+  pass: "from-file"
+  replicas: ["gousr@tcp(replica:3306)/go-practice"]
+auth:
+  jwt:
+    hmacSecrets: [{kid: "2024", secret: "0123456789abcdef0123456789abcdef"}]
+rateLimit:
+  routes:
+    - route: "GET /v1/addresses/"
+      rate: 2
+      burst: 10
+api:
+  v1:
+    sunset: "2027-01-01T00:00:00Z"
+`)
+	secret := writeFile(t, "bootstrap_key", "gp_from-secret-file\n")
+	t.Setenv("GOPRACTICE_DATABASE_PASS", "from-env")
+	t.Setenv("GOPRACTICE_SERVER_PORT", "8001")
+	t.Setenv("GOPRACTICE_DATABASE_MAXOPENCONNS", "25")
+	t.Setenv("GOPRACTICE_AUTH_BOOTSTRAPKEY_FILE", secret)
+	t.Setenv("GOPRACTICE_GRPC_ENABLED", "false")
+
+	cfg, err := Load(parseFlags(t, "--config", path, "--port", "8002", "--db-name", "flagged"))
+	assert.Equal(t, err, nil)
+	//flags win over the environment, which wins over the file, which wins over the defaults
+	assert.Equal(t, cfg.Server.Addr(), "0.0.0.0:8002")
+	assert.Equal(t, cfg.Database.Name, "flagged")
+	assert.Equal(t, cfg.Database.Pass, "from-env")
+	assert.Equal(t, cfg.Database.Host, "mysql")
+	assert.Equal(t, cfg.Database.MaxOpenConns, 25)
+	assert.Equal(t, cfg.Database.User, "gousr")
+	assert.Equal(t, cfg.Database.Replicas, []string{"gousr@tcp(replica:3306)/go-practice"})
+	assert.Equal(t, cfg.Auth.BootstrapKey, "gp_from-secret-file")
+	assert.Equal(t, cfg.Auth.JWT.HMACSecrets, []HMACSecret{{Kid: "2024", Secret: "0123456789abcdef0123456789abcdef"}})
+	assert.Equal(t, cfg.RateLimit.Routes, []RateLimitRoute{{Route: "GET /v1/addresses/", Rate: 2, Burst: 10}})
+	assert.Equal(t, cfg.API.V1.Sunset, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, cfg.GRPC.Enabled, false)
+
+	//settings hold the same values, as config print shows them
+	assert.Equal(t, cfg.Settings()["database"].(map[string]any)["pass"], "from-env")
+}
+
+func TestLoadListFromEnvironment(t *testing.T) {
+	t.Setenv("GOPRACTICE_DATABASE_REPLICAS", "gousr@tcp(replica-a:3306)/go-practice, gousr@tcp(replica-b:3306)/go-practice")
+
+	cfg, err := Load(nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, cfg.Database.Replicas, []string{"gousr@tcp(replica-a:3306)/go-practice", "gousr@tcp(replica-b:3306)/go-practice"})
+	assert.Equal(t, cfg.Settings()["database"].(map[string]any)["replicas"], []string{
+		"gousr@tcp(replica-a:3306)/go-practice", "gousr@tcp(replica-b:3306)/go-practice",
+	})
+}
+
+func TestLoadValidation(t *testing.T) {
+	t.Setenv("GOPRACTICE_DATABASE_PASS", "from-env")
+	t.Setenv("GOPRACTICE_DATABASE_PASS_FILE", filepath.Join(t.TempDir(), "pass"))
+	t.Setenv("GOPRACTICE_AUTH_BOOTSTRAPKEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("GOPRACTICE_DATABASE_HOST", "-mysql")
+	t.Setenv("GOPRACTICE_DATABASE_PORT", "70000")
+	t.Setenv("GOPRACTICE_GRPC_HOST", "localhost")
+
+	//every problem is reported, not just the first one found
+	_, err := Load(parseFlags(t, "--host", "my host", "--port", "0", "--grpc-port", "0"))
+	assert.Equal(t, err.Error(), "invalid config:\n"+
+		"  - GOPRACTICE_AUTH_BOOTSTRAPKEY_FILE: open "+os.Getenv("GOPRACTICE_AUTH_BOOTSTRAPKEY_FILE")+": no such file or directory\n"+
+		"  - GOPRACTICE_DATABASE_PASS and GOPRACTICE_DATABASE_PASS_FILE are both set, use one\n"+
+		"  - server.host [my host] is not a valid hostname or IP address\n"+
+		"  - server.port must be between 1 and 65535, got 0\n"+
+		"  - grpc.port must be between 1 and 65535, got 0\n"+
+		"  - database.host [-mysql] is not a valid hostname or IP address\n"+
+		"  - database.port must be between 1 and 65535, got 70000")
+
+	//the database address is not used over a unix socket, and IP addresses are valid hosts
+	t.Setenv("GOPRACTICE_DATABASE_PASS_FILE", "")
+	t.Setenv("GOPRACTICE_AUTH_BOOTSTRAPKEY_FILE", "")
+	t.Setenv("GOPRACTICE_DATABASE_SOCKET", "/var/run/mysqld/mysqld.sock")
+	_, err = Load(parseFlags(t, "--host", "::1", "--grpc-host", "127.0.0.1"))
+	assert.Equal(t, err, nil)
+
+	_, err = Load(parseFlags(t, "--config", filepath.Join(t.TempDir(), "config.yml")))
+	assert.Equal(t, err != nil, true)
+}
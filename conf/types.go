@@ -0,0 +1,234 @@
+package conf
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the effective configuration, one field per section of config.yml
+type Config struct {
+	Server      ServerConfig
+	Database    DatabaseConfig
+	Auth        AuthConfig
+	API         APIConfig
+	RateLimit   RateLimitConfig
+	Quota       QuotaConfig
+	Idempotency IdempotencyConfig
+	Batch       BatchConfig
+	GraphQL     GraphQLConfig
+	GRPC        GRPCConfig
+	Log         LogConfig
+	Tracing     TracingConfig
+
+	//settings are the values the config was decoded from, keyed the way viper keys them
+	settings map[string]any
+}
+
+// Settings returns the nested settings the config was decoded from, secrets included, with lower cased keys
+func (c Config) Settings() map[string]any {
+	return c.settings
+}
+
+type ServerConfig struct {
+	Host             string
+	Port             int
+	ReadinessTimeout time.Duration
+	ShutdownDelay    time.Duration
+	DrainTimeout     time.Duration
+	Debug            bool
+}
+
+// Addr is the address the REST API listens on
+func (s ServerConfig) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+type DatabaseConfig struct {
+	Name                    string
+	User                    string
+	Pass                    string
+	Host                    string
+	Port                    int
+	Socket                  string
+	Charset                 string
+	Collation               string
+	ParseTime               bool
+	DialTimeout             time.Duration
+	ReadTimeout             time.Duration
+	WriteTimeout            time.Duration
+	MaxOpenConns            int
+	MaxIdleConns            int
+	ConnMaxLifetime         time.Duration
+	ConnMaxIdleTime         time.Duration
+	PoolSaturationThreshold float64
+	TLS                     DatabaseTLSConfig
+	Replicas                []string
+	HealthCheckInterval     time.Duration
+	PingTimeout             time.Duration
+	ConnectAttempts         int
+	ConnectBackoff          time.Duration
+	ConnectMaxBackoff       time.Duration
+}
+
+type DatabaseTLSConfig struct {
+	Enabled    bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	SkipVerify bool
+}
+
+type AuthConfig struct {
+	Enabled          bool
+	BootstrapKey     string
+	LastUsedInterval time.Duration
+	JWT              JWTConfig
+}
+
+type JWTConfig struct {
+	HMACSecrets []HMACSecret
+	JWKSFiles   []string
+	Issuer      string
+	Audience    string
+	RolesClaim  string
+	//keys are lower cased as viper reads them
+	RoleMapping map[string]string
+	Leeway      time.Duration
+}
+
+type HMACSecret struct {
+	Kid    string
+	Secret string
+}
+
+type APIConfig struct {
+	Unversioned DeprecationConfig
+	V1          DeprecationConfig
+}
+
+// DeprecationConfig announces when a version was deprecated and will be removed, zero times announce nothing
+type DeprecationConfig struct {
+	DeprecatedAt time.Time
+	Sunset       time.Time
+}
+
+type RateLimitConfig struct {
+	Enabled bool
+	Rate    float64
+	Burst   int
+	Routes  []RateLimitRoute
+}
+
+type RateLimitRoute struct {
+	Route string
+	Rate  float64
+	Burst int
+}
+
+type QuotaConfig struct {
+	Enabled       bool
+	Daily         int64
+	FlushInterval time.Duration
+}
+
+type IdempotencyConfig struct {
+	Enabled      bool
+	Window       time.Duration
+	LockTimeout  time.Duration
+	MaxBodyBytes int64
+}
+
+type BatchConfig struct {
+	MaxOperations int
+}
+
+type GraphQLConfig struct {
+	MaxDepth int
+}
+
+type GRPCConfig struct {
+	Enabled    bool
+	Host       string
+	Port       int
+	Reflection bool
+}
+
+// Addr is the address the gRPC server listens on
+func (g GRPCConfig) Addr() string {
+	return net.JoinHostPort(g.Host, strconv.Itoa(g.Port))
+}
+
+type LogConfig struct {
+	Level  string
+	Format string
+	Output string
+}
+
+type TracingConfig struct {
+	Exporter    string
+	File        string
+	ServiceName string
+	SampleRatio float64
+}
+
+// validate checks the addresses the config names, the other sections are checked by the packages that use them
+func (c Config) validate() []string {
+	var problems []string
+	checkPort := func(key string, port int) {
+		if port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("%s must be between 1 and 65535, got %d", key, port))
+		}
+	}
+	checkHost := func(key, host string) {
+		if !validHost(host) {
+			problems = append(problems, fmt.Sprintf("%s [%s] is not a valid hostname or IP address", key, host))
+		}
+	}
+
+	//an empty listen host listens on every interface
+	if c.Server.Host != "" {
+		checkHost("server.host", c.Server.Host)
+	}
+	checkPort("server.port", c.Server.Port)
+	if c.GRPC.Enabled {
+		if c.GRPC.Host != "" {
+			checkHost("grpc.host", c.GRPC.Host)
+		}
+		checkPort("grpc.port", c.GRPC.Port)
+		if c.GRPC.Port == c.Server.Port && c.GRPC.Host == c.Server.Host {
+			problems = append(problems, fmt.Sprintf("grpc.port must differ from server.port, both are %d", c.Server.Port))
+		}
+	}
+	//host and port are not used when connecting over a unix socket
+	if c.Database.Socket == "" {
+		checkHost("database.host", c.Database.Host)
+		checkPort("database.port", c.Database.Port)
+	}
+	return problems
+}
+
+// validHost reports whether host is an IP address or a DNS name. Underscores are allowed as docker service names
+// may contain them
+func validHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the value of every secret config print shows
+const redacted = "REDACTED"
+
+func newConfigCommand(a *app) *cobra.Command {
+	config := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the config",
+	}
+
+	printCmd := &cobra.Command{
+		Use:   "print",
+		Short: "Print the effective config with secrets redacted",
+		Long: "Print the effective config, with the config file, environment variables and flags applied over the " +
+			"built-in defaults, as YAML. Passwords, secrets, keys and tokens that are set are replaced with " +
+			redacted + ", as are the passwords in replica DSNs.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			enc := yaml.NewEncoder(a.out)
+			enc.SetIndent(2)
+			if err := enc.Encode(redact(a.cfg.Settings())); err != nil {
+				return err
+			}
+			return enc.Close()
+		},
+	}
+
+	config.AddCommand(printCmd)
+	return config
+}
+
+// redact returns a copy of settings with the value of every secret replaced
+func redact(settings map[string]any) map[string]any {
+	safe := make(map[string]any, len(settings))
+	for key, value := range settings {
+		switch {
+		case isSecret(key) && !isEmpty(value):
+			safe[key] = redacted
+		case key == "replicas":
+			safe[key] = redactDSNs(value)
+		default:
+			if section, ok := value.(map[string]any); ok {
+				value = redact(section)
+			}
+			safe[key] = value
+		}
+	}
+	return safe
+}
+
+// isSecret reports whether a setting holds a credential, going by its name. viper lowercases every key, and file
+// settings such as database.tls.keyFile are paths rather than secrets
+func isSecret(key string) bool {
+	if strings.HasSuffix(key, "file") || strings.HasSuffix(key, "files") {
+		return false
+	}
+	for _, word := range []string{"pass", "secret", "key", "token"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty reports whether a setting is unset, which is shown as is so it is clear the secret is missing
+func isEmpty(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case []map[string]string:
+		return len(v) == 0
+	}
+	return false
+}
+
+// redactDSNs replaces the password in each replica DSN
+func redactDSNs(value any) []string {
+	dsns := cast.ToStringSlice(value)
+	safe := make([]string, len(dsns))
+	for i, dsn := range dsns {
+		cfg, err := mysql.ParseDSN(dsn)
+		switch {
+		case err != nil:
+			//an unparseable DSN could hold a password anywhere
+			safe[i] = redacted
+		case cfg.Passwd != "":
+			cfg.Passwd = redacted
+			safe[i] = cfg.FormatDSN()
+		default:
+			safe[i] = dsn
+		}
+	}
+	return safe
+}
@@ -1,10 +1,11 @@
 package controllers
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net/http"
 
+	"github.com/lengebretsen/go-practice/db"
 	"github.com/lengebretsen/go-practice/models"
 
 	"github.com/gin-gonic/gin"
@@ -12,131 +13,165 @@ import (
 )
 
 type addUpdateAddressBody struct {
-	UserId uuid.UUID `json:"userId" binding:"required"`
-	Street string    `json:"street"`
-	City   string    `json:"city"`
-	State  string    `json:"state"`
-	Zip    string    `json:"zip"`
-	Type   string    `json:"type"`
+	UserId uuid.UUID `json:"userId" xml:"userId" binding:"required"`
+	Street string    `json:"street" xml:"street" binding:"required,max=255,addressline" maxLength:"255"`
+	City   string    `json:"city" xml:"city" binding:"required,max=255,addressline" maxLength:"255"`
+	State  string    `json:"state" xml:"state" binding:"required,max=255,addressline" maxLength:"255"`
+	Zip    string    `json:"zip" xml:"zip" binding:"required,postalcode" maxLength:"10"`
+	Type   string    `json:"type" xml:"type" binding:"required,oneof=HOME WORK OTHER" enums:"HOME,WORK,OTHER"`
 }
 
 // FetchAddresses retrieves a list of all addresses in the system
 // @Summary retrieve a list of all addresses in the system
 // @Tags addresses
 // @ID fetch-all-addrs
-// @Produce json
+// @Produce json,xml,application/yaml,application/msgpack,text/csv
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param fields query string false "comma separated fields to return, e.g. id,city"
 // @Success 200 {object} []models.Address
-// @Router /addresses [get]
-func (h handler) FetchAddresses(c *gin.Context) {
-	addrs, err := h.addresses.FetchAddresses()
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v1/addresses [get]
+func (h handler) FetchAddresses(c *gin.Context) error {
+	addrs, err := h.addresses.FetchAddresses(c.Request.Context())
+	if err != nil {
+		return &InternalError{Detail: "Error fetching address records", Err: err}
+	}
+	data, err := selectFields(c, addrs)
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: "Error fetching address records", Detail: err.Error()})
-		return
+		return err
 	}
-	c.IndentedJSON(http.StatusOK, addrs)
+	return respond(c, http.StatusOK, data)
 }
 
 // FetchAddress retrieves a single address by Id
 // @Summary retrieve an address by Id
 // @Tags addresses
 // @ID fetch-addr
-// @Produce json
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param id path string true "address ID"
+// @Param fields query string false "comma separated fields to return, e.g. id,city"
 // @Success 200 {object} models.Address
-// @Failure 400 {object} ApiError
-// @Failure 404 {object} ApiError
-// @Router /addresses/{id} [get]
-func (h handler) FetchAddress(c *gin.Context) {
-	idParam := c.Param("id")
-	id, err := uuid.Parse(idParam)
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v1/addresses/{id} [get]
+func (h handler) FetchAddress(c *gin.Context) error {
+	id, err := parseId(c, "id")
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, ApiError{Message: fmt.Sprintf("Id [%s] is not a valid UUID", idParam), Detail: err.Error()})
-		return
+		return err
 	}
 
-	addr, err := h.addresses.FetchOneAddress(id)
+	addr, err := h.addresses.FetchOneAddress(c.Request.Context(), id)
 	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.IndentedJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No address exists with Id [%s]", idParam), Detail: err.Error()})
-			return
-		} else {
-			c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error fetching address record with Id [%s]", id), Detail: err.Error()})
-			return
-		}
+		return repositoryError(err, "address", id, fmt.Sprintf("Error fetching address record with Id [%s]", id))
 	}
-	c.IndentedJSON(http.StatusOK, addr)
+	data, err := selectFields(c, addr)
+	if err != nil {
+		return err
+	}
+	return respond(c, http.StatusOK, data)
 }
 
 // FetchAddressesForUser retrieves a list of addresses associated with a user
 // @Summary retrieve a list of addresses by the user's Id
 // @Tags users, addresses
 // @ID fetch-addrs-for-user
-// @Produce json
+// @Produce json,xml,application/yaml,application/msgpack,text/csv
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param id path string true "user ID"
+// @Param fields query string false "comma separated fields to return, e.g. id,city"
 // @Success 200 {object} []models.Address
-// @Failure 400 {object} ApiError
-// @Failure 404 {object} ApiError
-// @Router /users/{id}/addresses [get]
-func (h handler) FetchAddressesForUser(c *gin.Context) {
-	idParam := c.Param("id")
-	userId, err := uuid.Parse(idParam)
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v1/users/{id}/addresses [get]
+func (h handler) FetchAddressesForUser(c *gin.Context) error {
+	userId, err := parseId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	addrs, err := h.userAddresses(c.Request.Context(), userId)
+	if err != nil {
+		return err
+	}
+	data, err := selectFields(c, addrs)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, ApiError{Message: fmt.Sprintf("Id [%s] is not a valid UUID", idParam), Detail: err.Error()})
-		return
+		return err
 	}
+	return respond(c, http.StatusOK, data)
+}
 
+// userAddresses returns the addresses of an existing user, shared by FetchAddressesForUser and the gRPC server
+func (h handler) userAddresses(ctx context.Context, userId uuid.UUID) ([]models.Address, error) {
 	//lookup user to make sure they exist, and send back 404 if they do not
-	_, err = h.users.SelectOneUser(userId)
+	_, err := h.users.SelectOneUser(ctx, userId)
 	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.IndentedJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No user exists with Id [%s]", idParam), Detail: err.Error()})
-			return
-		} else {
-			c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error fetching address records for user [%s]", idParam), Detail: err.Error()})
-			return
-		}
+		return nil, repositoryError(err, "user", userId, fmt.Sprintf("Error fetching address records for user [%s]", userId))
 	}
 
-	addrs, err := h.addresses.FindAddressesByUserId(userId)
+	addrs, err := h.addresses.FindAddressesByUserId(ctx, userId)
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error fetching address records for user [%s]", idParam), Detail: err.Error()})
-		return
+		return nil, &InternalError{Detail: fmt.Sprintf("Error fetching address records for user [%s]", userId), Err: err}
 	}
-	c.IndentedJSON(http.StatusOK, addrs)
+	return addrs, nil
 }
 
 // AddAddress stores a new address
 // @Summary store a new address
 // @Tags addresses
 // @ID add-addr
-// @Produce json
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param data body addUpdateAddressBody true "new address data"
+// @Param Idempotency-Key header string false "makes retries of this request safe, see the README"
 // @Success 200 {object} []models.Address
-// @Failure 400 {object} ApiError
-// @Failure 404 {object} ApiError
-// @Router /addresses [post]
-func (h handler) AddAddress(c *gin.Context) {
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /v1/addresses [post]
+func (h handler) AddAddress(c *gin.Context) error {
 	var reqBody addUpdateAddressBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
+	}
 
-	err := c.BindJSON(&reqBody)
+	newAddr, err := h.createAddress(c.Request.Context(), reqBody)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, ApiError{Message: "Invalid request body.", Detail: err.Error()})
-		return
+		return err
 	}
 
-	//lookup user to make sure they exist, and send back 404 if they do not
-	_, err = h.users.SelectOneUser(reqBody.UserId)
+	return respond(c, http.StatusCreated, newAddr)
+}
+
+// createAddress stores a new address for an existing user, shared by AddAddress and batch operations
+func (h handler) createAddress(ctx context.Context, reqBody addUpdateAddressBody) (models.Address, error) {
+	//lookup user to make sure they exist, and send back 404 if they do not. This check guards a write so it has to
+	//see the primary, otherwise a user created moments ago may not have reached the replicas yet
+	_, err := h.users.SelectOneUser(db.WithPrimaryReads(ctx), reqBody.UserId)
 	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.IndentedJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No user exists with Id [%s]", reqBody.UserId), Detail: err.Error()})
-			return
-		} else {
-			c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: "Error creating new address", Detail: err.Error()})
-			return
-		}
+		return models.Address{}, repositoryError(err, "user", reqBody.UserId, "Error creating new address")
 	}
 
-	newAddr, err := h.addresses.InsertAddress(models.Address{
+	newAddr, err := h.addresses.InsertAddress(ctx, models.Address{
 		Id:     uuid.New(),
 		UserId: reqBody.UserId,
 		Street: reqBody.Street,
@@ -146,65 +181,65 @@ func (h handler) AddAddress(c *gin.Context) {
 		Type:   reqBody.Type,
 	})
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: "Error creating new address", Detail: err.Error()})
-		return
+		return models.Address{}, repositoryError(err, "address", uuid.Nil, "Error creating new address")
 	}
-
-	c.IndentedJSON(http.StatusCreated, newAddr)
+	return newAddr, nil
 }
 
 // AddAddress updates an existing address
 // @Summary update an existing address by Id
 // @Tags addresses
 // @ID update-addr
-// @Produce json
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param id path string true "address ID"
 // @Param data body addUpdateAddressBody true "updated address data"
 // @Success 200 {object} []models.Address
-// @Failure 400 {object} ApiError
-// @Failure 404 {object} ApiError
-// @Router /addresses/{id} [put]
-func (h handler) UpdateAddress(c *gin.Context) {
-	var reqBody addUpdateAddressBody
-	idParam := c.Param("id")
-	id, err := uuid.Parse(idParam)
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /v1/addresses/{id} [put]
+func (h handler) UpdateAddress(c *gin.Context) error {
+	id, err := parseId(c, "id")
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, ApiError{Message: fmt.Sprintf("Id [%s] is not a valid UUID", idParam), Detail: err.Error()})
-		return
+		return err
+	}
+
+	var reqBody addUpdateAddressBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
 	}
 
-	err = c.BindJSON(&reqBody)
+	updatedAddr, err := h.updateAddress(c.Request.Context(), id, reqBody)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, ApiError{Message: "Invalid request body.", Detail: err.Error()})
-		return
+		return err
 	}
 
-	//lookup user to make sure they exist, and send back 404 if they do not
-	_, err = h.users.SelectOneUser(reqBody.UserId)
+	return respond(c, http.StatusOK, updatedAddr)
+}
+
+// updateAddress modifies an existing address, shared by UpdateAddress and batch operations
+func (h handler) updateAddress(ctx context.Context, id uuid.UUID, reqBody addUpdateAddressBody) (models.Address, error) {
+	//lookup user to make sure they exist, and send back 404 if they do not. This check guards a write so it has to
+	//see the primary, otherwise a user created moments ago may not have reached the replicas yet
+	_, err := h.users.SelectOneUser(db.WithPrimaryReads(ctx), reqBody.UserId)
 	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.IndentedJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No user exists with Id [%s]", reqBody.UserId), Detail: err.Error()})
-			return
-		} else {
-			c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error updating address record with Id [%s]", id), Detail: err.Error()})
-			return
-		}
+		return models.Address{}, repositoryError(err, "user", reqBody.UserId, fmt.Sprintf("Error updating address record with Id [%s]", id))
 	}
 
 	updatedAddr, err := h.addresses.UpdateAddress(
+		ctx,
 		models.Address{Id: id, UserId: reqBody.UserId, Street: reqBody.Street, City: reqBody.City, State: reqBody.State, Zip: reqBody.Zip, Type: reqBody.Type},
 	)
 	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.IndentedJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No address exists with Id [%s]", idParam), Detail: err.Error()})
-			return
-		} else {
-			c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error updating address record with Id [%s]", id), Detail: err.Error()})
-			return
-		}
+		return models.Address{}, repositoryError(err, "address", id, fmt.Sprintf("Error updating address record with Id [%s]", id))
 	}
-
-	c.IndentedJSON(http.StatusOK, updatedAddr)
+	return updatedAddr, nil
 }
 
 // DeleteAddress deletes an existing address
@@ -212,28 +247,32 @@ func (h handler) UpdateAddress(c *gin.Context) {
 // @Tags addresses
 // @ID delete-addr
 // @Produce json
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param id path string true "address ID"
 // @Success 204
-// @Failure 400 {object} ApiError
-// @Failure 404 {object} ApiError
-// @Router /addresses/{id} [delete]
-func (h handler) DeleteAddress(c *gin.Context) {
-	idParam := c.Param("id")
-	id, err := uuid.Parse(idParam)
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Router /v1/addresses/{id} [delete]
+func (h handler) DeleteAddress(c *gin.Context) error {
+	id, err := parseId(c, "id")
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, ApiError{Message: fmt.Sprintf("Id [%s] is not a valid UUID", idParam), Detail: err.Error()})
-		return
+		return err
 	}
 
-	err = h.addresses.DeleteAddress(id)
-	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.IndentedJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No address exists with Id [%s]", idParam), Detail: err.Error()})
-			return
-		} else {
-			c.IndentedJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error deleting address record with Id [%s]", id), Detail: err.Error()})
-			return
-		}
+	if err := h.deleteAddress(c.Request.Context(), id); err != nil {
+		return err
 	}
 	c.Status(http.StatusNoContent)
+	return nil
+}
+
+// deleteAddress removes an address, shared by DeleteAddress and batch operations
+func (h handler) deleteAddress(ctx context.Context, id uuid.UUID) error {
+	if err := h.addresses.DeleteAddress(ctx, id); err != nil {
+		return repositoryError(err, "address", id, fmt.Sprintf("Error deleting address record with Id [%s]", id))
+	}
+	return nil
 }
@@ -2,12 +2,14 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
 
 	"github.com/google/uuid"
@@ -18,23 +20,25 @@ import (
 type mockAddressRepository struct {
 	addrs []models.Address
 	err   error
+	//batches counts calls to FindAddressesByUserIds
+	batches int
 }
 
-func (m *mockAddressRepository) FetchAddresses() ([]models.Address, error) {
+func (m *mockAddressRepository) FetchAddresses(ctx context.Context) ([]models.Address, error) {
 	if m.addrs != nil {
 		return m.addrs, nil
 	} else {
 		return nil, m.err
 	}
 }
-func (m *mockAddressRepository) FetchOneAddress(id uuid.UUID) (models.Address, error) {
+func (m *mockAddressRepository) FetchOneAddress(ctx context.Context, id uuid.UUID) (models.Address, error) {
 	if len(m.addrs) > 0 {
 		return m.addrs[0], nil
 	} else {
 		return models.Address{}, m.err
 	}
 }
-func (m *mockAddressRepository) InsertAddress(addr models.Address) (models.Address, error) {
+func (m *mockAddressRepository) InsertAddress(ctx context.Context, addr models.Address) (models.Address, error) {
 	if addr.Id == uuid.Nil {
 		log.Fatalln("UUID value for new user was nil")
 	}
@@ -47,17 +51,17 @@ func (m *mockAddressRepository) InsertAddress(addr models.Address) (models.Addre
 		return models.Address{}, m.err
 	}
 }
-func (m *mockAddressRepository) UpdateAddress(addr models.Address) (models.Address, error) {
+func (m *mockAddressRepository) UpdateAddress(ctx context.Context, addr models.Address) (models.Address, error) {
 	if m.err != nil {
 		return models.Address{}, m.err
 	} else {
 		return addr, nil
 	}
 }
-func (m *mockAddressRepository) DeleteAddress(id uuid.UUID) error {
+func (m *mockAddressRepository) DeleteAddress(ctx context.Context, id uuid.UUID) error {
 	return m.err
 }
-func (m *mockAddressRepository) FindAddressesByUserId(userId uuid.UUID) ([]models.Address, error) {
+func (m *mockAddressRepository) FindAddressesByUserId(ctx context.Context, userId uuid.UUID) ([]models.Address, error) {
 	if m.addrs != nil {
 		return m.addrs, nil
 	} else {
@@ -65,12 +69,26 @@ func (m *mockAddressRepository) FindAddressesByUserId(userId uuid.UUID) ([]model
 	}
 }
 
+func (m *mockAddressRepository) FindAddressesByUserIds(ctx context.Context, userIds []uuid.UUID) ([]models.Address, error) {
+	m.batches++
+	if m.err != nil {
+		return nil, m.err
+	}
+	addrs := make([]models.Address, 0)
+	for _, addr := range m.addrs {
+		if slices.Contains(userIds, addr.UserId) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
 func TestFetchAddressesRoute(t *testing.T) {
 	type test struct {
 		mockResult mockAddressRepository
 		wantedCode int
 		wantedBody []models.Address
-		wantedErr  ApiError
+		wantedErr  Problem
 	}
 
 	tests := []test{
@@ -122,13 +140,14 @@ func TestFetchAddressesRoute(t *testing.T) {
 		{
 			mockResult: mockAddressRepository{err: errors.New("Kaboom!!")},
 			wantedCode: 500,
-			wantedErr:  ApiError{Message: "Error fetching address records", Detail: "Kaboom!!"},
+			wantedErr:  wantProblem(ErrCodeInternal, "Error fetching address records"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, nil, &testCase.mockResult)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, nil, &testCase.mockResult, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/addresses/", nil)
@@ -144,9 +163,8 @@ func TestFetchAddressesRoute(t *testing.T) {
 			//compare expected slice w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedErr)
 		}
 	}
@@ -158,7 +176,7 @@ func TestFetchSingleAddressRoute(t *testing.T) {
 		addrId     string
 		wantedCode int
 		wantedBody models.Address
-		wantedErr  ApiError
+		wantedErr  Problem
 	}
 
 	tests := []test{
@@ -191,25 +209,26 @@ func TestFetchSingleAddressRoute(t *testing.T) {
 		{
 			addrId:     "bob",
 			wantedCode: 400,
-			wantedErr:  ApiError{Message: "Id [bob] is not a valid UUID", Detail: "invalid UUID length: 3"},
+			wantedErr:  wantProblem(ErrCodeInvalidId, "Id [bob] is not a valid UUID: invalid UUID length: 3"),
 		},
 		{
 			addrId:     "34ecb0a8-7184-42fa-8840-6fa5c496d161",
 			mockResult: mockAddressRepository{err: models.ErrModelNotFound},
 			wantedCode: 404,
-			wantedErr:  ApiError{Message: "No address exists with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]", Detail: "resource not found"},
+			wantedErr:  wantProblem(ErrCodeAddressNotFound, "No address exists with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]"),
 		},
 		{
 			addrId:     "34ecb0a8-7184-42fa-8840-6fa5c496d161",
 			mockResult: mockAddressRepository{err: errors.New("Kaboom!!")},
 			wantedCode: 500,
-			wantedErr:  ApiError{Message: "Error fetching address record with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]", Detail: "Kaboom!!"},
+			wantedErr:  wantProblem(ErrCodeInternal, "Error fetching address record with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, nil, &testCase.mockResult)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, nil, &testCase.mockResult, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/addresses/"+testCase.addrId, nil)
@@ -225,9 +244,8 @@ func TestFetchSingleAddressRoute(t *testing.T) {
 			//compare expected w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedErr)
 		}
 	}
@@ -240,7 +258,7 @@ func TestFetchAddresseForUserRoute(t *testing.T) {
 		mockUserRepo mockUserRepository
 		wantedCode   int
 		wantedBody   []models.Address
-		wantedErr    ApiError
+		wantedErr    Problem
 	}
 
 	tests := []test{
@@ -303,32 +321,33 @@ func TestFetchAddresseForUserRoute(t *testing.T) {
 		{
 			userId:     "bob",
 			wantedCode: 400,
-			wantedErr:  ApiError{Message: "Id [bob] is not a valid UUID", Detail: "invalid UUID length: 3"},
+			wantedErr:  wantProblem(ErrCodeInvalidId, "Id [bob] is not a valid UUID: invalid UUID length: 3"),
 		},
 		{
 			userId:       "80e4de8a-91c4-46cc-a66d-23d3cf364036",
 			mockUserRepo: mockUserRepository{users: []models.User{}, err: models.ErrModelNotFound},
 			wantedCode:   404,
-			wantedErr:    ApiError{Message: "No user exists with Id [80e4de8a-91c4-46cc-a66d-23d3cf364036]", Detail: "resource not found"},
+			wantedErr:    wantProblem(ErrCodeUserNotFound, "No user exists with Id [80e4de8a-91c4-46cc-a66d-23d3cf364036]"),
 		},
 		{
 			userId:       "80e4de8a-91c4-46cc-a66d-23d3cf364036",
 			mockUserRepo: mockUserRepository{users: []models.User{}, err: errors.New("Random error when fetching the user")},
 			wantedCode:   500,
-			wantedErr:    ApiError{Message: "Error fetching address records for user [80e4de8a-91c4-46cc-a66d-23d3cf364036]", Detail: "Random error when fetching the user"},
+			wantedErr:    wantProblem(ErrCodeInternal, "Error fetching address records for user [80e4de8a-91c4-46cc-a66d-23d3cf364036]"),
 		},
 		{
 			userId:       "80e4de8a-91c4-46cc-a66d-23d3cf364036",
 			mockResult:   mockAddressRepository{err: errors.New("Kaboom!!")},
 			mockUserRepo: mockUserRepository{users: []models.User{{Id: uuid.MustParse("80e4de8a-91c4-46cc-a66d-23d3cf364036"), FirstName: "Test", LastName: "User"}}},
 			wantedCode:   500,
-			wantedErr:    ApiError{Message: "Error fetching address records for user [80e4de8a-91c4-46cc-a66d-23d3cf364036]", Detail: "Kaboom!!"},
+			wantedErr:    wantProblem(ErrCodeInternal, "Error fetching address records for user [80e4de8a-91c4-46cc-a66d-23d3cf364036]"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, &testCase.mockUserRepo, &testCase.mockResult)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &testCase.mockUserRepo, &testCase.mockResult, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/users/%s/addresses", testCase.userId), nil)
@@ -344,9 +363,8 @@ func TestFetchAddresseForUserRoute(t *testing.T) {
 			//compare expected slice w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedErr)
 		}
 	}
@@ -357,7 +375,7 @@ func TestAddAddressRoute(t *testing.T) {
 		mockResult   mockAddressRepository
 		wantedCode   int
 		wantedBody   models.Address
-		wantedErr    ApiError
+		wantedErr    Problem
 		requestBody  string
 		mockUserRepo mockUserRepository
 	}
@@ -406,7 +424,23 @@ func TestAddAddressRoute(t *testing.T) {
 				"zip": "30033"
 			  }`,
 			wantedCode: 400,
-			wantedErr:  ApiError{Message: "Invalid request body.", Detail: "Key: 'addUpdateAddressBody.UserId' Error:Field validation for 'UserId' failed on the 'required' tag"},
+			wantedErr:  wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation", FieldError{Field: "userId", Code: "required", Message: "is required"}),
+		},
+		{
+			requestBody: `{
+				"city": "",
+				"state": "GA",
+				"street": "123 A St.; DROP TABLE",
+				"type": "VACATION",
+				"userId": "80e4de8a-91c4-46cc-a66d-23d3cf364036",
+				"zip": "300330000000"
+			  }`,
+			wantedCode: 400,
+			wantedErr: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "street", Code: "addressline", Message: "may only contain letters, digits, spaces and the punctuation . , ' # / & ( ) -"},
+				FieldError{Field: "city", Code: "required", Message: "is required"},
+				FieldError{Field: "zip", Code: "postalcode", Message: "must be 3 to 10 letters, digits, spaces or hyphens"},
+				FieldError{Field: "type", Code: "oneof", Message: "must be one of HOME, WORK, OTHER"}),
 		},
 		{
 			requestBody: `{
@@ -418,7 +452,7 @@ func TestAddAddressRoute(t *testing.T) {
 				"zip": "30033"
 			  }`,
 			wantedCode:   404,
-			wantedErr:    ApiError{Message: "No user exists with Id [80e4de8a-91c4-46cc-a66d-23d3cf364036]", Detail: "resource not found"},
+			wantedErr:    wantProblem(ErrCodeUserNotFound, "No user exists with Id [80e4de8a-91c4-46cc-a66d-23d3cf364036]"),
 			mockUserRepo: mockUserRepository{err: models.ErrModelNotFound},
 		},
 		{
@@ -432,14 +466,15 @@ func TestAddAddressRoute(t *testing.T) {
 			  }`,
 			mockResult:   mockAddressRepository{err: errors.New("Kaboom!!")},
 			wantedCode:   500,
-			wantedErr:    ApiError{Message: "Error creating new address", Detail: "Kaboom!!"},
+			wantedErr:    wantProblem(ErrCodeInternal, "Error creating new address"),
 			mockUserRepo: mockUserRepository{users: []models.User{{Id: uuid.MustParse("80e4de8a-91c4-46cc-a66d-23d3cf364036"), FirstName: "Test", LastName: "User"}}},
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, &testCase.mockUserRepo, &testCase.mockResult)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &testCase.mockUserRepo, &testCase.mockResult, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/addresses/", bytes.NewBuffer([]byte(testCase.requestBody)))
@@ -456,9 +491,8 @@ func TestAddAddressRoute(t *testing.T) {
 			//compare expected w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedErr)
 		}
 	}
@@ -470,7 +504,7 @@ func TestUpdateAddressRoute(t *testing.T) {
 		mockResult   mockAddressRepository
 		wantedCode   int
 		wantedBody   models.Address
-		wantedErr    ApiError
+		wantedErr    Problem
 		requestBody  string
 		mockUserRepo mockUserRepository
 	}
@@ -520,7 +554,7 @@ func TestUpdateAddressRoute(t *testing.T) {
 				"zip": "30033"
 			  }`,
 			wantedCode: 400,
-			wantedErr:  ApiError{Message: "Invalid request body.", Detail: "Key: 'addUpdateAddressBody.UserId' Error:Field validation for 'UserId' failed on the 'required' tag"},
+			wantedErr:  wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation", FieldError{Field: "userId", Code: "required", Message: "is required"}),
 		},
 		{
 			addrId: "bob",
@@ -532,7 +566,7 @@ func TestUpdateAddressRoute(t *testing.T) {
 				"zip": "30033"
 			  }`,
 			wantedCode: 400,
-			wantedErr:  ApiError{Message: "Id [bob] is not a valid UUID", Detail: "invalid UUID length: 3"},
+			wantedErr:  wantProblem(ErrCodeInvalidId, "Id [bob] is not a valid UUID: invalid UUID length: 3"),
 		},
 		{
 			addrId: "34ecb0a8-7184-42fa-8840-6fa5c496d161",
@@ -546,7 +580,7 @@ func TestUpdateAddressRoute(t *testing.T) {
 			  }`,
 			mockResult: mockAddressRepository{err: models.ErrModelNotFound},
 			wantedCode: 404,
-			wantedErr:  ApiError{Message: "No address exists with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]", Detail: "resource not found"},
+			wantedErr:  wantProblem(ErrCodeAddressNotFound, "No address exists with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]"),
 		},
 		{
 			addrId: "34ecb0a8-7184-42fa-8840-6fa5c496d161",
@@ -559,7 +593,7 @@ func TestUpdateAddressRoute(t *testing.T) {
 				"zip": "30033"
 			  }`,
 			wantedCode:   404,
-			wantedErr:    ApiError{Message: "No user exists with Id [80e4de8a-91c4-46cc-a66d-23d3cf364036]", Detail: "resource not found"},
+			wantedErr:    wantProblem(ErrCodeUserNotFound, "No user exists with Id [80e4de8a-91c4-46cc-a66d-23d3cf364036]"),
 			mockUserRepo: mockUserRepository{err: models.ErrModelNotFound},
 		},
 		{
@@ -574,13 +608,14 @@ func TestUpdateAddressRoute(t *testing.T) {
 			  }`,
 			mockResult: mockAddressRepository{err: errors.New("Kaboom!!")},
 			wantedCode: 500,
-			wantedErr:  ApiError{Message: "Error updating address record with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]", Detail: "Kaboom!!"},
+			wantedErr:  wantProblem(ErrCodeInternal, "Error updating address record with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, &testCase.mockUserRepo, &testCase.mockResult)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &testCase.mockUserRepo, &testCase.mockResult, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("PUT", "/addresses/"+testCase.addrId, bytes.NewBuffer([]byte(testCase.requestBody)))
@@ -597,9 +632,8 @@ func TestUpdateAddressRoute(t *testing.T) {
 			//compare expected w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedErr)
 		}
 	}
@@ -610,7 +644,7 @@ func TestDeleteAddressRoute(t *testing.T) {
 		addrId     string
 		mockResult mockAddressRepository
 		wantedCode int
-		wantedErr  ApiError
+		wantedErr  Problem
 	}
 
 	tests := []test{
@@ -622,25 +656,26 @@ func TestDeleteAddressRoute(t *testing.T) {
 		{
 			addrId:     "bob",
 			wantedCode: 400,
-			wantedErr:  ApiError{Message: "Id [bob] is not a valid UUID", Detail: "invalid UUID length: 3"},
+			wantedErr:  wantProblem(ErrCodeInvalidId, "Id [bob] is not a valid UUID: invalid UUID length: 3"),
 		},
 		{
 			addrId:     "34ecb0a8-7184-42fa-8840-6fa5c496d161",
 			mockResult: mockAddressRepository{err: models.ErrModelNotFound},
 			wantedCode: 404,
-			wantedErr:  ApiError{Message: "No address exists with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]", Detail: "resource not found"},
+			wantedErr:  wantProblem(ErrCodeAddressNotFound, "No address exists with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]"),
 		},
 		{
 			addrId:     "34ecb0a8-7184-42fa-8840-6fa5c496d161",
 			mockResult: mockAddressRepository{err: errors.New("Kaboom!!")},
 			wantedCode: 500,
-			wantedErr:  ApiError{Message: "Error deleting address record with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]", Detail: "Kaboom!!"},
+			wantedErr:  wantProblem(ErrCodeInternal, "Error deleting address record with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, nil, &testCase.mockResult)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, nil, &testCase.mockResult, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("DELETE", "/addresses/"+testCase.addrId, nil)
@@ -649,9 +684,8 @@ func TestDeleteAddressRoute(t *testing.T) {
 		assert.Equal(t, testCase.wantedCode, w.Code)
 
 		if testCase.wantedCode != 204 {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedErr)
 		}
 	}
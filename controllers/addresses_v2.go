@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FetchAddressesV2 retrieves a page of addresses
+// @Summary retrieve a page of addresses
+// @Tags v2 addresses
+// @ID fetch-all-addrs-v2
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param limit query int false "addresses per page, at most 100" default(50)
+// @Param offset query int false "addresses to skip" default(0)
+// @Param fields query string false "comma separated fields to return, e.g. id,city"
+// @Success 200 {object} pageV2{data=[]addressV2}
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v2/addresses [get]
+func (h handler) FetchAddressesV2(c *gin.Context) error {
+	addrs, err := h.addresses.FetchAddresses(c.Request.Context())
+	if err != nil {
+		return &InternalError{Detail: "Error fetching address records", Err: err}
+	}
+	page, start, end, err := cutPage(c, len(addrs))
+	if err != nil {
+		return err
+	}
+	return respondPage(c, page, toAddressesV2(addrs[start:end]), "links")
+}
+
+// FetchAddressV2 retrieves a single address by Id
+// @Summary retrieve an address by Id
+// @Tags v2 addresses
+// @ID fetch-addr-v2
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "address ID"
+// @Param fields query string false "comma separated fields to return, e.g. id,city"
+// @Success 200 {object} addressV2
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v2/addresses/{id} [get]
+func (h handler) FetchAddressV2(c *gin.Context) error {
+	id, err := parseId(c, "id")
+	if err != nil {
+		return err
+	}
+	addr, err := h.addresses.FetchOneAddress(c.Request.Context(), id)
+	if err != nil {
+		return repositoryError(err, "address", id, fmt.Sprintf("Error fetching address record with Id [%s]", id))
+	}
+	data, err := selectFields(c, toAddressV2(addr), "links")
+	if err != nil {
+		return err
+	}
+	return respond(c, http.StatusOK, data)
+}
+
+// AddAddressV2 stores a new address
+// @Summary store a new address
+// @Tags v2 addresses
+// @ID add-addr-v2
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param data body addUpdateAddressBody true "new address data"
+// @Param Idempotency-Key header string false "makes retries of this request safe, see the README"
+// @Success 201 {object} addressV2
+// @Header 201 {string} Location "path of the new address"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 415 {object} Problem
+// @Failure 422 {object} Problem
+// @Router /v2/addresses [post]
+func (h handler) AddAddressV2(c *gin.Context) error {
+	var reqBody addUpdateAddressBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
+	}
+	newAddr, err := h.createAddress(c.Request.Context(), reqBody)
+	if err != nil {
+		return err
+	}
+	data := toAddressV2(newAddr)
+	c.Header("Location", data.Links.Self)
+	return respond(c, http.StatusCreated, data)
+}
+
+// UpdateAddressV2 updates an existing address
+// @Summary update an existing address by Id
+// @Tags v2 addresses
+// @ID update-addr-v2
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "address ID"
+// @Param data body addUpdateAddressBody true "updated address data"
+// @Success 200 {object} addressV2
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /v2/addresses/{id} [put]
+func (h handler) UpdateAddressV2(c *gin.Context) error {
+	id, err := parseId(c, "id")
+	if err != nil {
+		return err
+	}
+	var reqBody addUpdateAddressBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
+	}
+	updatedAddr, err := h.updateAddress(c.Request.Context(), id, reqBody)
+	if err != nil {
+		return err
+	}
+	return respond(c, http.StatusOK, toAddressV2(updatedAddr))
+}
+
+// DeleteAddressV2 deletes an existing address. It answers as /v1 does
+// @Summary remove an existing address by Id
+// @Tags v2 addresses
+// @ID delete-addr-v2
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "address ID"
+// @Success 204
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Router /v2/addresses/{id} [delete]
+func (h handler) DeleteAddressV2(c *gin.Context) error {
+	return h.DeleteAddress(c)
+}
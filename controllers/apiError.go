@@ -1,6 +0,0 @@
-package controllers
-
-type ApiError struct {
-	Message string `json:"message"`
-	Detail  string `json:"detail"`
-}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	//apiKeySecretPrefix marks strings as keys for this API so they are easy to spot in logs and secret scanners
+	apiKeySecretPrefix = "gp_"
+	//apiKeyPrefixLength is how much of a secret is kept in the clear to identify the key in listings
+	apiKeyPrefixLength = len(apiKeySecretPrefix) + 8
+)
+
+type apiKeyHandler struct {
+	keys models.APIKeyRepository
+}
+
+type createAPIKeyBody struct {
+	Name      string     `json:"name" xml:"name" binding:"required,max=255" maxLength:"255"`
+	Admin     bool       `json:"admin" xml:"admin"`
+	ExpiresAt *time.Time `json:"expiresAt" xml:"expiresAt" binding:"omitempty,gt"`
+}
+
+// createdAPIKey is returned when a key is created or rotated, the only time its secret is ever shown
+type createdAPIKey struct {
+	XMLName xml.Name `json:"-" xml:"apiKey"`
+	models.APIKey
+	Secret string `json:"secret" xml:"secret"`
+}
+
+// newAPIKeySecret generates a random key secret along with the hash that is stored in its place
+func newAPIKeySecret() (string, []byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	secret := apiKeySecretPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return secret, hashAPIKey(secret), nil
+}
+
+// hashAPIKey hashes a key secret for storage and lookup. Secrets are 256 random bits, so a fast unsalted hash is
+// enough to keep a database leak from exposing usable keys
+func hashAPIKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// FetchAPIKeys retrieves every API key, including revoked and expired ones
+// @Summary list all API keys
+// @Tags admin
+// @ID fetch-api-keys
+// @Produce json,xml,application/yaml,application/msgpack,text/csv
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Success 200 {object} []models.APIKey
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /admin/keys [get]
+func (h apiKeyHandler) FetchAPIKeys(c *gin.Context) error {
+	keys, err := h.keys.SelectAllAPIKeys(c.Request.Context())
+	if err != nil {
+		return &InternalError{Detail: "Error fetching API keys", Err: err}
+	}
+	return respond(c, http.StatusOK, keys)
+}
+
+// CreateAPIKey issues a new API key
+// @Summary create an API key, the response is the only time its secret is shown
+// @Tags admin
+// @ID create-api-key
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param data body createAPIKeyBody true "new key settings"
+// @Success 201 {object} createdAPIKey
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /admin/keys [post]
+func (h apiKeyHandler) CreateAPIKey(c *gin.Context) error {
+	var reqBody createAPIKeyBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
+	}
+
+	secret, hash, err := newAPIKeySecret()
+	if err != nil {
+		return &InternalError{Detail: "Error generating API key", Err: err}
+	}
+	key, err := h.keys.InsertAPIKey(c.Request.Context(), models.APIKey{
+		Id:        uuid.New(),
+		Name:      reqBody.Name,
+		Prefix:    secret[:apiKeyPrefixLength],
+		Admin:     reqBody.Admin,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: reqBody.ExpiresAt,
+	}, hash)
+	if err != nil {
+		return repositoryError(err, "API key", uuid.Nil, "Error creating API key")
+	}
+
+	return respond(c, http.StatusCreated, createdAPIKey{APIKey: key, Secret: secret})
+}
+
+// RevokeAPIKey disables an API key immediately
+// @Summary revoke an API key
+// @Tags admin
+// @ID revoke-api-key
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "API key ID"
+// @Success 204
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Router /admin/keys/{id} [delete]
+func (h apiKeyHandler) RevokeAPIKey(c *gin.Context) error {
+	id, err := parseId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	if err := h.keys.RevokeAPIKey(c.Request.Context(), id, time.Now().UTC()); err != nil {
+		return repositoryError(err, "API key", id, "Error revoking API key")
+	}
+	c.Status(http.StatusNoContent)
+	return nil
+}
+
+// RotateAPIKey revokes an API key and issues a replacement with the same name, role and expiry
+// @Summary replace an API key with a new secret, the old one stops working immediately
+// @Tags admin
+// @ID rotate-api-key
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "API key ID"
+// @Success 201 {object} createdAPIKey
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /admin/keys/{id}/rotate [post]
+func (h apiKeyHandler) RotateAPIKey(c *gin.Context) error {
+	id, err := parseId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	existing, err := h.keys.SelectOneAPIKey(c.Request.Context(), id)
+	if err != nil {
+		return repositoryError(err, "API key", id, "Error rotating API key")
+	}
+	secret, hash, err := newAPIKeySecret()
+	if err != nil {
+		return &InternalError{Detail: "Error generating API key", Err: err}
+	}
+	key, err := h.keys.RotateAPIKey(c.Request.Context(), id, models.APIKey{
+		Id:        uuid.New(),
+		Name:      existing.Name,
+		Prefix:    secret[:apiKeyPrefixLength],
+		Admin:     existing.Admin,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: existing.ExpiresAt,
+	}, hash)
+	if err != nil {
+		return repositoryError(err, "API key", id, "Error rotating API key")
+	}
+
+	return respond(c, http.StatusCreated, createdAPIKey{APIKey: key, Secret: secret})
+}
+
+// RegisterAPIKeyRoutes adds the admin endpoints for managing API keys. They need Authenticate to have run first
+func RegisterAPIKeyRoutes(r *gin.Engine, keys models.APIKeyRepository) {
+	h := apiKeyHandler{keys: keys}
+
+	keyRoutes := r.Group("/admin/keys", requireRole(auth.RoleAdmin), negotiateContent())
+	keyRoutes.GET("/", handle(h.FetchAPIKeys))
+	keyRoutes.POST("/", handle(h.CreateAPIKey))
+	keyRoutes.DELETE("/:id", handle(h.RevokeAPIKey))
+	keyRoutes.POST("/:id/rotate", handle(h.RotateAPIKey))
+}
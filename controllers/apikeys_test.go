@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+type mockAPIKeyRepository struct {
+	keys    []models.APIKey
+	hashes  map[string]models.APIKey
+	err     error
+	touched []uuid.UUID
+}
+
+func (m *mockAPIKeyRepository) SelectAllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return m.keys, m.err
+}
+func (m *mockAPIKeyRepository) SelectOneAPIKey(ctx context.Context, id uuid.UUID) (models.APIKey, error) {
+	if len(m.keys) > 0 {
+		return m.keys[0], m.err
+	}
+	return models.APIKey{}, m.err
+}
+func (m *mockAPIKeyRepository) SelectAPIKeyByHash(ctx context.Context, hash []byte) (models.APIKey, error) {
+	if m.err != nil {
+		return models.APIKey{}, m.err
+	}
+	key, ok := m.hashes[string(hash)]
+	if !ok {
+		return models.APIKey{}, models.ErrModelNotFound
+	}
+	return key, nil
+}
+func (m *mockAPIKeyRepository) InsertAPIKey(ctx context.Context, key models.APIKey, hash []byte) (models.APIKey, error) {
+	return key, m.err
+}
+func (m *mockAPIKeyRepository) RevokeAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return m.err
+}
+func (m *mockAPIKeyRepository) RotateAPIKey(ctx context.Context, id uuid.UUID, replacement models.APIKey, hash []byte) (models.APIKey, error) {
+	return replacement, m.err
+}
+func (m *mockAPIKeyRepository) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	m.touched = append(m.touched, id)
+	return nil
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	now := time.Now().UTC()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	active := models.APIKey{Id: uuid.MustParse("b6a0f2c4-3f35-4d57-9a0d-3a7e2f0d8b11"), Name: "active", ExpiresAt: &future}
+	recentlyUsed := models.APIKey{Id: uuid.MustParse("0d5e7a61-4a3b-4c8e-9f3e-5b1c2d3e4f50"), Name: "recent", LastUsedAt: &now}
+	expired := models.APIKey{Id: uuid.New(), Name: "expired", ExpiresAt: &past}
+	revoked := models.APIKey{Id: uuid.New(), Name: "revoked", RevokedAt: &past}
+	repo := &mockAPIKeyRepository{hashes: map[string]models.APIKey{
+		string(hashAPIKey("gp_active")):  active,
+		string(hashAPIKey("gp_recent")):  recentlyUsed,
+		string(hashAPIKey("gp_expired")): expired,
+		string(hashAPIKey("gp_revoked")): revoked,
+	}}
+
+	type test struct {
+		headers     map[string]string
+		wantedCode  int
+		wantedError Problem
+	}
+
+	tests := []test{
+		{headers: map[string]string{"X-API-Key": "gp_active"}, wantedCode: 200},
+		{headers: map[string]string{"Authorization": "Bearer gp_active"}, wantedCode: 200},
+		{headers: map[string]string{"X-API-Key": "gp_recent"}, wantedCode: 200},
+		{headers: map[string]string{"X-API-Key": "gp_bootstrap"}, wantedCode: 200},
+		{
+			wantedCode:  401,
+			wantedError: wantProblem(ErrCodeUnauthorized, "An API key is required, send it in the X-API-Key header or as a bearer token"),
+		},
+		{
+			headers:     map[string]string{"Authorization": "Basic Z286cHJhY3RpY2U="},
+			wantedCode:  401,
+			wantedError: wantProblem(ErrCodeUnauthorized, "An API key is required, send it in the X-API-Key header or as a bearer token"),
+		},
+		{
+			headers:     map[string]string{"X-API-Key": "gp_unknown"},
+			wantedCode:  401,
+			wantedError: wantProblem(ErrCodeUnauthorized, "API key is not valid"),
+		},
+		{
+			headers:     map[string]string{"X-API-Key": "gp_expired"},
+			wantedCode:  401,
+			wantedError: wantProblem(ErrCodeUnauthorized, "API key has expired"),
+		},
+		{
+			headers:     map[string]string{"Authorization": "Bearer gp_revoked"},
+			wantedCode:  401,
+			wantedError: wantProblem(ErrCodeUnauthorized, "API key has been revoked"),
+		},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		router.Use(Authenticate(AuthConfig{APIKeys: repo, BootstrapKey: "gp_bootstrap", TouchInterval: time.Minute}))
+		router.GET("/protected", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		for name, value := range testCase.headers {
+			req.Header.Set(name, value)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		if testCase.wantedCode != 200 {
+			assert.Equal(t, parseProblem(t, w), testCase.wantedError)
+			assert.Equal(t, strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer"), true)
+		}
+	}
+
+	//Last use is only recorded for stored keys, and not again within the touch interval
+	assert.Equal(t, repo.touched, []uuid.UUID{active.Id, active.Id})
+}
+
+func TestPublicRoutesSkipAPIKey(t *testing.T) {
+	router := SetupRouter(RouterConfig{})
+	router.Use(Authenticate(AuthConfig{APIKeys: &mockAPIKeyRepository{}}))
+
+	for _, path := range []string{"/healthz", "/docs/index.html"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		//the swagger spec is not registered in tests, so /docs answers 404 rather than 200
+		assert.Equal(t, w.Code != http.StatusUnauthorized, true)
+	}
+}
+
+func TestAPIKeyAdminRoutes(t *testing.T) {
+	existing := models.APIKey{Id: uuid.MustParse("b6a0f2c4-3f35-4d57-9a0d-3a7e2f0d8b11"), Name: "ci", Prefix: "gp_abcdefgh", Admin: false}
+	reader := models.APIKey{Id: uuid.New(), Name: "reader"}
+
+	type test struct {
+		method      string
+		path        string
+		requestBody string
+		apiKey      string
+		mockResult  mockAPIKeyRepository
+		wantedCode  int
+		wantedError Problem
+	}
+
+	tests := []test{
+		{method: "GET", path: "/admin/keys/", apiKey: "gp_admin", mockResult: mockAPIKeyRepository{keys: []models.APIKey{existing}}, wantedCode: 200},
+		{
+			method:      "GET",
+			path:        "/admin/keys/",
+			apiKey:      "gp_reader",
+			wantedCode:  403,
+			wantedError: wantProblem(ErrCodeForbidden, "The admin role is required"),
+		},
+		{method: "POST", path: "/admin/keys/", apiKey: "gp_admin", requestBody: `{"name":"ci"}`, wantedCode: 201},
+		{
+			method:      "POST",
+			path:        "/admin/keys/",
+			apiKey:      "gp_admin",
+			requestBody: `{"name":"ci", "expiresAt":"2001-01-01T00:00:00Z"}`,
+			wantedCode:  400,
+			wantedError: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "expiresAt", Code: "gt", Message: "must be in the future"}),
+		},
+		{method: "DELETE", path: "/admin/keys/" + existing.Id.String(), apiKey: "gp_admin", wantedCode: 204},
+		{
+			method:      "DELETE",
+			path:        "/admin/keys/" + existing.Id.String(),
+			apiKey:      "gp_admin",
+			mockResult:  mockAPIKeyRepository{err: models.ErrModelNotFound},
+			wantedCode:  404,
+			wantedError: wantProblem(ErrCodeAPIKeyNotFound, "No API key exists with Id [b6a0f2c4-3f35-4d57-9a0d-3a7e2f0d8b11]"),
+		},
+		{method: "POST", path: "/admin/keys/" + existing.Id.String() + "/rotate", apiKey: "gp_admin", mockResult: mockAPIKeyRepository{keys: []models.APIKey{existing}}, wantedCode: 201},
+		{
+			method:      "POST",
+			path:        "/admin/keys/" + existing.Id.String() + "/rotate",
+			apiKey:      "gp_admin",
+			mockResult:  mockAPIKeyRepository{keys: []models.APIKey{existing}, err: errors.New("Kaboom!")},
+			wantedCode:  500,
+			wantedError: wantProblem(ErrCodeInternal, "Error rotating API key"),
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase.mockResult.hashes = map[string]models.APIKey{string(hashAPIKey("gp_reader")): reader}
+		router := SetupRouter(RouterConfig{})
+		router.Use(Authenticate(AuthConfig{APIKeys: &testCase.mockResult, BootstrapKey: "gp_admin", TouchInterval: time.Minute}))
+		RegisterAPIKeyRoutes(router, &testCase.mockResult)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(testCase.method, testCase.path, bytes.NewBufferString(testCase.requestBody))
+		req.Header.Set("X-API-Key", testCase.apiKey)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		switch {
+		case testCase.wantedCode == 201:
+			//the secret is only ever returned here, and must be the one the prefix and stored hash came from
+			parsedResp := createdAPIKey{}
+			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			assert.Equal(t, strings.HasPrefix(parsedResp.Secret, apiKeySecretPrefix), true)
+			assert.Equal(t, parsedResp.Prefix, parsedResp.Secret[:apiKeyPrefixLength])
+			assert.Equal(t, parsedResp.Name, "ci")
+		case testCase.wantedCode == 200:
+			parsedResp := []models.APIKey{}
+			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			assert.Equal(t, parsedResp, []models.APIKey{existing})
+		case testCase.wantedCode >= 400:
+			assert.Equal(t, parseProblem(t, w), testCase.wantedError)
+		}
+	}
+}
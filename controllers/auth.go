@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/logging"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+const principalContextKey = "principal"
+
+// WWW-Authenticate challenges sent with 401 responses, the second when the credentials presented were rejected
+const (
+	bearerChallenge       = `Bearer realm="go-practice"`
+	invalidTokenChallenge = `Bearer realm="go-practice", error="invalid_token"`
+)
+
+// TokenVerifier validates a JWT bearer token and returns who it was issued to
+type TokenVerifier interface {
+	Verify(token string) (auth.Principal, error)
+}
+
+// AuthConfig holds what Authenticate needs to check credentials
+type AuthConfig struct {
+	//APIKeys looks up keys presented in the X-API-Key header or as an opaque bearer token
+	APIKeys models.APIKeyRepository
+	//BootstrapKey, when set, is accepted as an admin key without a database lookup so the first keys can be created
+	BootstrapKey string
+	//TouchInterval limits how often a key's last use is recorded, to keep authentication from writing on every request
+	TouchInterval time.Duration
+	//Tokens validates JWT bearer tokens, leave nil to only accept API keys
+	Tokens TokenVerifier
+}
+
+// credentials reads what the client authenticated with from the X-API-Key and Authorization headers
+func credentials(c *gin.Context) (apiKey string, token string) {
+	return parseCredentials(c.GetHeader(apiKeyHeader), c.GetHeader("Authorization"))
+}
+
+// parseCredentials picks the API key or token out of an API key header and an Authorization header. A bearer token in
+// JWT form (three dot separated segments) is a JWT, anything else is an API key, which never contains dots
+func parseCredentials(keyHeader, authorization string) (apiKey string, token string) {
+	if keyHeader != "" {
+		return keyHeader, ""
+	}
+	scheme, bearer, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", ""
+	}
+	bearer = strings.TrimSpace(bearer)
+	if strings.Count(bearer, ".") == 2 {
+		return "", bearer
+	}
+	return bearer, ""
+}
+
+// principalFrom returns the caller the request was authenticated as
+func principalFrom(c *gin.Context) (auth.Principal, bool) {
+	value, ok := c.Get(principalContextKey)
+	if !ok {
+		return auth.Principal{}, false
+	}
+	principal, ok := value.(auth.Principal)
+	return principal, ok
+}
+
+// clientKey identifies the caller for rate limits and quotas: the authenticated principal when there is one, and the
+// client IP for anonymous requests
+func clientKey(c *gin.Context) string {
+	if principal, ok := principalFrom(c); ok && principal.Id != "" {
+		return principal.Id
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func setPrincipal(c *gin.Context, principal auth.Principal) {
+	c.Set(principalContextKey, principal)
+	logger := logging.FromContext(c.Request.Context()).With(slog.String("principal", principal.Name))
+	c.Request = c.Request.WithContext(logging.WithContext(c.Request.Context(), logger))
+}
+
+// authenticator checks the credentials a caller presented, for Authenticate and the gRPC server
+type authenticator struct {
+	cfg           AuthConfig
+	bootstrapHash []byte
+}
+
+func newAuthenticator(cfg AuthConfig) *authenticator {
+	a := &authenticator{cfg: cfg}
+	if cfg.BootstrapKey != "" {
+		a.bootstrapHash = hashAPIKey(cfg.BootstrapKey)
+	}
+	return a
+}
+
+// authenticate returns who the caller presenting apiKey or token is. Admin API keys act as the admin role, other keys
+// as editor. Rejected credentials are reported as an UnauthorizedError
+func (a *authenticator) authenticate(ctx context.Context, apiKey, token string) (auth.Principal, error) {
+	switch {
+	case token != "" && a.cfg.Tokens != nil:
+		principal, err := a.cfg.Tokens.Verify(token)
+		if err != nil {
+			return auth.Principal{}, &UnauthorizedError{Detail: fmt.Sprintf("Bearer token is not valid: %s", err), Challenge: invalidTokenChallenge}
+		}
+		return principal, nil
+	case token != "":
+		return auth.Principal{}, &UnauthorizedError{Detail: "Bearer tokens are not accepted, use an API key", Challenge: invalidTokenChallenge}
+	case apiKey == "":
+		return auth.Principal{}, &UnauthorizedError{Detail: "An API key is required, send it in the X-API-Key header or as a bearer token", Challenge: bearerChallenge}
+	}
+
+	hash := hashAPIKey(apiKey)
+	if a.bootstrapHash != nil && subtle.ConstantTimeCompare(hash, a.bootstrapHash) == 1 {
+		return auth.Principal{Id: "apikey:bootstrap", Name: "bootstrap", Roles: []auth.Role{auth.RoleAdmin}}, nil
+	}
+
+	key, err := a.cfg.APIKeys.SelectAPIKeyByHash(ctx, hash)
+	if errors.Is(err, models.ErrModelNotFound) {
+		return auth.Principal{}, &UnauthorizedError{Detail: "API key is not valid", Challenge: invalidTokenChallenge}
+	}
+	if err != nil {
+		return auth.Principal{}, &InternalError{Detail: "Error checking API key", Err: err}
+	}
+
+	now := time.Now().UTC()
+	if key.RevokedAt != nil {
+		return auth.Principal{}, &UnauthorizedError{Detail: "API key has been revoked", Challenge: invalidTokenChallenge}
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return auth.Principal{}, &UnauthorizedError{Detail: "API key has expired", Challenge: invalidTokenChallenge}
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= a.cfg.TouchInterval {
+		//failing to record last use is not a reason to turn the request away
+		if err := a.cfg.APIKeys.TouchAPIKey(ctx, key.Id, now); err != nil {
+			logging.FromContext(ctx).Warn("failed to record API key use", "apiKeyId", key.Id, "error", err)
+		}
+	}
+
+	role := auth.RoleEditor
+	if key.Admin {
+		role = auth.RoleAdmin
+	}
+	return auth.Principal{Id: "apikey:" + key.Id.String(), Name: key.Name, Roles: []auth.Role{role}}, nil
+}
+
+// Authenticate rejects requests that do not present an active API key or a valid JWT, and records who made the
+// request for requireRole.
+// It must be added after /healthz, /readyz, /metrics and /docs are registered so those stay public
+func Authenticate(cfg AuthConfig) gin.HandlerFunc {
+	a := newAuthenticator(cfg)
+	return handle(func(c *gin.Context) error {
+		apiKey, token := credentials(c)
+		principal, err := a.authenticate(c.Request.Context(), apiKey, token)
+		var unauthorized *UnauthorizedError
+		if errors.As(err, &unauthorized) && unauthorized.Challenge != "" {
+			c.Header("WWW-Authenticate", unauthorized.Challenge)
+		}
+		if err != nil {
+			return err
+		}
+		setPrincipal(c, principal)
+		return nil
+	})
+}
+
+// AllowAnonymous treats every request as coming from an admin. It stands in for Authenticate when authentication is
+// turned off so the per route role checks still pass
+func AllowAnonymous() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(principalContextKey, auth.Principal{Name: "anonymous", Roles: []auth.Role{auth.RoleAdmin}})
+		c.Next()
+	}
+}
+
+// requireRole only lets through requests whose principal holds role or one that outranks it
+func requireRole(role auth.Role) gin.HandlerFunc {
+	return handle(func(c *gin.Context) error {
+		principal, ok := principalFrom(c)
+		if !ok {
+			return &UnauthorizedError{Detail: "Request was not authenticated"}
+		}
+		if !principal.Can(role) {
+			return &ForbiddenError{Detail: fmt.Sprintf("The %s role is required", role)}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+// mockTokenVerifier accepts the tokens in principals, which look like JWTs so Authenticate routes them here
+type mockTokenVerifier struct {
+	principals map[string]auth.Principal
+}
+
+func (m mockTokenVerifier) Verify(token string) (auth.Principal, error) {
+	principal, ok := m.principals[token]
+	if !ok {
+		return auth.Principal{}, errors.New("token signature is invalid")
+	}
+	return principal, nil
+}
+
+func TestRoutePermissions(t *testing.T) {
+	tokens := mockTokenVerifier{principals: map[string]auth.Principal{
+		"reader.jwt.token": {Name: "rita", Roles: []auth.Role{auth.RoleReader}},
+		"editor.jwt.token": {Name: "ed", Roles: []auth.Role{auth.RoleEditor}},
+		"admin.jwt.token":  {Name: "ada", Roles: []auth.Role{auth.RoleAdmin}},
+		"norole.jwt.token": {Name: "nobody"},
+	}}
+	keys := &mockAPIKeyRepository{hashes: map[string]models.APIKey{
+		string(hashAPIKey("gp_editor")): {Id: uuid.New(), Name: "ci"},
+	}}
+
+	const userPath = "/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee"
+	type test struct {
+		method        string
+		path          string
+		authorization string
+		wantedCode    int
+		wantedError   Problem
+	}
+
+	tests := []test{
+		{method: "GET", path: "/users/", authorization: "Bearer reader.jwt.token", wantedCode: 200},
+		{method: "GET", path: userPath + "/addresses", authorization: "Bearer reader.jwt.token", wantedCode: 200},
+		{
+			method:        "POST",
+			path:          "/users/",
+			authorization: "Bearer reader.jwt.token",
+			wantedCode:    403,
+			wantedError:   wantProblem(ErrCodeForbidden, "The editor role is required"),
+		},
+		{method: "POST", path: "/users/", authorization: "Bearer editor.jwt.token", wantedCode: 201},
+		{method: "PUT", path: userPath, authorization: "Bearer editor.jwt.token", wantedCode: 200},
+		{
+			method:        "DELETE",
+			path:          userPath,
+			authorization: "Bearer editor.jwt.token",
+			wantedCode:    403,
+			wantedError:   wantProblem(ErrCodeForbidden, "The admin role is required"),
+		},
+		{method: "DELETE", path: "/addresses/34ecb0a8-7184-42fa-8840-6fa5c496d161", authorization: "Bearer editor.jwt.token", wantedCode: 204},
+		{method: "DELETE", path: userPath, authorization: "Bearer admin.jwt.token", wantedCode: 204},
+		{
+			method:        "GET",
+			path:          "/users/",
+			authorization: "Bearer norole.jwt.token",
+			wantedCode:    403,
+			wantedError:   wantProblem(ErrCodeForbidden, "The reader role is required"),
+		},
+		{
+			method:        "GET",
+			path:          "/users/",
+			authorization: "Bearer forged.jwt.token",
+			wantedCode:    401,
+			wantedError:   wantProblem(ErrCodeUnauthorized, "Bearer token is not valid: token signature is invalid"),
+		},
+		//non-admin API keys act as editors
+		{method: "PUT", path: userPath, authorization: "Bearer gp_editor", wantedCode: 200},
+		{
+			method:        "DELETE",
+			path:          userPath,
+			authorization: "Bearer gp_editor",
+			wantedCode:    403,
+			wantedError:   wantProblem(ErrCodeForbidden, "The admin role is required"),
+		},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		router.Use(Authenticate(AuthConfig{APIKeys: keys, Tokens: tokens}))
+		RegisterRoutes(router,
+			&mockUserRepository{users: []models.User{{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Test", LastName: "User"}}},
+			&mockAddressRepository{addrs: []models.Address{}},
+			RouteVersions{},
+		)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(testCase.method, testCase.path, bytes.NewBufferString(`{"firstName":"Test", "lastName":"User"}`))
+		req.Header.Set("Authorization", testCase.authorization)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		if testCase.wantedCode >= 400 {
+			assert.Equal(t, parseProblem(t, w), testCase.wantedError)
+		}
+	}
+}
+
+func TestBearerTokensRejectedWithoutVerifier(t *testing.T) {
+	router := SetupRouter(RouterConfig{})
+	router.Use(Authenticate(AuthConfig{APIKeys: &mockAPIKeyRepository{}}))
+	RegisterRoutes(router, &mockUserRepository{users: []models.User{}}, nil, RouteVersions{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/", nil)
+	req.Header.Set("Authorization", "Bearer admin.jwt.token")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 401)
+	assert.Equal(t, parseProblem(t, w), wantProblem(ErrCodeUnauthorized, "Bearer tokens are not accepted, use an API key"))
+}
@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// batchRefPrefix marks an id that refers to a record created earlier in the same batch, e.g. "$newUser"
+const batchRefPrefix = "$"
+
+// Transactor runs fn in a database transaction, committing when it succeeds and rolling back when it returns an
+// error. It is implemented by *db.Cluster
+type Transactor interface {
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type batchOperation struct {
+	Op       string `json:"op" binding:"required,oneof=create update delete" enums:"create,update,delete"`
+	Resource string `json:"resource" binding:"required,oneof=user address" enums:"user,address"`
+	//Id is the record to update or delete, a UUID or "$" followed by the ref of a record created earlier
+	Id string `json:"id,omitempty" binding:"required_unless=Op create"`
+	//Ref names the record a create operation makes so later operations can refer to it
+	Ref  string          `json:"ref,omitempty" binding:"omitempty,max=64,batchref" maxLength:"64"`
+	Data json.RawMessage `json:"data,omitempty" binding:"required_unless=Op delete" swaggertype:"object"`
+}
+
+type batchBody struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchResult is the outcome of one operation: the status and record the matching single request would have returned
+type batchResult struct {
+	XMLName  xml.Name  `json:"-" xml:"result"`
+	Index    int       `json:"index" xml:"index"`
+	Op       string    `json:"op" xml:"op"`
+	Resource string    `json:"resource" xml:"resource"`
+	Ref      string    `json:"ref,omitempty" xml:"ref,omitempty"`
+	Id       uuid.UUID `json:"id" xml:"id"`
+	Status   int       `json:"status" xml:"status"`
+	Data     any       `json:"data,omitempty" xml:",omitempty" swaggertype:"object"`
+}
+
+type batchResponse struct {
+	XMLName xml.Name      `json:"-" xml:"batch"`
+	Results []batchResult `json:"results" xml:"results>result"`
+}
+
+// batchRecord is a record created earlier in the batch, looked up by its ref
+type batchRecord struct {
+	resource string
+	id       uuid.UUID
+}
+
+type batchHandler struct {
+	handler
+	tx            Transactor
+	maxOperations int
+}
+
+// RunBatch applies a list of create, update and delete operations on users and addresses in a single transaction
+// @Summary apply several user and address changes in one transaction
+// @Description Operations run in order and either all succeed or none are applied. A create operation can name its
+// @Description record with ref, and later operations can use "$" followed by that name as an id or address userId.
+// @Tags batch
+// @ID run-batch
+// @Accept json,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param data body batchBody true "operations to apply, in order"
+// @Param Idempotency-Key header string false "makes retries of this request safe, see the README"
+// @Success 200 {object} batchResponse
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /batch [post]
+func (h batchHandler) RunBatch(c *gin.Context) error {
+	//operation data is kept as raw JSON until the resource it belongs to is known, which XML cannot carry
+	if mediaType := strings.ToLower(c.ContentType()); slices.Contains(xmlMediaTypes, mediaType) {
+		return &UnsupportedMediaTypeError{ContentType: mediaType, Supported: "application/json, application/yaml or application/msgpack"}
+	}
+	var reqBody batchBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
+	}
+	switch {
+	case len(reqBody.Operations) == 0:
+		return &ValidationError{Err: &fieldRuleError{field: "operations", code: "required", message: "must hold at least one operation"}}
+	case len(reqBody.Operations) > h.maxOperations:
+		return &ValidationError{Err: &fieldRuleError{
+			field:   "operations",
+			code:    "max",
+			message: fmt.Sprintf("must hold at most %d operations", h.maxOperations),
+		}}
+	}
+
+	//every operation is checked before any of them runs, so a malformed batch never opens a transaction
+	principal, _ := principalFrom(c)
+	refs := make(map[string]int)
+	for i := range reqBody.Operations {
+		if err := checkOperation(principal, &reqBody.Operations[i], refs); err != nil {
+			return &BatchOperationError{Index: i, Field: fmt.Sprintf("operations[%d].", i), Err: err}
+		}
+		if ref := reqBody.Operations[i].Ref; ref != "" {
+			refs[ref] = i
+		}
+	}
+
+	results := make([]batchResult, 0, len(reqBody.Operations))
+	err := h.tx.InTx(c.Request.Context(), func(ctx context.Context) error {
+		created := make(map[string]batchRecord)
+		for i, op := range reqBody.Operations {
+			result, err := h.runOperation(ctx, i, op, created)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	var opErr *BatchOperationError
+	if errors.As(err, &opErr) {
+		return err
+	}
+	if err != nil {
+		return &InternalError{Detail: "Error committing batch", Err: err}
+	}
+	return respond(c, http.StatusOK, batchResponse{Results: results})
+}
+
+// checkOperation validates an operation on its own, before the batch runs. refs holds the index of the operation
+// that declared each ref so far
+func checkOperation(principal auth.Principal, op *batchOperation, refs map[string]int) error {
+	if err := validateBody(op); err != nil {
+		return err
+	}
+	switch {
+	case op.Op == "create" && op.Id != "":
+		return &ValidationError{Err: &fieldRuleError{field: "id", code: "excluded", message: "is assigned by the server, leave it out of create operations"}}
+	case op.Op != "create" && op.Ref != "":
+		return &ValidationError{Err: &fieldRuleError{field: "ref", code: "excluded", message: "can only name the record of a create operation"}}
+	case op.Op == "delete" && op.Resource == "user" && !principal.Can(auth.RoleAdmin):
+		return &ForbiddenError{Detail: fmt.Sprintf("The %s role is required to delete users", auth.RoleAdmin)}
+	}
+	if first, ok := refs[op.Ref]; ok {
+		return &ValidationError{Err: &fieldRuleError{field: "ref", code: "unique", message: fmt.Sprintf("is already used by operation [%d]", first)}}
+	}
+	return nil
+}
+
+// runOperation applies operation i of a batch inside its transaction, recording records it creates in created
+func (h batchHandler) runOperation(ctx context.Context, i int, op batchOperation, created map[string]batchRecord) (batchResult, error) {
+	fail := func(field string, err error) (batchResult, error) {
+		return batchResult{}, &BatchOperationError{Index: i, Field: fmt.Sprintf("operations[%d].%s", i, field), Err: err}
+	}
+
+	result := batchResult{Index: i, Op: op.Op, Resource: op.Resource, Ref: op.Ref}
+	if op.Op != "create" {
+		id, err := resolveId(op.Id, "id", op.Resource, created)
+		if err != nil {
+			return fail("", err)
+		}
+		result.Id = id
+	}
+
+	var err error
+	switch op.Resource + " " + op.Op {
+	case "user create":
+		var body addUpdateUserBody
+		if err := decodeOperationData(op.Data, &body, created); err != nil {
+			return fail("data.", err)
+		}
+		var user models.User
+		user, err = h.createUser(ctx, body)
+		result.Id, result.Status, result.Data = user.Id, http.StatusCreated, user
+	case "user update":
+		var body addUpdateUserBody
+		if err := decodeOperationData(op.Data, &body, created); err != nil {
+			return fail("data.", err)
+		}
+		result.Status = http.StatusOK
+		result.Data, err = h.updateUser(ctx, result.Id, body)
+	case "user delete":
+		result.Status = http.StatusNoContent
+		err = h.deleteUser(ctx, result.Id)
+	case "address create":
+		var body addUpdateAddressBody
+		if err := decodeOperationData(op.Data, &body, created); err != nil {
+			return fail("data.", err)
+		}
+		var addr models.Address
+		addr, err = h.createAddress(ctx, body)
+		result.Id, result.Status, result.Data = addr.Id, http.StatusCreated, addr
+	case "address update":
+		var body addUpdateAddressBody
+		if err := decodeOperationData(op.Data, &body, created); err != nil {
+			return fail("data.", err)
+		}
+		result.Status = http.StatusOK
+		result.Data, err = h.updateAddress(ctx, result.Id, body)
+	case "address delete":
+		result.Status = http.StatusNoContent
+		err = h.deleteAddress(ctx, result.Id)
+	}
+	if err != nil {
+		return fail("", err)
+	}
+
+	if op.Ref != "" {
+		created[op.Ref] = batchRecord{resource: op.Resource, id: result.Id}
+	}
+	return result, nil
+}
+
+// resolveId parses the id of a resource, looking up references to records created earlier in the batch. field names
+// where the id came from for error messages
+func resolveId(value string, field string, resource string, created map[string]batchRecord) (uuid.UUID, error) {
+	name, isRef := strings.CutPrefix(value, batchRefPrefix)
+	if !isRef {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return uuid.Nil, &InvalidIdError{Value: value, Err: err}
+		}
+		return id, nil
+	}
+
+	record, ok := created[name]
+	switch {
+	case !ok:
+		return uuid.Nil, &ValidationError{Err: &fieldRuleError{
+			field:   field,
+			code:    "reference",
+			message: fmt.Sprintf("refers to [%s], which no earlier operation created", name),
+		}}
+	case record.resource != resource:
+		return uuid.Nil, &ValidationError{Err: &fieldRuleError{
+			field:   field,
+			code:    "reference",
+			message: fmt.Sprintf("refers to [%s], but only %s records can be used here", name, resource),
+		}}
+	}
+	return record.id, nil
+}
+
+// decodeOperationData decodes and validates the data of an operation into obj, replacing a reference in userId with
+// the id of the user it names
+func decodeOperationData(data json.RawMessage, obj any, created map[string]batchRecord) error {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) == nil {
+		var userId string
+		if json.Unmarshal(fields["userId"], &userId) == nil && strings.HasPrefix(userId, batchRefPrefix) {
+			id, err := resolveId(userId, "userId", "user", created)
+			if err != nil {
+				return err
+			}
+			fields["userId"], _ = json.Marshal(id)
+			data, _ = json.Marshal(fields)
+		}
+	}
+
+	if err := decodeJSON(bytes.NewReader(data), obj); err != nil {
+		return &ValidationError{Err: err}
+	}
+	return validateBody(obj)
+}
+
+// RegisterBatchRoutes adds POST /batch, which applies user and address operations through the same code as the
+// single record routes, all in one transaction started by tx. Like RegisterRoutes it needs a principal set first
+func RegisterBatchRoutes(r *gin.Engine, tx Transactor, users models.UserRepository, addresses models.AddressRepository, maxOperations int) error {
+	if maxOperations <= 0 {
+		return fmt.Errorf("batch.maxOperations must be positive, got %d", maxOperations)
+	}
+	h := batchHandler{handler: handler{users: users, addresses: addresses}, tx: tx, maxOperations: maxOperations}
+
+	r.POST("/batch", negotiateContent(), requireRole(auth.RoleEditor), handle(h.RunBatch))
+	return nil
+}
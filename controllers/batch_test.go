@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+type mockTransactor struct {
+	committed  bool
+	rolledBack bool
+}
+
+func (m *mockTransactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := fn(ctx); err != nil {
+		m.rolledBack = true
+		return err
+	}
+	m.committed = true
+	return nil
+}
+
+func TestBatchRoute(t *testing.T) {
+	pat := models.User{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "Smith"}
+	home := models.Address{Id: uuid.MustParse("4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"), UserId: pat.Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"}
+
+	type test struct {
+		body             string
+		maxOperations    int
+		role             auth.Role
+		userErr          error
+		wantedCode       int
+		wantedBody       string
+		wantedProblem    Problem
+		wantedCommitted  bool
+		wantedRolledBack bool
+	}
+
+	tests := []test{
+		//later operations refer to the user created by the first one
+		{body: `{"operations":[
+			{"op":"create","resource":"user","ref":"pat","data":{"firstName":"Pat","lastName":"Smith"}},
+			{"op":"create","resource":"address","data":{"userId":"$pat","street":"1 Main St","city":"Boise","state":"ID","zip":"83702","type":"HOME"}},
+			{"op":"update","resource":"user","id":"$pat","data":{"firstName":"Pat","lastName":"Jones"}},
+			{"op":"delete","resource":"address","id":"4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"}]}`,
+			wantedCode: 200, wantedCommitted: true,
+			wantedBody: `{"results":[` +
+				`{"index":0,"op":"create","resource":"user","ref":"pat","id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","status":201,"data":{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","firstName":"Pat","lastName":"Smith"}},` +
+				`{"index":1,"op":"create","resource":"address","id":"4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11","status":201,"data":{"id":"4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11","userId":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","street":"1 Main St","city":"Boise","state":"ID","zip":"83702","type":"HOME"}},` +
+				`{"index":2,"op":"update","resource":"user","id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","status":200,"data":{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","firstName":"Pat","lastName":"Jones"}},` +
+				`{"index":3,"op":"delete","resource":"address","id":"4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11","status":204}]}`},
+		//a failed operation rolls back the ones before it
+		{body: `{"operations":[
+			{"op":"create","resource":"address","data":{"userId":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","street":"1 Main St","city":"Boise","state":"ID","zip":"83702","type":"HOME"}},
+			{"op":"update","resource":"user","id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","data":{"firstName":"Pat","lastName":"Jones"}}]}`,
+			userErr: models.ErrModelNotFound, wantedCode: 404, wantedRolledBack: true,
+			wantedProblem: wantProblem(ErrCodeUserNotFound, "Operation [0] failed, no changes were made: No user exists with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]")},
+		{body: `{"operations":[
+			{"op":"create","resource":"user","ref":"pat","data":{"firstName":"Pat","lastName":"Smith"}},
+			{"op":"update","resource":"user","id":"$pat","data":{"firstName":"Pat","lastName":""}}]}`,
+			wantedCode: 400, wantedRolledBack: true,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Operation [1] failed, no changes were made: Request body is malformed or failed validation",
+				FieldError{Field: "operations[1].data.lastName", Code: "required", Message: "is required"})},
+		{body: `{"operations":[{"op":"update","resource":"user","id":"$sam","data":{"firstName":"Pat","lastName":"Smith"}}]}`,
+			wantedCode: 400, wantedRolledBack: true,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Operation [0] failed, no changes were made: Request body is malformed or failed validation",
+				FieldError{Field: "operations[0].id", Code: "reference", Message: "refers to [sam], which no earlier operation created"})},
+		{body: `{"operations":[
+			{"op":"create","resource":"address","ref":"home","data":{"userId":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","street":"1 Main St","city":"Boise","state":"ID","zip":"83702","type":"HOME"}},
+			{"op":"create","resource":"address","data":{"userId":"$home","street":"1 Main St","city":"Boise","state":"ID","zip":"83702","type":"HOME"}}]}`,
+			wantedCode: 400, wantedRolledBack: true,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Operation [1] failed, no changes were made: Request body is malformed or failed validation",
+				FieldError{Field: "operations[1].data.userId", Code: "reference", Message: "refers to [home], but only user records can be used here"})},
+		//malformed operations are rejected before the transaction starts
+		{body: `{"operations":[{"op":"create","resource":"user","data":{"firstName":"Pat","lastName":"Smith"}},{"op":"upsert","resource":"user"}]}`,
+			wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Operation [1] failed, no changes were made: Request body is malformed or failed validation",
+				FieldError{Field: "operations[1].op", Code: "oneof", Message: "must be one of create, update, delete"},
+				FieldError{Field: "operations[1].id", Code: "required_unless", Message: "is required"},
+				FieldError{Field: "operations[1].data", Code: "required_unless", Message: "is required"})},
+		{body: `{"operations":[
+			{"op":"create","resource":"user","ref":"pat","data":{"firstName":"Pat","lastName":"Smith"}},
+			{"op":"create","resource":"user","ref":"pat","data":{"firstName":"Sam","lastName":"Smith"}}]}`,
+			wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Operation [1] failed, no changes were made: Request body is malformed or failed validation",
+				FieldError{Field: "operations[1].ref", Code: "unique", Message: "is already used by operation [0]"})},
+		{body: `{"operations":[{"op":"delete","resource":"user","id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee"}]}`, role: auth.RoleEditor,
+			wantedCode:    403,
+			wantedProblem: wantProblem(ErrCodeForbidden, "Operation [0] failed, no changes were made: The admin role is required to delete users")},
+		{body: `{"operations":[]}`, wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "operations", Code: "required", Message: "must hold at least one operation"})},
+		{body: `{"operations":[{"op":"delete","resource":"address","id":"$a"},{"op":"delete","resource":"address","id":"$b"},{"op":"delete","resource":"address","id":"$c"}]}`,
+			maxOperations: 2, wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "operations", Code: "max", Message: "must hold at most 2 operations"})},
+	}
+
+	for _, testCase := range tests {
+		maxOperations := testCase.maxOperations
+		if maxOperations == 0 {
+			maxOperations = 10
+		}
+		tx := &mockTransactor{}
+		router := SetupRouter(RouterConfig{})
+		router.Use(func(c *gin.Context) {
+			role := testCase.role
+			if role == "" {
+				role = auth.RoleAdmin
+			}
+			c.Set(principalContextKey, auth.Principal{Name: "sync", Roles: []auth.Role{role}})
+		})
+		err := RegisterBatchRoutes(router, tx, &mockUserRepository{users: []models.User{pat}, err: testCase.userErr},
+			&mockAddressRepository{addrs: []models.Address{home}}, maxOperations)
+		assert.Equal(t, err, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/batch?pretty=false", strings.NewReader(testCase.body))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		assert.Equal(t, tx.committed, testCase.wantedCommitted)
+		assert.Equal(t, tx.rolledBack, testCase.wantedRolledBack)
+		if testCase.wantedCode >= 400 {
+			assert.Equal(t, parseProblem(t, w), testCase.wantedProblem)
+		} else {
+			assert.Equal(t, w.Body.String(), testCase.wantedBody)
+		}
+	}
+}
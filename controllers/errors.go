@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var tracer = otel.Tracer("github.com/lengebretsen/go-practice/controllers")
+
+// InvalidIdError is returned when a path parameter that should hold a UUID does not
+type InvalidIdError struct {
+	Value string
+	Err   error
+}
+
+func (e *InvalidIdError) Error() string {
+	return fmt.Sprintf("Id [%s] is not a valid UUID: %s", e.Value, e.Err)
+}
+
+func (e *InvalidIdError) Unwrap() error { return e.Err }
+
+// ValidationError is returned when a request body cannot be decoded or fails validation
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// InvalidQueryError is returned when a query parameter holds a value the endpoint does not support
+type InvalidQueryError struct {
+	Param  string
+	Detail string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return fmt.Sprintf("invalid %s parameter: %s", e.Param, e.Detail)
+}
+
+// UnauthorizedError is returned when a request does not carry valid credentials
+type UnauthorizedError struct {
+	Detail string
+	//Challenge is sent back in the WWW-Authenticate header when set
+	Challenge string
+}
+
+func (e *UnauthorizedError) Error() string { return e.Detail }
+
+// ForbiddenError is returned when the caller is authenticated but not allowed to make the request
+type ForbiddenError struct {
+	Detail string
+}
+
+func (e *ForbiddenError) Error() string { return e.Detail }
+
+// NotFoundError is returned when the record a request refers to does not exist
+type NotFoundError struct {
+	Resource string
+	Id       string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("No %s exists with Id [%s]", e.Resource, e.Id)
+}
+
+// ConflictError is returned when a write clashes with existing data, such as a duplicate key
+type ConflictError struct {
+	Detail string
+	Err    error
+}
+
+func (e *ConflictError) Error() string { return fmt.Sprintf("%s: %s", e.Detail, e.Err) }
+
+func (e *ConflictError) Unwrap() error { return e.Err }
+
+// TooManyRequestsError is returned when a client has gone over a rate limit or quota. Code says which
+type TooManyRequestsError struct {
+	Code   ErrorCode
+	Detail string
+}
+
+func (e *TooManyRequestsError) Error() string { return e.Detail }
+
+// IdempotencyError is returned when a request's Idempotency-Key header cannot be honoured. Code says why
+type IdempotencyError struct {
+	Code   ErrorCode
+	Detail string
+}
+
+func (e *IdempotencyError) Error() string { return e.Detail }
+
+// RequestTooLargeError is returned when a request body is longer than the Limit in bytes the endpoint reads
+type RequestTooLargeError struct {
+	Limit int64
+}
+
+func (e *RequestTooLargeError) Error() string {
+	return fmt.Sprintf("Request body is larger than the %d bytes allowed", e.Limit)
+}
+
+// NotAcceptableError is returned when the response cannot be written in any media type the Accept header allows
+type NotAcceptableError struct {
+	Detail string
+}
+
+func (e *NotAcceptableError) Error() string { return e.Detail }
+
+// UnsupportedMediaTypeError is returned when a request body is sent in a media type the API cannot read
+type UnsupportedMediaTypeError struct {
+	ContentType string
+	//Supported lists the media types the endpoint reads, when it does not read every body format
+	Supported string
+}
+
+func (e *UnsupportedMediaTypeError) Error() string {
+	supported := e.Supported
+	if supported == "" {
+		supported = "application/json, application/xml, application/yaml or application/msgpack"
+	}
+	return fmt.Sprintf("Content-Type [%s] is not supported, send %s", e.ContentType, supported)
+}
+
+// BatchOperationError is returned when one operation of a batch fails, which rolls back the whole batch. Field is
+// prefixed to the fields named in validation failures so they point into the batch body, e.g. operations[2].data.
+type BatchOperationError struct {
+	Index int
+	Field string
+	Err   error
+}
+
+func (e *BatchOperationError) Error() string {
+	return fmt.Sprintf("operation [%d] failed: %s", e.Index, e.Err)
+}
+
+func (e *BatchOperationError) Unwrap() error { return e.Err }
+
+// InternalError wraps an unexpected failure. Detail is safe to show clients, Err is only shown in debug mode
+type InternalError struct {
+	Detail string
+	Err    error
+}
+
+func (e *InternalError) Error() string { return fmt.Sprintf("%s: %s", e.Detail, e.Err) }
+
+func (e *InternalError) Unwrap() error { return e.Err }
+
+// handlerFunc is a route handler that reports failures by returning an error instead of writing a response
+type handlerFunc func(c *gin.Context) error
+
+// handle adapts a handlerFunc to gin, recording any returned error for errorResponses to turn into a response. Each
+// call runs in a span named after fn, carried on the request context so the SQL spans it causes are its children
+func handle(fn handlerFunc) gin.HandlerFunc {
+	name := handlerName(fn)
+	return func(c *gin.Context) {
+		parent := c.Request.Context()
+		ctx, span := tracer.Start(parent, name)
+		c.Request = c.Request.WithContext(ctx)
+		err := fn(c)
+		//later handlers in the chain are siblings of this span, not children of it
+		c.Request = c.Request.WithContext(parent)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			c.Error(err)
+			c.Abort()
+		}
+		span.End()
+	}
+}
+
+// closureSuffix matches the ".func1" (or ".func1.2" when nested) the compiler appends to a closure's name
+var closureSuffix = regexp.MustCompile(`(\.func\d+)(\.\d+)*$`)
+
+// handlerName names fn after the method or function it was declared as, e.g. "handler.AddAddress", or after the
+// function that returned it for closures, e.g. "requireRole"
+func handlerName(fn handlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = closureSuffix.ReplaceAllString(strings.TrimSuffix(name, "-fm"), "")
+	//drop the package and the pointer receiver syntax
+	name = name[strings.Index(name, ".")+1:]
+	return strings.NewReplacer("(*", "", ")", "").Replace(name)
+}
+
+// errorResponses writes a problem response for the last error recorded by a handler that did not write one itself,
+// with the internal cause of server errors in the detail when debug is set. It must be the innermost middleware so
+// the outer ones (access log, metrics) see the final status
+func errorResponses(debug bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		//kept on the request for handlers that write problems themselves, such as the GraphQL resolvers
+		c.Set(debugContextKey, debug)
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		abortWith(c, problemFor(c, c.Errors.Last().Err))
+	}
+}
+
+// parseId reads the named path parameter as a UUID
+func parseId(c *gin.Context, param string) (uuid.UUID, error) {
+	return parseUUID(c.Param(param))
+}
+
+// parseUUID reads an id sent outside a path parameter, such as in a gRPC request
+func parseUUID(value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, &InvalidIdError{Value: value, Err: err}
+	}
+	return id, nil
+}
+
+// repositoryError converts an error from a repository call on the resource with the given id into the matching typed
+// error, with detail describing the failed operation for unexpected errors
+func repositoryError(err error, resource string, id uuid.UUID, detail string) error {
+	switch statusForError(err) {
+	case http.StatusNotFound:
+		return &NotFoundError{Resource: resource, Id: id.String()}
+	case http.StatusConflict:
+		return &ConflictError{Detail: detail, Err: err}
+	default:
+		return &InternalError{Detail: detail, Err: err}
+	}
+}
+
+// problemFor maps an error returned by a handler to the problem reported to the client
+func problemFor(c *gin.Context, err error) Problem {
+	problem := problemOf(err, c.GetBool(debugContextKey))
+	problem.Instance = c.Request.URL.Path
+	problem.RequestId = requestIDFrom(c)
+	return problem
+}
+
+// problemOf maps a typed error to its problem without the details of the request it failed, so APIs served outside
+// gin can report failures the same way. debug adds the internal cause to the detail of server errors
+func problemOf(err error, debug bool) Problem {
+	var batchOp *BatchOperationError
+	var invalidId *InvalidIdError
+	var validation *ValidationError
+	var invalidQuery *InvalidQueryError
+	var unauthorized *UnauthorizedError
+	var forbidden *ForbiddenError
+	var notFound *NotFoundError
+	var conflict *ConflictError
+	var tooMany *TooManyRequestsError
+	var idempotency *IdempotencyError
+	var tooLarge *RequestTooLargeError
+	var notAcceptable *NotAcceptableError
+	var unsupported *UnsupportedMediaTypeError
+	var internal *InternalError
+
+	switch {
+	//checked first because the error it wraps would match one of the cases below
+	case errors.As(err, &batchOp):
+		problem := problemOf(batchOp.Err, debug)
+		problem.Detail = fmt.Sprintf("Operation [%d] failed, no changes were made: %s", batchOp.Index, problem.Detail)
+		for i := range problem.Errors {
+			problem.Errors[i].Field = batchOp.Field + problem.Errors[i].Field
+		}
+		return problem
+	case errors.As(err, &invalidId):
+		return codeProblem(ErrCodeInvalidId, invalidId.Error())
+	case errors.As(err, &validation):
+		return validationProblem(validation.Err)
+	case errors.As(err, &invalidQuery):
+		return codeProblem(ErrCodeInvalidQuery, invalidQuery.Detail)
+	case errors.As(err, &unauthorized):
+		return codeProblem(ErrCodeUnauthorized, unauthorized.Detail)
+	case errors.As(err, &forbidden):
+		return codeProblem(ErrCodeForbidden, forbidden.Detail)
+	case errors.As(err, &notFound):
+		code, ok := notFoundCodes[notFound.Resource]
+		if !ok {
+			code = ErrCodeNotFound
+		}
+		return codeProblem(code, notFound.Error())
+	case errors.As(err, &conflict):
+		return codeProblem(ErrCodeConflict, conflict.Detail)
+	case errors.As(err, &tooMany):
+		return codeProblem(tooMany.Code, tooMany.Detail)
+	case errors.As(err, &idempotency):
+		return codeProblem(idempotency.Code, idempotency.Detail)
+	case errors.As(err, &tooLarge):
+		return codeProblem(ErrCodeRequestTooLarge, tooLarge.Error())
+	case errors.As(err, &notAcceptable):
+		return codeProblem(ErrCodeNotAcceptable, notAcceptable.Detail)
+	case errors.As(err, &unsupported):
+		return codeProblem(ErrCodeUnsupportedMediaType, unsupported.Error())
+	case errors.As(err, &internal):
+		return codeProblem(ErrCodeInternal, debugDetail(internal.Detail, internal.Err, debug))
+	default:
+		return codeProblem(ErrCodeInternal, debugDetail("Unexpected error", err, debug))
+	}
+}
+
+// validationProblem reports a request body that could not be decoded or failed validation, listing each offending
+// field when the failure can be traced to one
+func validationProblem(err error) Problem {
+	problem := codeProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation")
+
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var unknownErr *unknownFieldError
+	var ruleErr *fieldRuleError
+	switch {
+	case errors.As(err, &validationErrs):
+		for _, fieldErr := range validationErrs {
+			problem.Errors = append(problem.Errors, FieldError{
+				Field:   fieldErr.Field(),
+				Code:    fieldErr.Tag(),
+				Message: validationMessage(fieldErr),
+			})
+		}
+	case errors.As(err, &typeErr):
+		problem.Errors = []FieldError{{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: fmt.Sprintf("must be a %s", typeErr.Type),
+		}}
+	case errors.As(err, &unknownErr):
+		problem.Errors = []FieldError{{
+			Field:   unknownErr.field,
+			Code:    "unknown",
+			Message: "is not a recognized field",
+		}}
+	case errors.As(err, &ruleErr):
+		problem.Errors = []FieldError{{
+			Field:   ruleErr.field,
+			Code:    ruleErr.code,
+			Message: ruleErr.message,
+		}}
+	default:
+		problem.Detail = fmt.Sprintf("Request body is malformed: %s", err)
+	}
+	return problem
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryList splits a comma separated query parameter, dropping blank entries
+func queryList(c *gin.Context, param string) []string {
+	var values []string
+	for _, value := range strings.Split(c.Query(param), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseInclude reads ?include=, reporting which of the supported relations the client asked to embed
+func parseInclude(c *gin.Context, supported ...string) (map[string]bool, error) {
+	include := make(map[string]bool)
+	for _, relation := range queryList(c, "include") {
+		if !slices.Contains(supported, relation) {
+			return nil, &InvalidQueryError{
+				Param:  "include",
+				Detail: fmt.Sprintf("Cannot include [%s], supported relations are: %s", relation, strings.Join(supported, ", ")),
+			}
+		}
+		include[relation] = true
+	}
+	return include, nil
+}
+
+// jsonName is the name a struct field is written under in JSON, or "" when it is left out
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch {
+	case !field.IsExported() || name == "-" || (field.Anonymous && field.Type.Kind() == reflect.Struct):
+		return ""
+	case name == "":
+		return field.Name
+	}
+	return name
+}
+
+// selectFields trims data, a record or list of records, to the fields named in ?fields= plus any listed in keep,
+// returning it unchanged when the parameter is missing. The trimmed records are values of a struct type built from
+// the selected fields and their tags, so every response format renders them just like the full records
+func selectFields(c *gin.Context, data any, keep ...string) (any, error) {
+	requested := queryList(c, "fields")
+	if len(requested) == 0 {
+		return data, nil
+	}
+
+	value := reflect.ValueOf(data)
+	recordType := value.Type()
+	if recordType.Kind() == reflect.Slice {
+		recordType = recordType.Elem()
+	}
+
+	var available []string
+	var selected []reflect.StructField
+	var indexes [][]int
+	for _, field := range reflect.VisibleFields(recordType) {
+		if field.Name == "XMLName" {
+			//kept so XML responses are still named after the record
+			selected = append(selected, reflect.StructField{Name: field.Name, Type: field.Type, Tag: field.Tag})
+			indexes = append(indexes, field.Index)
+			continue
+		}
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		available = append(available, name)
+		if slices.Contains(requested, name) || slices.Contains(keep, name) {
+			selected = append(selected, reflect.StructField{Name: field.Name, Type: field.Type, Tag: field.Tag})
+			indexes = append(indexes, field.Index)
+		}
+	}
+	for _, name := range requested {
+		if !slices.Contains(available, name) {
+			return nil, &InvalidQueryError{
+				Param:  "fields",
+				Detail: fmt.Sprintf("Unknown field [%s], available fields are: %s", name, strings.Join(available, ", ")),
+			}
+		}
+	}
+
+	sparseType := reflect.StructOf(selected)
+	trim := func(record reflect.Value) reflect.Value {
+		sparse := reflect.New(sparseType).Elem()
+		for i, index := range indexes {
+			sparse.Field(i).Set(record.FieldByIndex(index))
+		}
+		return sparse
+	}
+	if value.Kind() != reflect.Slice {
+		return trim(value).Interface(), nil
+	}
+	list := reflect.MakeSlice(reflect.SliceOf(sparseType), value.Len(), value.Len())
+	for i := 0; i < value.Len(); i++ {
+		list.Index(i).Set(trim(value.Index(i)))
+	}
+	return list.Interface(), nil
+}
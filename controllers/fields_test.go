@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+func TestSparseFieldsAndIncludes(t *testing.T) {
+	pat := models.User{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "Smith"}
+	sam := models.User{Id: uuid.MustParse("a3b2c1d0-0000-4000-8000-000000000001"), FirstName: "Sam", LastName: "Jones"}
+	home := models.Address{Id: uuid.MustParse("4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"), UserId: pat.Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"}
+
+	type test struct {
+		path              string
+		accept            string
+		users             []models.User
+		wantedCode        int
+		wantedContentType string
+		wantedBody        string
+		wantedProblem     Problem
+		wantedBatches     int
+	}
+
+	tests := []test{
+		{path: "/users/?pretty=false&fields=lastName,id", users: []models.User{pat, sam}, wantedCode: 200,
+			wantedBody: `[{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","lastName":"Smith"},{"id":"a3b2c1d0-0000-4000-8000-000000000001","lastName":"Jones"}]`},
+		{path: "/users/?fields=id,+lastName", users: []models.User{pat}, accept: "text/csv", wantedCode: 200,
+			wantedContentType: "text/csv; charset=utf-8", wantedBody: "id,lastName\n493adb28-9da1-4db8-893d-73cc2d7bd4ee,Smith\n"},
+		//every user's addresses come from one batched lookup, users without any get an empty list
+		{path: "/users/?pretty=false&include=addresses", users: []models.User{pat, sam}, wantedCode: 200, wantedBatches: 1,
+			wantedBody: `[{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","firstName":"Pat","lastName":"Smith","addresses":[{"id":"4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11","userId":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","street":"1 Main St","city":"Boise","state":"ID","zip":"83702","type":"HOME"}]},` +
+				`{"id":"a3b2c1d0-0000-4000-8000-000000000001","firstName":"Sam","lastName":"Jones","addresses":[]}]`},
+		{path: "/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee?include=addresses&fields=firstName", users: []models.User{pat}, accept: "application/xml", wantedCode: 200, wantedBatches: 1,
+			wantedContentType: "application/xml; charset=utf-8",
+			wantedBody:        `<user><firstName>Pat</firstName><addresses><address><id>4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11</id><userId>493adb28-9da1-4db8-893d-73cc2d7bd4ee</userId><street>1 Main St</street><city>Boise</city><state>ID</state><zip>83702</zip><type>HOME</type></address></addresses></user>`},
+		//embedded lists cannot be written as CSV
+		{path: "/users/?include=addresses", users: []models.User{pat}, accept: "text/csv", wantedCode: 406, wantedBatches: 1,
+			wantedProblem: wantProblem(ErrCodeNotAcceptable, "None of the media types in the Accept header can be produced. Supported types are application/json, application/xml, application/yaml, application/msgpack and, for lists, text/csv")},
+		{path: "/users/?fields=id,middleName", users: []models.User{pat}, wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidQuery, "Unknown field [middleName], available fields are: id, firstName, lastName")},
+		{path: "/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee?include=orders", users: []models.User{pat}, wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidQuery, "Cannot include [orders], supported relations are: addresses")},
+		{path: "/addresses/?pretty=false&fields=city,type", wantedCode: 200,
+			wantedBody: `[{"city":"Boise","type":"HOME"}]`},
+	}
+
+	for _, testCase := range tests {
+		addrs := &mockAddressRepository{addrs: []models.Address{home}}
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &mockUserRepository{users: testCase.users}, addrs, RouteVersions{})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", testCase.path, nil)
+		req.Header.Set("Accept", testCase.accept)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		assert.Equal(t, addrs.batches, testCase.wantedBatches)
+		if testCase.wantedCode >= 400 {
+			assert.Equal(t, parseProblem(t, w), testCase.wantedProblem)
+			continue
+		}
+		if testCase.wantedContentType != "" {
+			assert.Equal(t, w.Header().Get("Content-Type"), testCase.wantedContentType)
+		}
+		assert.Equal(t, w.Body.String(), testCase.wantedBody)
+	}
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/logging"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+//go:embed schema.graphql
+var graphSchema string
+
+//go:embed graphiql.html
+var graphiQLPage []byte
+
+// graphContextKey holds the gin context of a GraphQL request in the context passed to resolvers
+type graphContextKey struct{}
+
+// graphRequest is a GraphQL request as sent by GraphiQL and most clients
+type graphRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables" swaggertype:"object"`
+}
+
+type graphHandler struct {
+	schema *graphql.Schema
+}
+
+// Serve runs a GraphQL query or mutation. Failures in resolvers are reported in the errors list of a 200 response,
+// as GraphQL clients expect, so only a request that cannot be read gets a problem response
+// @Summary run a GraphQL query or mutation against the schema explorable at /docs/graphiql
+// @Tags graphql
+// @ID graphql
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param data body graphRequest true "GraphQL request"
+// @Success 200 {object} object "data and errors, as described by the GraphQL spec"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /graphql [post]
+func (h graphHandler) Serve(c *gin.Context) error {
+	if mediaType := strings.ToLower(c.ContentType()); mediaType != "" && !slices.Contains(jsonMediaTypes, mediaType) {
+		return &UnsupportedMediaTypeError{ContentType: mediaType, Supported: "application/json"}
+	}
+	var req graphRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errEmptyBody
+		}
+		return &ValidationError{Err: err}
+	}
+
+	ctx := context.WithValue(c.Request.Context(), graphContextKey{}, c)
+	c.JSON(http.StatusOK, h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+	return nil
+}
+
+// graphError reports a failed resolver with the code and field errors the REST API gives for the same failure
+type graphError struct {
+	problem Problem
+}
+
+func (e *graphError) Error() string { return e.problem.Detail }
+
+// Extensions is added to the error's entry in the response by the GraphQL executor
+func (e *graphError) Extensions() map[string]any {
+	extensions := map[string]any{"code": e.problem.Code, "status": e.problem.Status}
+	if len(e.problem.Errors) > 0 {
+		extensions["errors"] = e.problem.Errors
+	}
+	return extensions
+}
+
+// graphFail converts a typed error from a resolver into a graphError. Server errors are logged here, as they never
+// reach the access log the way handler errors do
+func graphFail(ctx context.Context, err error) error {
+	c := ctx.Value(graphContextKey{}).(*gin.Context)
+	problem := problemFor(c, err)
+	if problem.Status >= http.StatusInternalServerError {
+		logging.FromContext(ctx).Error("graphql resolver failed", "error", err)
+	}
+	return &graphError{problem: problem}
+}
+
+// requireGraphRole checks the caller holds role, for mutations that need more than the reader role /graphql requires
+func requireGraphRole(ctx context.Context, role auth.Role) error {
+	principal, _ := principalFrom(ctx.Value(graphContextKey{}).(*gin.Context))
+	if !principal.Can(role) {
+		return graphFail(ctx, &ForbiddenError{Detail: fmt.Sprintf("The %s role is required", role)})
+	}
+	return nil
+}
+
+// RegisterGraphQLRoutes adds POST /graphql, serving schema.graphql from the same repositories and validation as the
+// REST routes. Queries need the reader role and each mutation the role of the matching REST route. Queries nested
+// deeper than maxDepth are rejected
+func RegisterGraphQLRoutes(r *gin.Engine, users models.UserRepository, addresses models.AddressRepository, maxDepth int) error {
+	if maxDepth <= 0 {
+		return fmt.Errorf("graphql.maxDepth must be positive, got %d", maxDepth)
+	}
+	resolver := &graphResolver{h: handler{users: users, addresses: addresses}}
+	schema, err := graphql.ParseSchema(graphSchema, resolver, graphql.UseStringDescriptions(), graphql.MaxDepth(maxDepth))
+	if err != nil {
+		return fmt.Errorf("invalid GraphQL schema: %w", err)
+	}
+
+	r.POST("/graphql", requireRole(auth.RoleReader), handle(graphHandler{schema: schema}.Serve))
+	return nil
+}
@@ -0,0 +1,415 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// relationLoader loads a relation for a set of records resolved together, such as one page of users, the first time
+// any of them asks for it. The whole set shares one query, so nested fields cost a query per level rather than one
+// per record, without holding resolvers back on a timer the way a time-windowed dataloader does
+type relationLoader[V any] struct {
+	ids  []uuid.UUID
+	load func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]V, error)
+
+	once   sync.Once
+	values map[uuid.UUID]V
+	err    error
+}
+
+func newRelationLoader[V any](ids []uuid.UUID, load func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]V, error)) *relationLoader[V] {
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !slices.Contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	return &relationLoader[V]{ids: unique, load: load}
+}
+
+// get returns the relation of the record with the given id, loading it for the whole set on the first call
+func (l *relationLoader[V]) get(ctx context.Context, id uuid.UUID) (V, error) {
+	l.once.Do(func() {
+		l.values, l.err = l.load(ctx, l.ids)
+	})
+	return l.values[id], l.err
+}
+
+// graphResolver is the root of the schema, holding the Query and Mutation fields
+type graphResolver struct {
+	h handler
+}
+
+type userResolver struct {
+	user      models.User
+	addresses *relationLoader[[]*addressResolver]
+}
+
+func (r *userResolver) ID() graphql.ID    { return graphql.ID(r.user.Id.String()) }
+func (r *userResolver) FirstName() string { return r.user.FirstName }
+func (r *userResolver) LastName() string  { return r.user.LastName }
+
+func (r *userResolver) Addresses(ctx context.Context) ([]*addressResolver, error) {
+	addrs, err := r.addresses.get(ctx, r.user.Id)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	if addrs == nil {
+		addrs = make([]*addressResolver, 0)
+	}
+	return addrs, nil
+}
+
+type addressResolver struct {
+	addr models.Address
+	user *relationLoader[*userResolver]
+}
+
+func (r *addressResolver) ID() graphql.ID     { return graphql.ID(r.addr.Id.String()) }
+func (r *addressResolver) UserID() graphql.ID { return graphql.ID(r.addr.UserId.String()) }
+func (r *addressResolver) Street() string     { return r.addr.Street }
+func (r *addressResolver) City() string       { return r.addr.City }
+func (r *addressResolver) State() string      { return r.addr.State }
+func (r *addressResolver) Zip() string        { return r.addr.Zip }
+func (r *addressResolver) Type() string       { return r.addr.Type }
+
+func (r *addressResolver) User(ctx context.Context) (*userResolver, error) {
+	user, err := r.user.get(ctx, r.addr.UserId)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	if user == nil {
+		return nil, graphFail(ctx, &NotFoundError{Resource: "user", Id: r.addr.UserId.String()})
+	}
+	return user, nil
+}
+
+// userResolvers wraps users resolved together, so their addresses are loaded with one query
+func (g *graphResolver) userResolvers(users []models.User) []*userResolver {
+	ids := make([]uuid.UUID, len(users))
+	for i, user := range users {
+		ids[i] = user.Id
+	}
+	loader := newRelationLoader(ids, func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]*addressResolver, error) {
+		addrs, err := g.h.addresses.FindAddressesByUserIds(ctx, ids)
+		if err != nil {
+			return nil, &InternalError{Detail: "Error fetching addresses for users", Err: err}
+		}
+		byUser := make(map[uuid.UUID][]*addressResolver, len(ids))
+		for _, addr := range g.addressResolvers(addrs) {
+			byUser[addr.addr.UserId] = append(byUser[addr.addr.UserId], addr)
+		}
+		return byUser, nil
+	})
+
+	resolvers := make([]*userResolver, len(users))
+	for i, user := range users {
+		resolvers[i] = &userResolver{user: user, addresses: loader}
+	}
+	return resolvers
+}
+
+// addressResolvers wraps addresses resolved together, so their users are loaded with one query
+func (g *graphResolver) addressResolvers(addrs []models.Address) []*addressResolver {
+	ids := make([]uuid.UUID, len(addrs))
+	for i, addr := range addrs {
+		ids[i] = addr.UserId
+	}
+	loader := newRelationLoader(ids, func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*userResolver, error) {
+		users, err := g.h.users.SelectUsersByIds(ctx, ids)
+		if err != nil {
+			return nil, &InternalError{Detail: "Error fetching users for addresses", Err: err}
+		}
+		byId := make(map[uuid.UUID]*userResolver, len(users))
+		for _, user := range g.userResolvers(users) {
+			byId[user.user.Id] = user
+		}
+		return byId, nil
+	})
+
+	resolvers := make([]*addressResolver, len(addrs))
+	for i, addr := range addrs {
+		resolvers[i] = &addressResolver{addr: addr, user: loader}
+	}
+	return resolvers
+}
+
+type userPageResolver struct {
+	items      []*userResolver
+	totalCount int32
+}
+
+func (r *userPageResolver) Items() []*userResolver { return r.items }
+func (r *userPageResolver) TotalCount() int32      { return r.totalCount }
+
+type addressPageResolver struct {
+	items      []*addressResolver
+	totalCount int32
+}
+
+func (r *addressPageResolver) Items() []*addressResolver { return r.items }
+func (r *addressPageResolver) TotalCount() int32         { return r.totalCount }
+
+// matchesFilter reports whether value equals filter ignoring case, or filter is unset
+func matchesFilter(filter *string, value string) bool {
+	return filter == nil || strings.EqualFold(*filter, value)
+}
+
+// parseGraphId reads a GraphQL ID argument as a UUID
+func parseGraphId(id graphql.ID) (uuid.UUID, error) {
+	parsed, err := uuid.Parse(string(id))
+	if err != nil {
+		return uuid.Nil, &InvalidIdError{Value: string(id), Err: err}
+	}
+	return parsed, nil
+}
+
+type userFilter struct {
+	FirstName *string
+	LastName  *string
+}
+
+func (g *graphResolver) Users(ctx context.Context, args struct {
+	Filter *userFilter
+	Limit  int32
+	Offset int32
+}) (*userPageResolver, error) {
+	users, err := g.h.users.SelectAllUsers(ctx)
+	if err != nil {
+		return nil, graphFail(ctx, &InternalError{Detail: "Error fetching user records", Err: err})
+	}
+	if args.Filter != nil {
+		matching := make([]models.User, 0, len(users))
+		for _, user := range users {
+			if matchesFilter(args.Filter.FirstName, user.FirstName) && matchesFilter(args.Filter.LastName, user.LastName) {
+				matching = append(matching, user)
+			}
+		}
+		users = matching
+	}
+
+	start, end, err := pageBounds(int(args.Limit), int(args.Offset), len(users))
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	return &userPageResolver{items: g.userResolvers(users[start:end]), totalCount: int32(len(users))}, nil
+}
+
+func (g *graphResolver) User(ctx context.Context, args struct{ Id graphql.ID }) (*userResolver, error) {
+	id, err := parseGraphId(args.Id)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	user, err := g.h.users.SelectOneUser(ctx, id)
+	if errors.Is(err, models.ErrModelNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, graphFail(ctx, repositoryError(err, "user", id, fmt.Sprintf("Error fetching user record with Id [%s]", id)))
+	}
+	return g.userResolvers([]models.User{user})[0], nil
+}
+
+type addressFilter struct {
+	UserId *graphql.ID
+	City   *string
+	State  *string
+	Zip    *string
+	Type   *string
+}
+
+func (g *graphResolver) Addresses(ctx context.Context, args struct {
+	Filter *addressFilter
+	Limit  int32
+	Offset int32
+}) (*addressPageResolver, error) {
+	filter := args.Filter
+	if filter == nil {
+		filter = &addressFilter{}
+	}
+
+	var addrs []models.Address
+	var err error
+	if filter.UserId != nil {
+		userId, idErr := parseGraphId(*filter.UserId)
+		if idErr != nil {
+			return nil, graphFail(ctx, idErr)
+		}
+		addrs, err = g.h.addresses.FindAddressesByUserId(ctx, userId)
+	} else {
+		addrs, err = g.h.addresses.FetchAddresses(ctx)
+	}
+	if err != nil {
+		return nil, graphFail(ctx, &InternalError{Detail: "Error fetching address records", Err: err})
+	}
+
+	matching := make([]models.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		if matchesFilter(filter.City, addr.City) && matchesFilter(filter.State, addr.State) &&
+			matchesFilter(filter.Zip, addr.Zip) && matchesFilter(filter.Type, addr.Type) {
+			matching = append(matching, addr)
+		}
+	}
+
+	start, end, err := pageBounds(int(args.Limit), int(args.Offset), len(matching))
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	return &addressPageResolver{items: g.addressResolvers(matching[start:end]), totalCount: int32(len(matching))}, nil
+}
+
+func (g *graphResolver) Address(ctx context.Context, args struct{ Id graphql.ID }) (*addressResolver, error) {
+	id, err := parseGraphId(args.Id)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	addr, err := g.h.addresses.FetchOneAddress(ctx, id)
+	if errors.Is(err, models.ErrModelNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, graphFail(ctx, repositoryError(err, "address", id, fmt.Sprintf("Error fetching address record with Id [%s]", id)))
+	}
+	return g.addressResolvers([]models.Address{addr})[0], nil
+}
+
+type userInput struct {
+	FirstName string
+	LastName  string
+}
+
+// body converts the input to the REST request body, so it is checked by the same rules
+func (in userInput) body() (addUpdateUserBody, error) {
+	body := addUpdateUserBody{FirstName: in.FirstName, LastName: in.LastName}
+	return body, validateBody(&body)
+}
+
+type addressInput struct {
+	UserId graphql.ID
+	Street string
+	City   string
+	State  string
+	Zip    string
+	Type   string
+}
+
+// body converts the input to the REST request body, so it is checked by the same rules
+func (in addressInput) body() (addUpdateAddressBody, error) {
+	userId, err := parseGraphId(in.UserId)
+	if err != nil {
+		return addUpdateAddressBody{}, err
+	}
+	body := addUpdateAddressBody{UserId: userId, Street: in.Street, City: in.City, State: in.State, Zip: in.Zip, Type: in.Type}
+	return body, validateBody(&body)
+}
+
+func (g *graphResolver) CreateUser(ctx context.Context, args struct{ Input userInput }) (*userResolver, error) {
+	if err := requireGraphRole(ctx, auth.RoleEditor); err != nil {
+		return nil, err
+	}
+	body, err := args.Input.body()
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	user, err := g.h.createUser(ctx, body)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	return g.userResolvers([]models.User{user})[0], nil
+}
+
+func (g *graphResolver) UpdateUser(ctx context.Context, args struct {
+	Id    graphql.ID
+	Input userInput
+}) (*userResolver, error) {
+	if err := requireGraphRole(ctx, auth.RoleEditor); err != nil {
+		return nil, err
+	}
+	id, err := parseGraphId(args.Id)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	body, err := args.Input.body()
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	user, err := g.h.updateUser(ctx, id, body)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	return g.userResolvers([]models.User{user})[0], nil
+}
+
+func (g *graphResolver) DeleteUser(ctx context.Context, args struct{ Id graphql.ID }) (graphql.ID, error) {
+	if err := requireGraphRole(ctx, auth.RoleAdmin); err != nil {
+		return "", err
+	}
+	id, err := parseGraphId(args.Id)
+	if err != nil {
+		return "", graphFail(ctx, err)
+	}
+	if err := g.h.deleteUser(ctx, id); err != nil {
+		return "", graphFail(ctx, err)
+	}
+	return args.Id, nil
+}
+
+func (g *graphResolver) CreateAddress(ctx context.Context, args struct{ Input addressInput }) (*addressResolver, error) {
+	if err := requireGraphRole(ctx, auth.RoleEditor); err != nil {
+		return nil, err
+	}
+	body, err := args.Input.body()
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	addr, err := g.h.createAddress(ctx, body)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	return g.addressResolvers([]models.Address{addr})[0], nil
+}
+
+func (g *graphResolver) UpdateAddress(ctx context.Context, args struct {
+	Id    graphql.ID
+	Input addressInput
+}) (*addressResolver, error) {
+	if err := requireGraphRole(ctx, auth.RoleEditor); err != nil {
+		return nil, err
+	}
+	id, err := parseGraphId(args.Id)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	body, err := args.Input.body()
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	addr, err := g.h.updateAddress(ctx, id, body)
+	if err != nil {
+		return nil, graphFail(ctx, err)
+	}
+	return g.addressResolvers([]models.Address{addr})[0], nil
+}
+
+func (g *graphResolver) DeleteAddress(ctx context.Context, args struct{ Id graphql.ID }) (graphql.ID, error) {
+	if err := requireGraphRole(ctx, auth.RoleEditor); err != nil {
+		return "", err
+	}
+	id, err := parseGraphId(args.Id)
+	if err != nil {
+		return "", graphFail(ctx, err)
+	}
+	if err := g.h.deleteAddress(ctx, id); err != nil {
+		return "", graphFail(ctx, err)
+	}
+	return args.Id, nil
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+func TestGraphQLRoute(t *testing.T) {
+	pat := models.User{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "Smith"}
+	sam := models.User{Id: uuid.MustParse("a3b2c1d0-0000-4000-8000-000000000001"), FirstName: "Sam", LastName: "Jones"}
+	home := models.Address{Id: uuid.MustParse("4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"), UserId: pat.Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"}
+	work := models.Address{Id: uuid.MustParse("4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a12"), UserId: sam.Id, Street: "9 Park Ave", City: "Boise", State: "ID", Zip: "83702", Type: "WORK"}
+
+	type test struct {
+		query              string
+		role               auth.Role
+		userErr            error
+		wantedBody         string
+		wantedUserBatches  int
+		wantedAddrBatches  int
+		wantedErrorCode    string
+		wantedErrorMessage string
+	}
+
+	tests := []test{
+		//each level of nesting is loaded with one query however many records it holds
+		{query: `{ users { totalCount items { firstName addresses { type user { lastName } } } } }`, wantedAddrBatches: 1, wantedUserBatches: 1,
+			wantedBody: `{"data":{"users":{"totalCount":2,"items":[` +
+				`{"firstName":"Pat","addresses":[{"type":"HOME","user":{"lastName":"Smith"}}]},` +
+				`{"firstName":"Sam","addresses":[{"type":"WORK","user":{"lastName":"Jones"}}]}]}}}`},
+		{query: `{ addresses(filter: {type: WORK}) { totalCount items { street user { firstName } } } }`, wantedUserBatches: 1,
+			wantedBody: `{"data":{"addresses":{"totalCount":1,"items":[{"street":"9 Park Ave","user":{"firstName":"Sam"}}]}}}`},
+		{query: `{ users(filter: {lastName: "jones"}) { totalCount items { id } } }`,
+			wantedBody: `{"data":{"users":{"totalCount":1,"items":[{"id":"a3b2c1d0-0000-4000-8000-000000000001"}]}}}`},
+		{query: `{ users(limit: 1, offset: 1) { totalCount items { firstName } } }`,
+			wantedBody: `{"data":{"users":{"totalCount":2,"items":[{"firstName":"Sam"}]}}}`},
+		{query: `{ users(limit: 500) { totalCount } }`,
+			wantedErrorCode: ErrCodeInvalidQuery.Code, wantedErrorMessage: "limit must be between 1 and 100"},
+		{query: `{ user(id: "493adb28-9da1-4db8-893d-73cc2d7bd4ee") { firstName } }`, userErr: models.ErrModelNotFound,
+			wantedBody: `{"data":{"user":null}}`},
+		{query: `mutation { createUser(input: {firstName: "Pat", lastName: "Smith"}) { id lastName } }`,
+			wantedBody: `{"data":{"createUser":{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","lastName":"Smith"}}}`},
+		//inputs are checked by the same rules as REST request bodies
+		{query: `mutation { createUser(input: {firstName: "Pat", lastName: "Sm1th"}) { id } }`,
+			wantedErrorCode: ErrCodeInvalidRequestBody.Code, wantedErrorMessage: "Request body is malformed or failed validation"},
+		{query: `mutation { createUser(input: {firstName: "Pat", lastName: "Smith"}) { id } }`, role: auth.RoleReader,
+			wantedErrorCode: ErrCodeForbidden.Code, wantedErrorMessage: "The editor role is required"},
+		{query: `mutation { deleteUser(id: "493adb28-9da1-4db8-893d-73cc2d7bd4ee") }`, role: auth.RoleEditor,
+			wantedErrorCode: ErrCodeForbidden.Code, wantedErrorMessage: "The admin role is required"},
+		{query: `mutation { deleteAddress(id: "4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11") }`,
+			wantedBody: `{"data":{"deleteAddress":"4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"}}`},
+		{query: `{ users { items { addresses { user { addresses { user { addresses { id } } } } } } } }`,
+			wantedErrorMessage: "Field \"user\" has depth 6 that exceeds max depth 5"},
+	}
+
+	for _, testCase := range tests {
+		users := &mockUserRepository{users: []models.User{pat, sam}, err: testCase.userErr}
+		addrs := &mockAddressRepository{addrs: []models.Address{home, work}}
+		router := SetupRouter(RouterConfig{})
+		router.Use(func(c *gin.Context) {
+			role := testCase.role
+			if role == "" {
+				role = auth.RoleAdmin
+			}
+			c.Set(principalContextKey, auth.Principal{Name: "graph", Roles: []auth.Role{role}})
+		})
+		err := RegisterGraphQLRoutes(router, users, addrs, 5)
+		assert.Equal(t, err, nil)
+
+		body, _ := json.Marshal(graphRequest{Query: testCase.query})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, users.batches, testCase.wantedUserBatches)
+		assert.Equal(t, addrs.batches, testCase.wantedAddrBatches)
+		if testCase.wantedErrorMessage == "" {
+			assert.Equal(t, w.Body.String(), testCase.wantedBody)
+			continue
+		}
+		var resp struct {
+			Errors []struct {
+				Message    string
+				Extensions struct{ Code string }
+			}
+		}
+		assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &resp), nil)
+		assert.Equal(t, len(resp.Errors), 1)
+		assert.Equal(t, resp.Errors[0].Message, testCase.wantedErrorMessage)
+		assert.Equal(t, resp.Errors[0].Extensions.Code, testCase.wantedErrorCode)
+	}
+}
+
+func TestGraphiQLPage(t *testing.T) {
+	router := SetupRouter(RouterConfig{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/docs/graphiql", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	assert.Equal(t, strings.Contains(w.Body.String(), "GraphiQL.createFetcher"), true)
+}
@@ -0,0 +1,390 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/logging"
+	"github.com/lengebretsen/go-practice/models"
+	gopracticev1 "github.com/lengebretsen/go-practice/proto/gopractice/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// grpcErrorDomain names this API in the ErrorInfo detail of failed calls
+const grpcErrorDomain = "go-practice"
+
+// Metadata keys read from gRPC calls, the lower case forms of the matching HTTP headers
+const (
+	grpcAPIKeyKey        = "x-api-key"
+	grpcAuthorizationKey = "authorization"
+	grpcRequestIDKey     = "x-request-id"
+)
+
+// grpcPublicServices need no credentials, like /healthz and /docs on the REST side
+var grpcPublicServices = []string{
+	"/" + healthpb.Health_ServiceDesc.ServiceName + "/",
+	"/grpc.reflection.v1.ServerReflection/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+}
+
+// grpcMethodRoles is the role each RPC needs, matching its REST route. Methods missing here need the admin role
+var grpcMethodRoles = map[string]auth.Role{
+	"/gopractice.v1.UserService/ListUsers":         auth.RoleReader,
+	"/gopractice.v1.UserService/GetUser":           auth.RoleReader,
+	"/gopractice.v1.UserService/CreateUser":        auth.RoleEditor,
+	"/gopractice.v1.UserService/UpdateUser":        auth.RoleEditor,
+	"/gopractice.v1.UserService/DeleteUser":        auth.RoleAdmin,
+	"/gopractice.v1.UserService/ListUserAddresses": auth.RoleReader,
+	"/gopractice.v1.AddressService/ListAddresses":  auth.RoleReader,
+	"/gopractice.v1.AddressService/GetAddress":     auth.RoleReader,
+	"/gopractice.v1.AddressService/CreateAddress":  auth.RoleEditor,
+	"/gopractice.v1.AddressService/UpdateAddress":  auth.RoleEditor,
+	"/gopractice.v1.AddressService/DeleteAddress":  auth.RoleEditor,
+}
+
+// grpcCodes maps the HTTP status of a problem to the gRPC code reported for it
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.Aborted,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// GRPCConfig holds what NewGRPCServer needs besides the repositories
+type GRPCConfig struct {
+	//Auth checks the x-api-key or authorization metadata of each call, leave nil to treat every caller as an admin
+	//as AllowAnonymous does
+	Auth *AuthConfig
+	//Database, when set, fails calls with UNAVAILABLE while it reports the database unreachable
+	Database HealthReporter
+	//RateLimiter, when set, holds callers to its default rule, sharing their buckets with the REST routes
+	RateLimiter *RateLimiter
+	//Quota, when set, counts calls against the same daily quota as REST requests
+	Quota *QuotaTracker
+	//Debug includes the internal cause of server errors in status messages, as RouterConfig.Debug does for REST
+	Debug bool
+	//Reflection registers the server reflection service so tools such as grpcurl can describe the API
+	Reflection bool
+}
+
+// GRPCServer serves UserService and AddressService from proto/gopractice/v1 along with the standard health service
+type GRPCServer struct {
+	server *grpc.Server
+	health *health.Server
+}
+
+// NewGRPCServer builds a gRPC server on the same repositories, validation and error codes as the REST routes. Failed
+// calls carry the problem code in an ErrorInfo detail and field errors in a BadRequest detail
+func NewGRPCServer(users models.UserRepository, addresses models.AddressRepository, cfg GRPCConfig) *GRPCServer {
+	interceptors := []grpc.UnaryServerInterceptor{grpcCalls(cfg.Debug)}
+	if cfg.Database != nil {
+		interceptors = append(interceptors, grpcRequireDatabase(cfg.Database))
+	}
+	if cfg.RateLimiter != nil && cfg.Auth != nil {
+		interceptors = append(interceptors, grpcLimitFailedAuth(cfg.RateLimiter))
+	}
+	interceptors = append(interceptors, grpcAuthenticate(cfg.Auth))
+	if cfg.RateLimiter != nil {
+		interceptors = append(interceptors, grpcRateLimit(cfg.RateLimiter))
+	}
+	if cfg.Quota != nil {
+		interceptors = append(interceptors, grpcQuota(cfg.Quota))
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+
+	h := handler{users: users, addresses: addresses}
+	gopracticev1.RegisterUserServiceServer(server, &userService{h: h})
+	gopracticev1.RegisterAddressServiceServer(server, &addressService{h: h})
+
+	healthServer := health.NewServer()
+	for _, service := range []string{gopracticev1.UserService_ServiceDesc.ServiceName, gopracticev1.AddressService_ServiceDesc.ServiceName} {
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+	healthpb.RegisterHealthServer(server, healthServer)
+	if cfg.Reflection {
+		reflection.Register(server)
+	}
+	return &GRPCServer{server: server, health: healthServer}
+}
+
+// Serve accepts calls on lis until Shutdown is called
+func (s *GRPCServer) Serve(lis net.Listener) error {
+	return s.server.Serve(lis)
+}
+
+// Shutdown reports every service as NOT_SERVING and waits for in-flight calls to finish, cancelling any still
+// running once ctx is done
+func (s *GRPCServer) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// grpcCalls is the gRPC counterpart of the requestID, accessLog, recovery and errorResponses middleware. It tags the
+// call's logger with a request id, turns panics and typed errors into statuses and logs one line per call
+func grpcCalls(debug bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (resp any, err error) {
+		start := time.Now()
+		id := firstMetadata(ctx, grpcRequestIDKey)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs(grpcRequestIDKey, id))
+		logger := slog.Default().With(slog.String("requestId", id))
+		ctx = logging.WithContext(ctx, logger)
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				logger.Error("panic while handling call", slog.Any("error", recovered))
+				err = &InternalError{Detail: "unexpected error", Err: fmt.Errorf("panic: %v", recovered)}
+			}
+			attrs := []slog.Attr{slog.String("method", info.FullMethod), slog.Duration("latency", time.Since(start))}
+			level := slog.LevelInfo
+			code := codes.OK
+			if err != nil {
+				//the cause is logged here as it is hidden from the client, like handler errors in accessLog
+				attrs = append(attrs, slog.String("error", err.Error()))
+				var problem Problem
+				problem, err = grpcProblem(err, info.FullMethod, id, debug)
+				code = status.Code(err)
+				level = slog.LevelWarn
+				if problem.Status >= http.StatusInternalServerError {
+					level = slog.LevelError
+				}
+			}
+			attrs = append(attrs, slog.String("code", code.String()))
+			logger.LogAttrs(ctx, level, "call handled", attrs...)
+		}()
+		return next(ctx, req)
+	}
+}
+
+// grpcRequireDatabase fails calls with UNAVAILABLE while the database is unreachable, as RequireDatabase does
+func grpcRequireDatabase(database HealthReporter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if database.Degraded() && !grpcPublic(info.FullMethod) {
+			return nil, errDatabaseUnavailable
+		}
+		return next(ctx, req)
+	}
+}
+
+// errDatabaseUnavailable is reported by grpcRequireDatabase, grpcProblem gives it the SERVICE_UNAVAILABLE code
+var errDatabaseUnavailable = errors.New("database connection lost")
+
+// grpcAuthenticate checks the caller's credentials with cfg, or treats them as an admin when cfg is nil, then checks
+// they hold the role the method needs
+func grpcAuthenticate(cfg *AuthConfig) grpc.UnaryServerInterceptor {
+	var a *authenticator
+	if cfg != nil {
+		a = newAuthenticator(*cfg)
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if grpcPublic(info.FullMethod) {
+			return next(ctx, req)
+		}
+
+		principal := auth.Principal{Name: "anonymous", Roles: []auth.Role{auth.RoleAdmin}}
+		if a != nil {
+			apiKey, token := parseCredentials(firstMetadata(ctx, grpcAPIKeyKey), firstMetadata(ctx, grpcAuthorizationKey))
+			var err error
+			if principal, err = a.authenticate(ctx, apiKey, token); err != nil {
+				return nil, err
+			}
+			ctx = logging.WithContext(ctx, logging.FromContext(ctx).With(slog.String("principal", principal.Name)))
+		}
+		ctx = context.WithValue(ctx, grpcPrincipalKey{}, principal)
+
+		role, ok := grpcMethodRoles[info.FullMethod]
+		if !ok {
+			role = auth.RoleAdmin
+		}
+		if !principal.Can(role) {
+			return nil, &ForbiddenError{Detail: fmt.Sprintf("The %s role is required", role)}
+		}
+		return next(ctx, req)
+	}
+}
+
+// grpcPrincipalKey is the context key grpcAuthenticate stores the caller's principal under
+type grpcPrincipalKey struct{}
+
+// grpcClientKey identifies the caller for rate limits and quotas as clientKey does, by principal or else by IP
+func grpcClientKey(ctx context.Context) string {
+	if principal, ok := ctx.Value(grpcPrincipalKey{}).(auth.Principal); ok && principal.Id != "" {
+		return principal.Id
+	}
+	return "ip:" + grpcPeerIP(ctx)
+}
+
+// grpcPeerIP is the IP address the call came from, or the whole peer address when it has no port
+func grpcPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// setGRPCHeaders sends headers given as name and value pairs as the call's header metadata
+func setGRPCHeaders(ctx context.Context, headers []string) {
+	if len(headers) > 0 {
+		_ = grpc.SetHeader(ctx, metadata.Pairs(headers...))
+	}
+}
+
+// grpcLimitFailedAuth holds each IP to the rate limiter's default rule for calls grpcAuthenticate rejects, as
+// LimitFailedAuth does
+func grpcLimitFailedAuth(limiter *RateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if grpcPublic(info.FullMethod) {
+			return next(ctx, req)
+		}
+		ip := grpcPeerIP(ctx)
+		headers, err := limiter.limitFailedAuth(ip)
+		setGRPCHeaders(ctx, headers)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := next(ctx, req)
+		var unauthorized *UnauthorizedError
+		if !errors.As(err, &unauthorized) {
+			limiter.refund(failedAuthKey(ip), limiter.defaultRule)
+		}
+		return resp, err
+	}
+}
+
+// grpcRateLimit fails calls with RESOURCE_EXHAUSTED once the caller has used up its bucket, as RateLimiter.Middleware
+// does, and reports the limit in ratelimit-* header metadata. Route rules name REST routes, so every call is held to
+// the default rule and shares the bucket of REST routes without a rule of their own
+func grpcRateLimit(limiter *RateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if grpcPublic(info.FullMethod) {
+			return next(ctx, req)
+		}
+		headers, err := limiter.limit(grpcClientKey(ctx), limiter.defaultRule)
+		setGRPCHeaders(ctx, headers)
+		if err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+// grpcQuota fails calls with RESOURCE_EXHAUSTED once the caller has used its daily quota, as QuotaTracker.Middleware
+// does
+func grpcQuota(quotas *QuotaTracker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if grpcPublic(info.FullMethod) {
+			return next(ctx, req)
+		}
+		headers, err := quotas.check(ctx, grpcClientKey(ctx))
+		setGRPCHeaders(ctx, headers)
+		if err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+// grpcPublic reports whether method belongs to a service callers may use without credentials
+func grpcPublic(method string) bool {
+	for _, prefix := range grpcPublicServices {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstMetadata returns the first value sent for key in the call's metadata
+func firstMetadata(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcProblem maps err to the problem REST clients would get and the status carrying it, with the problem code in an
+// ErrorInfo detail, field errors in a BadRequest detail and the request id in a RequestInfo detail
+func grpcProblem(err error, method, requestId string, debug bool) (Problem, error) {
+	var problem Problem
+	if errors.Is(err, errDatabaseUnavailable) {
+		problem = codeProblem(ErrCodeUnavailable, err.Error())
+	} else {
+		problem = problemOf(err, debug)
+	}
+	problem.Instance = method
+	problem.RequestId = requestId
+
+	code, ok := grpcCodes[problem.Status]
+	if !ok {
+		code = codes.Unknown
+	}
+	st := status.New(code, problem.Detail)
+	info := &errdetails.ErrorInfo{Reason: problem.Code, Domain: grpcErrorDomain}
+	request := &errdetails.RequestInfo{RequestId: requestId}
+	if len(problem.Errors) == 0 {
+		if detailed, err := st.WithDetails(info, request); err == nil {
+			st = detailed
+		}
+		return problem, st.Err()
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, fieldErr := range problem.Errors {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       protoFieldName(fieldErr.Field),
+			Description: fmt.Sprintf("%s (%s)", fieldErr.Message, fieldErr.Code),
+		})
+	}
+	if detailed, err := st.WithDetails(info, badRequest, request); err == nil {
+		st = detailed
+	}
+	return problem, st.Err()
+}
+
+var upperLetter = regexp.MustCompile(`[A-Z]`)
+
+// protoFieldName converts the JSON name validation reports for a field, e.g. firstName, to the proto field name
+func protoFieldName(field string) string {
+	return upperLetter.ReplaceAllStringFunc(field, func(letter string) string {
+		return "_" + strings.ToLower(letter)
+	})
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lengebretsen/go-practice/models"
+	gopracticev1 "github.com/lengebretsen/go-practice/proto/gopractice/v1"
+)
+
+// addressTypePrefix is stripped from AddressType enum names to get the type stored on addresses, e.g. HOME
+const addressTypePrefix = "ADDRESS_TYPE_"
+
+// userService implements gopracticev1.UserServiceServer on the same handler helpers as the /users routes
+type userService struct {
+	gopracticev1.UnimplementedUserServiceServer
+	h handler
+}
+
+func (s *userService) ListUsers(ctx context.Context, _ *gopracticev1.ListUsersRequest) (*gopracticev1.ListUsersResponse, error) {
+	users, err := s.h.users.SelectAllUsers(ctx)
+	if err != nil {
+		return nil, &InternalError{Detail: "Error fetching user records", Err: err}
+	}
+	resp := &gopracticev1.ListUsersResponse{Users: make([]*gopracticev1.User, len(users))}
+	for i, user := range users {
+		resp.Users[i] = userMessage(user)
+	}
+	return resp, nil
+}
+
+func (s *userService) GetUser(ctx context.Context, req *gopracticev1.GetUserRequest) (*gopracticev1.User, error) {
+	id, err := parseUUID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.h.users.SelectOneUser(ctx, id)
+	if err != nil {
+		return nil, repositoryError(err, "user", id, fmt.Sprintf("Error fetching user record with Id [%s]", id))
+	}
+	return userMessage(user), nil
+}
+
+func (s *userService) CreateUser(ctx context.Context, req *gopracticev1.CreateUserRequest) (*gopracticev1.User, error) {
+	body := addUpdateUserBody{FirstName: req.FirstName, LastName: req.LastName}
+	if err := validateBody(&body); err != nil {
+		return nil, err
+	}
+	user, err := s.h.createUser(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	return userMessage(user), nil
+}
+
+func (s *userService) UpdateUser(ctx context.Context, req *gopracticev1.UpdateUserRequest) (*gopracticev1.User, error) {
+	id, err := parseUUID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	body := addUpdateUserBody{FirstName: req.FirstName, LastName: req.LastName}
+	if err := validateBody(&body); err != nil {
+		return nil, err
+	}
+	user, err := s.h.updateUser(ctx, id, body)
+	if err != nil {
+		return nil, err
+	}
+	return userMessage(user), nil
+}
+
+func (s *userService) DeleteUser(ctx context.Context, req *gopracticev1.DeleteUserRequest) (*gopracticev1.DeleteUserResponse, error) {
+	id, err := parseUUID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.h.deleteUser(ctx, id); err != nil {
+		return nil, err
+	}
+	return &gopracticev1.DeleteUserResponse{}, nil
+}
+
+func (s *userService) ListUserAddresses(ctx context.Context, req *gopracticev1.ListUserAddressesRequest) (*gopracticev1.ListAddressesResponse, error) {
+	userId, err := parseUUID(req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := s.h.userAddresses(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return addressesMessage(addrs), nil
+}
+
+// addressService implements gopracticev1.AddressServiceServer on the same handler helpers as the /addresses routes
+type addressService struct {
+	gopracticev1.UnimplementedAddressServiceServer
+	h handler
+}
+
+func (s *addressService) ListAddresses(ctx context.Context, _ *gopracticev1.ListAddressesRequest) (*gopracticev1.ListAddressesResponse, error) {
+	addrs, err := s.h.addresses.FetchAddresses(ctx)
+	if err != nil {
+		return nil, &InternalError{Detail: "Error fetching address records", Err: err}
+	}
+	return addressesMessage(addrs), nil
+}
+
+func (s *addressService) GetAddress(ctx context.Context, req *gopracticev1.GetAddressRequest) (*gopracticev1.Address, error) {
+	id, err := parseUUID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.h.addresses.FetchOneAddress(ctx, id)
+	if err != nil {
+		return nil, repositoryError(err, "address", id, fmt.Sprintf("Error fetching address record with Id [%s]", id))
+	}
+	return addressMessage(addr), nil
+}
+
+func (s *addressService) CreateAddress(ctx context.Context, req *gopracticev1.CreateAddressRequest) (*gopracticev1.Address, error) {
+	body, err := addressBody(req.UserId, req.Street, req.City, req.State, req.Zip, req.Type)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.h.createAddress(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	return addressMessage(addr), nil
+}
+
+func (s *addressService) UpdateAddress(ctx context.Context, req *gopracticev1.UpdateAddressRequest) (*gopracticev1.Address, error) {
+	id, err := parseUUID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	body, err := addressBody(req.UserId, req.Street, req.City, req.State, req.Zip, req.Type)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.h.updateAddress(ctx, id, body)
+	if err != nil {
+		return nil, err
+	}
+	return addressMessage(addr), nil
+}
+
+func (s *addressService) DeleteAddress(ctx context.Context, req *gopracticev1.DeleteAddressRequest) (*gopracticev1.DeleteAddressResponse, error) {
+	id, err := parseUUID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.h.deleteAddress(ctx, id); err != nil {
+		return nil, err
+	}
+	return &gopracticev1.DeleteAddressResponse{}, nil
+}
+
+// addressBody checks the fields of an address request by the rules for the body of POST /addresses
+func addressBody(userId, street, city, state, zip string, addrType gopracticev1.AddressType) (addUpdateAddressBody, error) {
+	body := addUpdateAddressBody{Street: street, City: city, State: state, Zip: zip}
+	//left empty for the unspecified type so it fails the required rule, unknown numbers fail the oneof rule
+	if addrType != gopracticev1.AddressType_ADDRESS_TYPE_UNSPECIFIED {
+		body.Type = strings.TrimPrefix(addrType.String(), addressTypePrefix)
+	}
+	if userId != "" {
+		id, err := parseUUID(userId)
+		if err != nil {
+			return body, &ValidationError{Err: &fieldRuleError{field: "userId", code: "uuid", message: "must be a UUID"}}
+		}
+		body.UserId = id
+	}
+	return body, validateBody(&body)
+}
+
+func userMessage(user models.User) *gopracticev1.User {
+	return &gopracticev1.User{Id: user.Id.String(), FirstName: user.FirstName, LastName: user.LastName}
+}
+
+func addressMessage(addr models.Address) *gopracticev1.Address {
+	return &gopracticev1.Address{
+		Id:     addr.Id.String(),
+		UserId: addr.UserId.String(),
+		Street: addr.Street,
+		City:   addr.City,
+		State:  addr.State,
+		Zip:    addr.Zip,
+		Type:   gopracticev1.AddressType(gopracticev1.AddressType_value[addressTypePrefix+addr.Type]),
+	}
+}
+
+func addressesMessage(addrs []models.Address) *gopracticev1.ListAddressesResponse {
+	resp := &gopracticev1.ListAddressesResponse{Addresses: make([]*gopracticev1.Address, len(addrs))}
+	for i, addr := range addrs {
+		resp.Addresses[i] = addressMessage(addr)
+	}
+	return resp
+}
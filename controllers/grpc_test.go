@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+	gopracticev1 "github.com/lengebretsen/go-practice/proto/gopractice/v1"
+	"github.com/lengebretsen/go-practice/testing/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// dialGRPC serves server over an in-memory listener and returns a connection to it
+func dialGRPC(t *testing.T, server *GRPCServer) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Equal(t, err, nil)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCServer(t *testing.T) {
+	pat := models.User{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "Smith"}
+	home := models.Address{Id: uuid.MustParse("4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"), UserId: pat.Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"}
+	editorKey := models.APIKey{Id: uuid.MustParse("0b0c1f6e-8f0a-4d0e-9a57-3f7d2c1b9e01"), Name: "sync"}
+
+	type test struct {
+		call             func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error)
+		apiKey           string
+		userErr          error
+		addrErr          error
+		degraded         bool
+		wantedResp       proto.Message
+		wantedCode       codes.Code
+		wantedReason     string
+		wantedViolations []string
+	}
+
+	tests := []test{
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).GetUser(ctx, &gopracticev1.GetUserRequest{Id: pat.Id.String()})
+		}, apiKey: "bootstrap", wantedResp: &gopracticev1.User{Id: pat.Id.String(), FirstName: "Pat", LastName: "Smith"}},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).ListUserAddresses(ctx, &gopracticev1.ListUserAddressesRequest{UserId: pat.Id.String()})
+		}, apiKey: "editor", wantedResp: &gopracticev1.ListAddressesResponse{Addresses: []*gopracticev1.Address{{Id: home.Id.String(), UserId: pat.Id.String(),
+			Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: gopracticev1.AddressType_ADDRESS_TYPE_HOME}}}},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).GetUser(ctx, &gopracticev1.GetUserRequest{Id: "493adb28"})
+		}, apiKey: "editor", wantedCode: codes.InvalidArgument, wantedReason: ErrCodeInvalidId.Code},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewAddressServiceClient(conn).GetAddress(ctx, &gopracticev1.GetAddressRequest{Id: home.Id.String()})
+		}, apiKey: "editor", addrErr: models.ErrModelNotFound, wantedCode: codes.NotFound, wantedReason: ErrCodeAddressNotFound.Code},
+		//requests are checked by the same rules as REST bodies, with field errors named after the proto fields
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).CreateUser(ctx, &gopracticev1.CreateUserRequest{FirstName: "Pat", LastName: "Sm1th"})
+		}, apiKey: "editor", wantedCode: codes.InvalidArgument, wantedReason: ErrCodeInvalidRequestBody.Code,
+			wantedViolations: []string{"last_name: may only contain letters, spaces, apostrophes, hyphens and periods, and must start with a letter (personname)"}},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewAddressServiceClient(conn).CreateAddress(ctx, &gopracticev1.CreateAddressRequest{
+				UserId: pat.Id.String(), Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702"})
+		}, apiKey: "editor", wantedCode: codes.InvalidArgument, wantedReason: ErrCodeInvalidRequestBody.Code,
+			wantedViolations: []string{"type: is required (required)"}},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewAddressServiceClient(conn).CreateAddress(ctx, &gopracticev1.CreateAddressRequest{
+				UserId: "pat", Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: gopracticev1.AddressType_ADDRESS_TYPE_WORK})
+		}, apiKey: "editor", wantedCode: codes.InvalidArgument, wantedReason: ErrCodeInvalidRequestBody.Code,
+			wantedViolations: []string{"user_id: must be a UUID (uuid)"}},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewAddressServiceClient(conn).CreateAddress(ctx, &gopracticev1.CreateAddressRequest{
+				UserId: pat.Id.String(), Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: gopracticev1.AddressType_ADDRESS_TYPE_WORK})
+		}, apiKey: "editor", wantedResp: &gopracticev1.Address{Id: home.Id.String(), UserId: pat.Id.String(),
+			Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: gopracticev1.AddressType_ADDRESS_TYPE_WORK}},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).DeleteUser(ctx, &gopracticev1.DeleteUserRequest{Id: pat.Id.String()})
+		}, apiKey: "editor", wantedCode: codes.PermissionDenied, wantedReason: ErrCodeForbidden.Code},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).DeleteUser(ctx, &gopracticev1.DeleteUserRequest{Id: pat.Id.String()})
+		}, apiKey: "bootstrap", wantedResp: &gopracticev1.DeleteUserResponse{}},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).ListUsers(ctx, &gopracticev1.ListUsersRequest{})
+		}, wantedCode: codes.Unauthenticated, wantedReason: ErrCodeUnauthorized.Code},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).ListUsers(ctx, &gopracticev1.ListUsersRequest{})
+		}, apiKey: "editor", degraded: true, wantedCode: codes.Unavailable, wantedReason: ErrCodeUnavailable.Code},
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return gopracticev1.NewUserServiceClient(conn).ListUsers(ctx, &gopracticev1.ListUsersRequest{})
+		}, apiKey: "editor", userErr: models.ErrModelConflict, wantedCode: codes.Internal, wantedReason: ErrCodeInternal.Code},
+		//the health service stays public and answers while the database is down
+		{call: func(ctx context.Context, conn *grpc.ClientConn) (proto.Message, error) {
+			return healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: "gopractice.v1.UserService"})
+		}, degraded: true, wantedResp: &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}},
+	}
+
+	for _, testCase := range tests {
+		users := &mockUserRepository{users: []models.User{pat}, err: testCase.userErr}
+		addrs := &mockAddressRepository{addrs: []models.Address{home}, err: testCase.addrErr}
+		if testCase.addrErr != nil {
+			//the mock only reports its error when it holds no addresses
+			addrs.addrs = nil
+		}
+		keys := &mockAPIKeyRepository{hashes: map[string]models.APIKey{string(hashAPIKey("editor")): editorKey}}
+		server := NewGRPCServer(users, addrs, GRPCConfig{
+			Auth:     &AuthConfig{APIKeys: keys, BootstrapKey: "bootstrap"},
+			Database: mockHealthReporter{degraded: testCase.degraded},
+		})
+		conn := dialGRPC(t, server)
+
+		ctx := context.Background()
+		if testCase.apiKey != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", testCase.apiKey)
+		}
+		resp, err := testCase.call(ctx, conn)
+
+		st := status.Convert(err)
+		assert.Equal(t, st.Code(), testCase.wantedCode)
+		if testCase.wantedCode == codes.OK {
+			assert.Equal(t, proto.Equal(resp, testCase.wantedResp), true)
+			continue
+		}
+		var reason string
+		var violations []string
+		for _, detail := range st.Details() {
+			switch detail := detail.(type) {
+			case *errdetails.ErrorInfo:
+				reason = detail.Reason
+			case *errdetails.BadRequest:
+				for _, violation := range detail.FieldViolations {
+					violations = append(violations, violation.Field+": "+violation.Description)
+				}
+			}
+		}
+		assert.Equal(t, reason, testCase.wantedReason)
+		assert.Equal(t, violations, testCase.wantedViolations)
+	}
+}
+
+func TestGRPCShutdownReportsNotServing(t *testing.T) {
+	server := NewGRPCServer(&mockUserRepository{}, &mockAddressRepository{}, GRPCConfig{})
+	conn := dialGRPC(t, server)
+	health := healthpb.NewHealthClient(conn)
+
+	resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "gopractice.v1.AddressService"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.Status, healthpb.HealthCheckResponse_SERVING)
+
+	server.health.Shutdown()
+	resp, err = health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "gopractice.v1.AddressService"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, resp.Status, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+func TestGRPCLimits(t *testing.T) {
+	keys := &mockAPIKeyRepository{hashes: map[string]models.APIKey{string(hashAPIKey("editor")): {Id: uuid.New(), Name: "sync"}}}
+	limiter, err := NewRateLimiter(RateLimitRule{Rate: 0.001, Burst: 2}, nil)
+	assert.Equal(t, err, nil)
+	quotas, err := NewQuotaTracker(&mockUsageRepository{}, 1, time.Minute)
+	assert.Equal(t, err, nil)
+
+	type test struct {
+		server       *GRPCServer
+		apiKey       string
+		health       bool
+		wantedCode   codes.Code
+		wantedReason string
+	}
+
+	limited := NewGRPCServer(&mockUserRepository{}, &mockAddressRepository{}, GRPCConfig{
+		Auth:        &AuthConfig{APIKeys: keys},
+		RateLimiter: limiter,
+	})
+	metered := NewGRPCServer(&mockUserRepository{}, &mockAddressRepository{}, GRPCConfig{Quota: quotas})
+	tests := []test{
+		{server: limited, apiKey: "editor", wantedCode: codes.OK},
+		{server: limited, apiKey: "editor", wantedCode: codes.OK},
+		{server: limited, apiKey: "editor", wantedCode: codes.ResourceExhausted, wantedReason: ErrCodeRateLimited.Code},
+		//rejected credentials are limited by IP, calls that authenticated gave their token back
+		{server: limited, apiKey: "guess", wantedCode: codes.Unauthenticated, wantedReason: ErrCodeUnauthorized.Code},
+		{server: limited, apiKey: "guess", wantedCode: codes.Unauthenticated, wantedReason: ErrCodeUnauthorized.Code},
+		{server: limited, apiKey: "guess", wantedCode: codes.ResourceExhausted, wantedReason: ErrCodeRateLimited.Code},
+		//the health service is never limited
+		{server: limited, health: true, wantedCode: codes.OK},
+		{server: metered, wantedCode: codes.OK},
+		{server: metered, wantedCode: codes.ResourceExhausted, wantedReason: ErrCodeQuotaExceeded.Code},
+	}
+
+	conns := map[*GRPCServer]*grpc.ClientConn{limited: dialGRPC(t, limited), metered: dialGRPC(t, metered)}
+	for _, testCase := range tests {
+		ctx := context.Background()
+		if testCase.apiKey != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", testCase.apiKey)
+		}
+		conn := conns[testCase.server]
+		if testCase.health {
+			_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		} else {
+			_, err = gopracticev1.NewUserServiceClient(conn).ListUsers(ctx, &gopracticev1.ListUsersRequest{})
+		}
+
+		st := status.Convert(err)
+		assert.Equal(t, st.Code(), testCase.wantedCode)
+		var reason string
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				reason = info.Reason
+			}
+		}
+		assert.Equal(t, reason, testCase.wantedReason)
+	}
+}
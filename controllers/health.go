@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	statusPass = "pass"
+	statusFail = "fail"
+)
+
+// HealthCheck is a single named dependency check run by the readiness endpoint
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+	//First runs the check on its own before the others start, for checks such as pool saturation that would
+	//otherwise count the connections the other checks hold
+	First bool
+}
+
+// Draining is a readiness check that starts failing once shutdown begins, so orchestrators stop routing new
+// traffic to the instance while in-flight requests finish
+type Draining struct {
+	active atomic.Bool
+}
+
+// Begin marks the server as shutting down
+func (d *Draining) Begin() {
+	d.active.Store(true)
+}
+
+func (d *Draining) Check(ctx context.Context) error {
+	if d.active.Load() {
+		return errors.New("server is shutting down")
+	}
+	return nil
+}
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readinessReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// Healthz reports that the process is up and able to serve requests
+// @Summary liveness probe
+// @Tags health
+// @ID healthz
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /healthz [get]
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": statusPass})
+}
+
+type readiness struct {
+	checks  []HealthCheck
+	timeout time.Duration
+}
+
+// Readyz runs the checks marked First, then every other dependency check concurrently, and reports whether the instance should receive traffic
+// @Summary readiness probe reporting the status and latency of each dependency check
+// @Tags health
+// @ID readyz
+// @Produce json
+// @Success 200 {object} readinessReport
+// @Failure 503 {object} readinessReport
+// @Router /readyz [get]
+func (rd readiness) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), rd.timeout)
+	defer cancel()
+
+	report := readinessReport{Status: statusPass, Checks: make(map[string]checkResult, len(rd.checks))}
+	var mu sync.Mutex
+	run := func(check HealthCheck) {
+		start := time.Now()
+		err := check.Check(ctx)
+		result := checkResult{Status: statusPass, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			result.Status = statusFail
+			result.Error = err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		report.Checks[check.Name] = result
+		if err != nil {
+			report.Status = statusFail
+		}
+	}
+	for _, check := range rd.checks {
+		if check.First {
+			run(check)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, check := range rd.checks {
+		if check.First {
+			continue
+		}
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+			run(check)
+		}(check)
+	}
+	wg.Wait()
+
+	if report.Status != statusPass {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// RegisterReadiness adds the /readyz route running the given checks, each bounded by timeout.
+// It must be added before RequireDatabase so the report is still served while the database is down
+func RegisterReadiness(r *gin.Engine, timeout time.Duration, checks ...HealthCheck) {
+	rd := readiness{checks: checks, timeout: timeout}
+	r.GET("/readyz", rd.Readyz)
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lengebretsen/go-practice/logging"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	//idempotentReplayHeader marks a response that was replayed from an earlier request with the same key
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+	//idempotencyPurgeInterval is how often expired keys are deleted from the database
+	idempotencyPurgeInterval = time.Minute
+	//maxStoredResponseBytes is the most the mediumblob Body column of idempotency_keys holds
+	maxStoredResponseBytes = 1<<24 - 1
+)
+
+// Idempotency lets clients retry POST requests safely by sending an Idempotency-Key header. The first request with a
+// key is handled normally and its response stored for the window; retries with the same key and body get that
+// response back instead of running the handler again
+type Idempotency struct {
+	keys         models.IdempotencyRepository
+	window       time.Duration
+	lockTimeout  time.Duration
+	maxBodyBytes int64
+	now          func() time.Time
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+// NewIdempotency stores responses for window. A request that has not finished within lockTimeout is assumed to have
+// died, and its key can be used again. Requests with a key and a body over maxBodyBytes are rejected
+func NewIdempotency(keys models.IdempotencyRepository, window time.Duration, lockTimeout time.Duration, maxBodyBytes int64) (*Idempotency, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("idempotency.window must be positive, got %s", window)
+	}
+	if lockTimeout <= 0 {
+		return nil, fmt.Errorf("idempotency.lockTimeout must be positive, got %s", lockTimeout)
+	}
+	if maxBodyBytes < 1 || maxBodyBytes > maxStoredResponseBytes {
+		return nil, fmt.Errorf("idempotency.maxBodyBytes must be between 1 and %d, got %d", maxStoredResponseBytes, maxBodyBytes)
+	}
+	return &Idempotency{keys: keys, window: window, lockTimeout: lockTimeout, maxBodyBytes: maxBodyBytes, now: time.Now}, nil
+}
+
+// recordingWriter keeps a copy of the response body so it can be stored for replays
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// requestHash identifies what a request asked for, so a key reused for a different request can be told apart from a
+// retry. Insignificant whitespace in JSON bodies is ignored
+func requestHash(method string, path string, body []byte) []byte {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, body); err == nil {
+		body = compact.Bytes()
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", method, path)
+	hash.Write(body)
+	return hash.Sum(nil)
+}
+
+// validIdempotencyKey accepts up to 255 printable ASCII characters
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// purge deletes expired keys, at most once every idempotencyPurgeInterval
+func (i *Idempotency) purge(ctx context.Context, now time.Time) {
+	i.mu.Lock()
+	if now.Sub(i.lastPurge) < idempotencyPurgeInterval {
+		i.mu.Unlock()
+		return
+	}
+	i.lastPurge = now
+	i.mu.Unlock()
+
+	if _, err := i.keys.DeleteExpiredIdempotencyKeys(ctx, now); err != nil {
+		logging.FromContext(ctx).Warn("failed to delete expired idempotency keys", "error", err)
+	}
+}
+
+// Middleware handles POST requests carrying an Idempotency-Key header, and lets every other request through
+// untouched. Keys are scoped to the client, so it must be added after Authenticate. Only successful responses are
+// stored: when the handler fails the key is released so the client can retry with it
+func (i *Idempotency) Middleware() gin.HandlerFunc {
+	return handle(func(c *gin.Context) error {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			return nil
+		}
+		if !validIdempotencyKey(key) {
+			return &IdempotencyError{
+				Code:   ErrCodeInvalidIdempotencyKey,
+				Detail: fmt.Sprintf("%s must be 1 to %d printable ASCII characters", idempotencyKeyHeader, maxIdempotencyKeyLength),
+			}
+		}
+
+		//the whole body is read to hash it, so it is capped rather than read into memory at any size
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, i.maxBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &RequestTooLargeError{Limit: tooLarge.Limit}
+		}
+		if err != nil {
+			return &ValidationError{Err: err}
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		//the outcome is saved even if the client goes away, otherwise its retry would find the key still in use
+		ctx := context.WithoutCancel(c.Request.Context())
+		now := i.now()
+		i.purge(ctx, now)
+		record := models.IdempotencyRecord{
+			ClientKey:   clientKey(c),
+			Key:         key,
+			RequestHash: requestHash(c.Request.Method, c.Request.URL.Path, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(i.lockTimeout),
+		}
+		existing, reserved, err := i.keys.ReserveIdempotencyKey(ctx, record)
+		if errors.Is(err, models.ErrModelConflict) {
+			return &IdempotencyError{Code: ErrCodeIdempotencyKeyInUse, Detail: "A request with this idempotency key is in progress, retry shortly"}
+		}
+		if err != nil {
+			return &InternalError{Detail: "Error checking idempotency key", Err: err}
+		}
+		if !reserved {
+			switch {
+			case !bytes.Equal(existing.RequestHash, record.RequestHash):
+				return &IdempotencyError{
+					Code:   ErrCodeIdempotencyKeyReused,
+					Detail: fmt.Sprintf("Idempotency key [%s] was already used for a different request", key),
+				}
+			case existing.InProgress():
+				return &IdempotencyError{Code: ErrCodeIdempotencyKeyInUse, Detail: "A request with this idempotency key is in progress, retry shortly"}
+			}
+			c.Header(idempotentReplayHeader, "true")
+			if existing.Location != "" {
+				c.Header("Location", existing.Location)
+			}
+			c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+			c.Abort()
+			return nil
+		}
+
+		recorder := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+
+		if len(c.Errors) > 0 || c.Writer.Status() >= http.StatusInternalServerError || recorder.body.Len() > maxStoredResponseBytes {
+			if recorder.body.Len() > maxStoredResponseBytes {
+				logging.FromContext(ctx).Warn("response too large to store for idempotent replay", "bytes", recorder.body.Len())
+			}
+			if err := i.keys.ReleaseIdempotencyKey(ctx, record.ClientKey, key); err != nil {
+				logging.FromContext(ctx).Warn("failed to release idempotency key", "error", err)
+			}
+			return nil
+		}
+		record.StatusCode = c.Writer.Status()
+		record.ContentType = c.Writer.Header().Get("Content-Type")
+		record.Location = c.Writer.Header().Get("Location")
+		record.Body = recorder.body.Bytes()
+		record.ExpiresAt = now.Add(i.window)
+		if err := i.keys.CompleteIdempotencyKey(ctx, record); err != nil {
+			//the response has been sent, so a retry after the lock timeout will run the request again
+			logging.FromContext(ctx).Error("failed to save idempotent response", "error", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+type mockIdempotencyRepository struct {
+	records map[string]models.IdempotencyRecord
+}
+
+func (m *mockIdempotencyRepository) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	id := record.ClientKey + "|" + record.Key
+	if existing, ok := m.records[id]; ok && existing.ExpiresAt.After(record.CreatedAt) {
+		return existing, false, nil
+	}
+	m.records[id] = record
+	return record, true, nil
+}
+func (m *mockIdempotencyRepository) CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error {
+	m.records[record.ClientKey+"|"+record.Key] = record
+	return nil
+}
+func (m *mockIdempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, clientKey string, key string) error {
+	delete(m.records, clientKey+"|"+key)
+	return nil
+}
+func (m *mockIdempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestIdempotency(t *testing.T) {
+	repo := &mockIdempotencyRepository{records: map[string]models.IdempotencyRecord{}}
+	idempotency, err := NewIdempotency(repo, time.Hour, time.Minute, 1024)
+	assert.Equal(t, err, nil)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	idempotency.now = func() time.Time { return now }
+
+	created := 0
+	router := SetupRouter(RouterConfig{})
+	router.Use(withPrincipal(), idempotency.Middleware())
+	router.POST("/users/", handle(func(c *gin.Context) error {
+		var body addUpdateUserBody
+		if err := bindBody(c, &body); err != nil {
+			return err
+		}
+		created++
+		id := uuid.New()
+		c.Header("Location", "/v2/users/"+id.String())
+		c.IndentedJSON(http.StatusCreated, models.User{Id: id, FirstName: body.FirstName, LastName: body.LastName})
+		return nil
+	}))
+
+	type test struct {
+		client         string
+		key            string
+		body           string
+		advance        time.Duration
+		wantedCode     int
+		wantedCreated  int
+		wantedReplayed string
+		wantedProblem  string
+	}
+
+	tests := []test{
+		{client: "alice", key: "k1", body: `{"firstName":"Pat","lastName":"Smith"}`, wantedCode: 201, wantedCreated: 1},
+		{client: "alice", key: "k1", body: `{"firstName": "Pat", "lastName": "Smith"}`, wantedCode: 201, wantedCreated: 1, wantedReplayed: "true"},
+		{client: "alice", key: "k1", body: `{"firstName":"Sam","lastName":"Smith"}`, wantedCode: 422, wantedCreated: 1, wantedProblem: ErrCodeIdempotencyKeyReused.Code},
+		//keys are scoped to the client
+		{client: "bob", key: "k1", body: `{"firstName":"Pat","lastName":"Smith"}`, wantedCode: 201, wantedCreated: 2},
+		//requests without a key are never deduplicated
+		{client: "alice", body: `{"firstName":"Pat","lastName":"Smith"}`, wantedCode: 201, wantedCreated: 3},
+		{client: "alice", body: `{"firstName":"Pat","lastName":"Smith"}`, wantedCode: 201, wantedCreated: 4},
+		//failed requests release the key so it can be retried
+		{client: "alice", key: "k2", body: `{"firstName":""}`, wantedCode: 400, wantedCreated: 4, wantedProblem: ErrCodeInvalidRequestBody.Code},
+		{client: "alice", key: "k2", body: `{"firstName":"Pat","lastName":"Smith"}`, wantedCode: 201, wantedCreated: 5},
+		{client: "alice", key: strings.Repeat("k", 256), body: `{}`, wantedCode: 400, wantedCreated: 5, wantedProblem: ErrCodeInvalidIdempotencyKey.Code},
+		//bodies are read whole to hash them, so they are capped
+		{client: "alice", key: "k3", body: `{"firstName":"` + strings.Repeat("P", 1024) + `"}`, wantedCode: 413, wantedCreated: 5, wantedProblem: ErrCodeRequestTooLarge.Code},
+		//once the window has passed the key starts over
+		{client: "alice", key: "k1", body: `{"firstName":"Sam","lastName":"Smith"}`, advance: 2 * time.Hour, wantedCode: 201, wantedCreated: 6},
+	}
+
+	var first, firstLocation string
+	for i, testCase := range tests {
+		now = now.Add(testCase.advance)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/users/", strings.NewReader(testCase.body))
+		req.Header.Set("X-Test-Client", testCase.client)
+		if testCase.key != "" {
+			req.Header.Set("Idempotency-Key", testCase.key)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		assert.Equal(t, created, testCase.wantedCreated)
+		assert.Equal(t, w.Header().Get("Idempotent-Replayed"), testCase.wantedReplayed)
+		if testCase.wantedProblem != "" {
+			assert.Equal(t, parseProblem(t, w).Code, testCase.wantedProblem)
+		}
+		switch i {
+		case 0:
+			first = w.Body.String()
+			firstLocation = w.Header().Get("Location")
+		case 1:
+			//the replay is the original response, including the id generated the first time
+			assert.Equal(t, w.Body.String(), first)
+			assert.Equal(t, w.Header().Get("Content-Type"), "application/json; charset=utf-8")
+			assert.Equal(t, w.Header().Get("Location"), firstLocation)
+		}
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	hash := requestHash("POST", "/users/", []byte(`{}`))
+	repo := &mockIdempotencyRepository{records: map[string]models.IdempotencyRecord{
+		"alice|k1": {ClientKey: "alice", Key: "k1", RequestHash: hash, CreatedAt: now, ExpiresAt: now.Add(time.Minute)},
+	}}
+	idempotency, _ := NewIdempotency(repo, time.Hour, time.Minute, 1024)
+	idempotency.now = func() time.Time { return now }
+
+	router := SetupRouter(RouterConfig{})
+	router.Use(withPrincipal(), idempotency.Middleware())
+	router.POST("/users/", func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	request := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/users/", strings.NewReader(`{}`))
+		req.Header.Set("X-Test-Client", "alice")
+		req.Header.Set("Idempotency-Key", "k1")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request()
+	assert.Equal(t, w.Code, 409)
+	assert.Equal(t, parseProblem(t, w).Code, ErrCodeIdempotencyKeyInUse.Code)
+
+	//a request that never finished stops holding the key after the lock timeout
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, request().Code, 201)
+}
+
+func TestIdempotencyConfig(t *testing.T) {
+	repo := &mockIdempotencyRepository{records: map[string]models.IdempotencyRecord{}}
+	_, err := NewIdempotency(repo, time.Hour, time.Minute, 0)
+	assert.Equal(t, err.Error(), "idempotency.maxBodyBytes must be between 1 and 16777215, got 0")
+	//capped at what the mediumblob column responses are stored in holds
+	_, err = NewIdempotency(repo, time.Hour, time.Minute, 1<<24)
+	assert.Equal(t, err.Error(), "idempotency.maxBodyBytes must be between 1 and 16777215, got 16777216")
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/logging"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestId"
+)
+
+// validRequestID limits client supplied ids to something safe to echo back in headers and write to logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID honors a well-formed X-Request-ID from the client or generates a new one, echoes it back on the response
+// and stores a logger tagged with it (and the trace id, when tracing) in the request context
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+
+		logger := slog.Default().With(slog.String("requestId", id))
+		if spanCtx := trace.SpanContextFromContext(c.Request.Context()); spanCtx.IsValid() {
+			logger = logger.With(slog.String("traceId", spanCtx.TraceID().String()))
+		}
+		c.Request = c.Request.WithContext(logging.WithContext(c.Request.Context(), logger))
+		c.Next()
+	}
+}
+
+// requestIDFrom returns the id assigned to the request by the requestID middleware
+func requestIDFrom(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// accessLog writes one structured line per request once it has been handled
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("clientIp", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+		}
+		//errors recorded by handlers carry the internal cause that is hidden from the client
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.Last().Error()))
+		}
+		logging.FromContext(c.Request.Context()).LogAttrs(c.Request.Context(), level, "request handled", attrs...)
+	}
+}
+
+// recovery turns a panic into a 500 and logs it with the request's logger instead of gin's plain text output
+func recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		logging.FromContext(c.Request.Context()).Error("panic while handling request", slog.Any("error", err))
+		abortWithProblem(c, ErrCodeInternal, "unexpected error")
+	})
+}
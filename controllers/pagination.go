@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	//defaultPageSize is the number of records a page holds when the client does not set a limit
+	defaultPageSize = 50
+	//maxPageSize is the largest limit list queries accept
+	maxPageSize = 100
+)
+
+// pageBounds checks limit and offset and returns the range of a list of total records they select
+func pageBounds(limit int, offset int, total int) (int, int, error) {
+	if limit < 1 || limit > maxPageSize {
+		return 0, 0, &InvalidQueryError{Param: "limit", Detail: fmt.Sprintf("limit must be between 1 and %d", maxPageSize)}
+	}
+	if offset < 0 {
+		return 0, 0, &InvalidQueryError{Param: "offset", Detail: "offset cannot be negative"}
+	}
+	start := min(offset, total)
+	return start, min(start+limit, total), nil
+}
+
+// parsePage reads ?limit= and ?offset=, defaulting to the first page of defaultPageSize records
+func parsePage(c *gin.Context) (limit int, offset int, err error) {
+	limit, offset = defaultPageSize, 0
+	if value, ok := c.GetQuery("limit"); ok {
+		if limit, err = strconv.Atoi(value); err != nil {
+			return 0, 0, &InvalidQueryError{Param: "limit", Detail: fmt.Sprintf("limit must be a whole number, got [%s]", value)}
+		}
+	}
+	if value, ok := c.GetQuery("offset"); ok {
+		if offset, err = strconv.Atoi(value); err != nil {
+			return 0, 0, &InvalidQueryError{Param: "offset", Detail: fmt.Sprintf("offset must be a whole number, got [%s]", value)}
+		}
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemXMLContentType is used instead for clients that prefer XML, as described in RFC 7807 appendix A
+const problemXMLContentType = "application/problem+xml"
+
+// problemTypeBase prefixes every problem type URI; the code's slug is appended to it
+const problemTypeBase = "/problems/"
+
+// ErrorCode is an entry in the catalog of errors the API can report. Codes are stable and safe for clients to
+// branch on, unlike titles and details which may be reworded
+type ErrorCode struct {
+	Code   string
+	Title  string
+	Status int
+}
+
+// Type is the problem type URI identifying the error code
+func (e ErrorCode) Type() string {
+	return problemTypeBase + strings.ReplaceAll(strings.ToLower(e.Code), "_", "-")
+}
+
+var (
+	ErrCodeInvalidId             = ErrorCode{Code: "INVALID_ID", Title: "Invalid identifier", Status: http.StatusBadRequest}
+	ErrCodeInvalidRequestBody    = ErrorCode{Code: "INVALID_REQUEST_BODY", Title: "Invalid request body", Status: http.StatusBadRequest}
+	ErrCodeInvalidQuery          = ErrorCode{Code: "INVALID_QUERY_PARAMETER", Title: "Invalid query parameter", Status: http.StatusBadRequest}
+	ErrCodeUnauthorized          = ErrorCode{Code: "UNAUTHORIZED", Title: "Authentication required", Status: http.StatusUnauthorized}
+	ErrCodeForbidden             = ErrorCode{Code: "FORBIDDEN", Title: "Not permitted", Status: http.StatusForbidden}
+	ErrCodeUserNotFound          = ErrorCode{Code: "USER_NOT_FOUND", Title: "User not found", Status: http.StatusNotFound}
+	ErrCodeAddressNotFound       = ErrorCode{Code: "ADDRESS_NOT_FOUND", Title: "Address not found", Status: http.StatusNotFound}
+	ErrCodeAPIKeyNotFound        = ErrorCode{Code: "API_KEY_NOT_FOUND", Title: "API key not found", Status: http.StatusNotFound}
+	ErrCodeNotFound              = ErrorCode{Code: "RESOURCE_NOT_FOUND", Title: "Resource not found", Status: http.StatusNotFound}
+	ErrCodeConflict              = ErrorCode{Code: "CONFLICT", Title: "Conflict with the current state of the resource", Status: http.StatusConflict}
+	ErrCodeInvalidIdempotencyKey = ErrorCode{Code: "INVALID_IDEMPOTENCY_KEY", Title: "Invalid idempotency key", Status: http.StatusBadRequest}
+	ErrCodeIdempotencyKeyInUse   = ErrorCode{Code: "IDEMPOTENCY_KEY_IN_USE", Title: "Request with this idempotency key is in progress", Status: http.StatusConflict}
+	ErrCodeIdempotencyKeyReused  = ErrorCode{Code: "IDEMPOTENCY_KEY_REUSED", Title: "Idempotency key reused with a different request", Status: http.StatusUnprocessableEntity}
+	ErrCodeRequestTooLarge       = ErrorCode{Code: "REQUEST_TOO_LARGE", Title: "Request body too large", Status: http.StatusRequestEntityTooLarge}
+	ErrCodeNotAcceptable         = ErrorCode{Code: "NOT_ACCEPTABLE", Title: "Requested media type not available", Status: http.StatusNotAcceptable}
+	ErrCodeUnsupportedMediaType  = ErrorCode{Code: "UNSUPPORTED_MEDIA_TYPE", Title: "Unsupported request body media type", Status: http.StatusUnsupportedMediaType}
+	ErrCodeRateLimited           = ErrorCode{Code: "RATE_LIMITED", Title: "Too many requests", Status: http.StatusTooManyRequests}
+	ErrCodeQuotaExceeded         = ErrorCode{Code: "QUOTA_EXCEEDED", Title: "Daily request quota exceeded", Status: http.StatusTooManyRequests}
+	ErrCodeInternal              = ErrorCode{Code: "INTERNAL_ERROR", Title: "Internal server error", Status: http.StatusInternalServerError}
+	ErrCodeUnavailable           = ErrorCode{Code: "SERVICE_UNAVAILABLE", Title: "Service temporarily unavailable", Status: http.StatusServiceUnavailable}
+)
+
+// sentinelStatuses maps errors returned by the models package to the HTTP status they represent
+var sentinelStatuses = map[error]int{
+	models.ErrModelNotFound: http.StatusNotFound,
+	models.ErrModelConflict: http.StatusConflict,
+}
+
+// notFoundCodes picks the resource specific not found code, resources without one report ErrCodeNotFound
+var notFoundCodes = map[string]ErrorCode{
+	"user":    ErrCodeUserNotFound,
+	"address": ErrCodeAddressNotFound,
+	"API key": ErrCodeAPIKeyNotFound,
+}
+
+// statusForError returns the HTTP status for a repository error, treating anything unrecognized as a server error
+func statusForError(err error) int {
+	for sentinel, status := range sentinelStatuses {
+		if errors.Is(err, sentinel) {
+			return status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// FieldError describes a single problem with one field of a request body
+type FieldError struct {
+	Field   string `json:"field" xml:"field"`
+	Code    string `json:"code" xml:"code"`
+	Message string `json:"message" xml:"message"`
+}
+
+// Problem is an RFC 7807 problem details response body
+type Problem struct {
+	XMLName   xml.Name     `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+	Type      string       `json:"type" xml:"type"`
+	Title     string       `json:"title" xml:"title"`
+	Status    int          `json:"status" xml:"status"`
+	Detail    string       `json:"detail,omitempty" xml:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty" xml:"instance,omitempty"`
+	Code      string       `json:"code" xml:"code"`
+	RequestId string       `json:"requestId,omitempty" xml:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty" xml:"errors>error,omitempty"`
+}
+
+func newProblem(c *gin.Context, code ErrorCode, detail string) Problem {
+	problem := codeProblem(code, detail)
+	problem.Instance = c.Request.URL.Path
+	problem.RequestId = requestIDFrom(c)
+	return problem
+}
+
+// codeProblem is the problem for code, without the details of the request that failed
+func codeProblem(code ErrorCode, detail string) Problem {
+	return Problem{
+		Type:   code.Type(),
+		Title:  code.Title,
+		Status: code.Status,
+		Detail: detail,
+		Code:   code.Code,
+	}
+}
+
+// abortWith ends the request with problem, written as problem+xml when the client prefers XML over JSON
+func abortWith(c *gin.Context, problem Problem) {
+	ranges := parseAccept(c.GetHeader("Accept"))
+	if preference(ranges, append([]string{problemXMLContentType}, xmlMediaTypes...)) >
+		preference(ranges, append([]string{problemContentType}, jsonMediaTypes...)) {
+		c.Header("Content-Type", problemXMLContentType)
+		c.Abort()
+		c.XML(problem.Status, problem)
+		return
+	}
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
+
+// debugContextKey holds the router's Debug setting for the problems written while handling a request
+const debugContextKey = "debugErrors"
+
+// debugDetail appends the internal cause of a server error to detail, but only when debug is set from server.debug
+// so internal error text never leaks by default
+func debugDetail(detail string, cause error, debug bool) string {
+	if cause != nil && debug {
+		return fmt.Sprintf("%s: %s", detail, cause)
+	}
+	return detail
+}
+
+// abortWithProblem ends the request with a problem+json body for code. It is for middleware, handlers return one
+// of the typed errors instead and leave the response to errorResponses
+func abortWithProblem(c *gin.Context, code ErrorCode, detail string) {
+	abortWith(c, newProblem(c, code, detail))
+}
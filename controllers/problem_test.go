@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+// wantProblem builds the problem a test expects for code, leaving out the per request instance and request id
+func wantProblem(code ErrorCode, detail string, fieldErrors ...FieldError) Problem {
+	return Problem{Type: code.Type(), Title: code.Title, Status: code.Status, Detail: detail, Code: code.Code, Errors: fieldErrors}
+}
+
+// parseProblem checks the response is problem+json and decodes it, clearing the instance and request id which are
+// covered by TestProblemResponse and TestRequestIDMiddleware
+func parseProblem(t *testing.T, w *httptest.ResponseRecorder) Problem {
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/problem+json")
+	parsedResp := Problem{}
+	json.Unmarshal(w.Body.Bytes(), &parsedResp)
+	parsedResp.Instance = ""
+	parsedResp.RequestId = ""
+	return parsedResp
+}
+
+func TestProblemResponse(t *testing.T) {
+	router := SetupRouter(RouterConfig{})
+	router.Use(AllowAnonymous())
+	RegisterRoutes(router, &mockUserRepository{err: models.ErrModelNotFound}, nil, RouteVersions{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee", nil)
+	req.Header.Set("X-Request-ID", "problem-test")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 404)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/problem+json")
+	assert.Equal(t, w.Body.String(), `{"type":"/problems/user-not-found","title":"User not found","status":404,`+
+		`"detail":"No user exists with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]",`+
+		`"instance":"/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee","code":"USER_NOT_FOUND","requestId":"problem-test"}`)
+}
+
+func TestInternalErrorDetailOnlyInDebug(t *testing.T) {
+	type test struct {
+		debug        bool
+		wantedDetail string
+	}
+
+	tests := []test{
+		{debug: false, wantedDetail: "Error fetching user records"},
+		{debug: true, wantedDetail: "Error fetching user records: Error 1045: Access denied for user 'gousr'"},
+	}
+
+	//every router is set up before any is used, so each must keep its own setting
+	routers := make([]*gin.Engine, len(tests))
+	for i, testCase := range tests {
+		routers[i] = SetupRouter(RouterConfig{Debug: testCase.debug})
+		routers[i].Use(AllowAnonymous())
+		RegisterRoutes(routers[i], &mockUserRepository{err: errors.New("Error 1045: Access denied for user 'gousr'")}, nil, RouteVersions{})
+	}
+
+	for i, testCase := range tests {
+		router := routers[i]
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, 500)
+		assert.Equal(t, parseProblem(t, w), wantProblem(ErrCodeInternal, testCase.wantedDetail))
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	type test struct {
+		err           error
+		wantedCode    int
+		wantedProblem Problem
+	}
+
+	tests := []test{
+		{
+			err:           &InvalidIdError{Value: "bob", Err: errors.New("invalid UUID length: 3")},
+			wantedCode:    400,
+			wantedProblem: wantProblem(ErrCodeInvalidId, "Id [bob] is not a valid UUID: invalid UUID length: 3"),
+		},
+		{
+			err:           repositoryError(models.ErrModelNotFound, "address", uuid.MustParse("34ecb0a8-7184-42fa-8840-6fa5c496d161"), "Error fetching address"),
+			wantedCode:    404,
+			wantedProblem: wantProblem(ErrCodeAddressNotFound, "No address exists with Id [34ecb0a8-7184-42fa-8840-6fa5c496d161]"),
+		},
+		{
+			//resources without their own code still get a not found problem
+			err:           &NotFoundError{Resource: "widget", Id: "42"},
+			wantedCode:    404,
+			wantedProblem: wantProblem(ErrCodeNotFound, "No widget exists with Id [42]"),
+		},
+		{
+			err:           repositoryError(fmt.Errorf("%w: Duplicate entry", models.ErrModelConflict), "user", uuid.Nil, "Error creating new user"),
+			wantedCode:    409,
+			wantedProblem: wantProblem(ErrCodeConflict, "Error creating new user"),
+		},
+		{
+			err:           repositoryError(errors.New("Kaboom!"), "user", uuid.Nil, "Error creating new user"),
+			wantedCode:    500,
+			wantedProblem: wantProblem(ErrCodeInternal, "Error creating new user"),
+		},
+		{
+			err:           errors.New("untyped"),
+			wantedCode:    500,
+			wantedProblem: wantProblem(ErrCodeInternal, "Unexpected error"),
+		},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		ranAfterError := false
+		router.GET("/test", handle(func(c *gin.Context) error { return testCase.err }), func(c *gin.Context) { ranAfterError = true })
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/test", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		assert.Equal(t, parseProblem(t, w), testCase.wantedProblem)
+		//a returned error aborts the chain, nothing registered after the handler runs
+		assert.Equal(t, ranAfterError, false)
+	}
+}
@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/logging"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// dayUsage holds request counts for one day that have not been written to the database yet
+type dayUsage struct {
+	day    string
+	counts map[string]int64
+}
+
+// QuotaTracker enforces a daily request quota per client. Requests are counted in memory and added to the totals in
+// MySQL every flush interval, so quotas hold across instances without a database write per request. Totals from
+// other instances are picked up on each flush, so a client can overshoot by what it sends in one interval
+type QuotaTracker struct {
+	usage         models.UsageRepository
+	limit         int64
+	flushInterval time.Duration
+	now           func() time.Time
+
+	mu      sync.Mutex
+	day     string
+	pending map[string]int64
+	totals  map[string]int64
+	//stale holds counts from previous days that are still waiting to be flushed
+	stale []dayUsage
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type usageReport struct {
+	XMLName   xml.Name  `json:"-" xml:"usage"`
+	Client    string    `json:"client" xml:"client"`
+	Day       string    `json:"day" xml:"day"`
+	Used      int64     `json:"used" xml:"used"`
+	Limit     int64     `json:"limit" xml:"limit"`
+	Remaining int64     `json:"remaining" xml:"remaining"`
+	ResetsAt  time.Time `json:"resetsAt" xml:"resetsAt"`
+}
+
+// NewQuotaTracker builds a tracker allowing each client limit requests per UTC day, saving counts every
+// flushInterval once started
+func NewQuotaTracker(usage models.UsageRepository, limit int64, flushInterval time.Duration) (*QuotaTracker, error) {
+	if limit < 1 {
+		return nil, fmt.Errorf("quota.daily must be at least 1, got %d", limit)
+	}
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("quota.flushInterval must be positive, got %s", flushInterval)
+	}
+	return &QuotaTracker{
+		usage:         usage,
+		limit:         limit,
+		flushInterval: flushInterval,
+		now:           time.Now,
+		pending:       make(map[string]int64),
+		totals:        make(map[string]int64),
+	}, nil
+}
+
+// rollover starts counting a new day once the clock passes midnight UTC. The caller must hold q.mu
+func (q *QuotaTracker) rollover(day string) {
+	if q.day == day {
+		return
+	}
+	if len(q.pending) > 0 {
+		q.stale = append(q.stale, dayUsage{day: q.day, counts: q.pending})
+	}
+	q.day = day
+	q.pending = make(map[string]int64)
+	q.totals = make(map[string]int64)
+}
+
+// used returns the client's requests today, loading the stored total the first time the client is seen
+func (q *QuotaTracker) used(ctx context.Context, client string) (int64, error) {
+	day := q.now().UTC().Format(models.UsageDayFormat)
+	q.mu.Lock()
+	q.rollover(day)
+	total, known := q.totals[client]
+	q.mu.Unlock()
+
+	if !known {
+		var err error
+		if total, err = q.usage.SelectUsage(ctx, client, day); err != nil {
+			return 0, err
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover(day)
+	if stored, ok := q.totals[client]; ok {
+		//a flush finished while the total was loading, its figure is at least as fresh
+		total = stored
+	}
+	q.totals[client] = total
+	return total + q.pending[client], nil
+}
+
+// allow counts a request for client, reporting how many it has made today and whether this one fits in the quota
+func (q *QuotaTracker) allow(ctx context.Context, client string) (int64, bool, error) {
+	if _, err := q.used(ctx, client); err != nil {
+		return 0, false, err
+	}
+	//checked again under the lock so concurrent requests cannot both take the last request in the quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	used := q.totals[client] + q.pending[client]
+	if used >= q.limit {
+		return used, false, nil
+	}
+	q.pending[client]++
+	return used + 1, true, nil
+}
+
+// Flush adds the requests counted since the last flush to the stored totals. Counts that fail to save are kept for
+// the next attempt
+func (q *QuotaTracker) Flush(ctx context.Context) error {
+	q.mu.Lock()
+	batches := append(q.stale, dayUsage{day: q.day, counts: q.pending})
+	q.stale = nil
+	q.pending = make(map[string]int64)
+	q.mu.Unlock()
+
+	for i, batch := range batches {
+		totals, err := q.usage.AddUsage(ctx, batch.day, batch.counts)
+		if err != nil {
+			q.requeue(batches[i:])
+			return err
+		}
+		q.mu.Lock()
+		if batch.day == q.day {
+			for client, total := range totals {
+				q.totals[client] = total
+			}
+		}
+		q.mu.Unlock()
+	}
+	return nil
+}
+
+// requeue puts counts that could not be flushed back in front of anything counted since
+func (q *QuotaTracker) requeue(batches []dayUsage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, batch := range batches {
+		if batch.day != q.day {
+			q.stale = append(q.stale, batch)
+			continue
+		}
+		for client, count := range batch.counts {
+			q.pending[client] += count
+		}
+	}
+}
+
+// Start flushes counts in the background every flush interval until Stop is called
+func (q *QuotaTracker) Start() {
+	q.stop = make(chan struct{})
+	q.done = make(chan struct{})
+	go func() {
+		defer close(q.done)
+		ticker := time.NewTicker(q.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := q.Flush(context.Background()); err != nil {
+					slog.Warn("failed to save request quota usage, retrying next interval", "error", err)
+				}
+			case <-q.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends background flushing and saves whatever has been counted since the last flush
+func (q *QuotaTracker) Stop(ctx context.Context) error {
+	if q.stop != nil {
+		close(q.stop)
+		<-q.done
+	}
+	return q.Flush(ctx)
+}
+
+// resetsAt is when the current day's quota starts over
+func (q *QuotaTracker) resetsAt() time.Time {
+	return q.now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// check counts a request from client, returning Retry-After as a name and value pair along with a
+// TooManyRequestsError once the client has used its daily quota. If usage cannot be loaded the request is let
+// through, the quota is a guard against runaway clients rather than something to fail closed on
+func (q *QuotaTracker) check(ctx context.Context, client string) ([]string, error) {
+	_, allowed, err := q.allow(ctx, client)
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to load request quota usage", "error", err)
+		return nil, nil
+	}
+	if !allowed {
+		resetsAt := q.resetsAt()
+		return []string{"Retry-After", strconv.Itoa(int(math.Ceil(resetsAt.Sub(q.now()).Seconds())))}, &TooManyRequestsError{
+			Code:   ErrCodeQuotaExceeded,
+			Detail: fmt.Sprintf("Daily quota of %d requests used up, it resets at %s", q.limit, resetsAt.Format(time.RFC3339)),
+		}
+	}
+	return nil, nil
+}
+
+// Middleware rejects requests with a 429 once the client has used its daily quota
+func (q *QuotaTracker) Middleware() gin.HandlerFunc {
+	return handle(func(c *gin.Context) error {
+		headers, err := q.check(c.Request.Context(), clientKey(c))
+		setHeaders(c, headers)
+		return err
+	})
+}
+
+// Usage reports how much of the daily quota the caller has used
+// @Summary report the caller's request quota usage for the current UTC day
+// @Tags usage
+// @ID fetch-usage
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Success 200 {object} usageReport
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /usage [get]
+func (q *QuotaTracker) Usage(c *gin.Context) error {
+	client := clientKey(c)
+	used, err := q.used(c.Request.Context(), client)
+	if err != nil {
+		return &InternalError{Detail: "Error loading quota usage", Err: err}
+	}
+
+	return respond(c, http.StatusOK, usageReport{
+		Client:    client,
+		Day:       q.now().UTC().Format(models.UsageDayFormat),
+		Used:      used,
+		Limit:     q.limit,
+		Remaining: max(q.limit-used, 0),
+		ResetsAt:  q.resetsAt(),
+	})
+}
+
+// RegisterUsageRoutes adds GET /usage. It needs Authenticate to have run first
+func RegisterUsageRoutes(r *gin.Engine, quotas *QuotaTracker) {
+	r.GET("/usage", requireRole(auth.RoleReader), negotiateContent(), handle(quotas.Usage))
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+type mockUsageRepository struct {
+	//stored totals by day and client
+	usage map[string]map[string]int64
+	err   error
+	adds  int
+}
+
+func (m *mockUsageRepository) AddUsage(ctx context.Context, day string, deltas map[string]int64) (map[string]int64, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.adds++
+	if m.usage[day] == nil {
+		m.usage[day] = make(map[string]int64)
+	}
+	totals := make(map[string]int64, len(deltas))
+	for client, delta := range deltas {
+		m.usage[day][client] += delta
+		totals[client] = m.usage[day][client]
+	}
+	return totals, nil
+}
+
+func (m *mockUsageRepository) SelectUsage(ctx context.Context, client string, day string) (int64, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	return m.usage[day][client], nil
+}
+
+func TestQuota(t *testing.T) {
+	repo := &mockUsageRepository{usage: map[string]map[string]int64{"2024-03-01": {"alice": 2}}}
+	quotas, err := NewQuotaTracker(repo, 3, time.Minute)
+	assert.Equal(t, err, nil)
+	now := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	quotas.now = func() time.Time { return now }
+
+	router := SetupRouter(RouterConfig{})
+	router.Use(withPrincipal(), quotas.Middleware())
+	router.GET("/users/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(client string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		req.Header.Set("X-Test-Client", client)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	//alice already made two requests today on another instance
+	assert.Equal(t, request("alice").Code, 200)
+	w := request("alice")
+	assert.Equal(t, w.Code, 429)
+	assert.Equal(t, w.Header().Get("Retry-After"), "3600")
+	assert.Equal(t, parseProblem(t, w).Code, ErrCodeQuotaExceeded.Code)
+	assert.Equal(t, parseProblem(t, w).Detail, "Daily quota of 3 requests used up, it resets at 2024-03-02T00:00:00Z")
+	assert.Equal(t, request("bob").Code, 200)
+
+	assert.Equal(t, quotas.Flush(context.Background()), nil)
+	assert.Equal(t, repo.usage["2024-03-01"], map[string]int64{"alice": 3, "bob": 1})
+
+	//totals written by other instances are picked up on flush
+	repo.usage["2024-03-01"]["bob"] = 3
+	assert.Equal(t, quotas.Flush(context.Background()), nil)
+	assert.Equal(t, request("bob").Code, 200)
+	assert.Equal(t, quotas.Flush(context.Background()), nil)
+	assert.Equal(t, request("bob").Code, 429)
+
+	//the quota starts over at midnight UTC and yesterday's counts are still flushed
+	repo.usage["2024-03-01"]["bob"] = 0
+	request("carol")
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, request("alice").Code, 200)
+	assert.Equal(t, quotas.Flush(context.Background()), nil)
+	assert.Equal(t, repo.usage["2024-03-01"]["carol"], int64(1))
+	assert.Equal(t, repo.usage["2024-03-02"], map[string]int64{"alice": 1})
+}
+
+func TestQuotaConfig(t *testing.T) {
+	_, err := NewQuotaTracker(&mockUsageRepository{}, 0, time.Minute)
+	assert.Equal(t, err.Error(), "quota.daily must be at least 1, got 0")
+	//a zero interval would panic once the tracker is started
+	_, err = NewQuotaTracker(&mockUsageRepository{}, 10, 0)
+	assert.Equal(t, err.Error(), "quota.flushInterval must be positive, got 0s")
+}
+
+func TestQuotaFlushFailure(t *testing.T) {
+	repo := &mockUsageRepository{usage: map[string]map[string]int64{}}
+	quotas, _ := NewQuotaTracker(repo, 10, time.Minute)
+	quotas.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	ctx := context.Background()
+	quotas.allow(ctx, "alice")
+	quotas.allow(ctx, "alice")
+	repo.err = errors.New("connection refused")
+	assert.Equal(t, errors.Is(quotas.Flush(ctx), repo.err), true)
+
+	//counts that failed to save are kept for the next flush
+	repo.err = nil
+	quotas.allow(ctx, "alice")
+	assert.Equal(t, quotas.Flush(ctx), nil)
+	assert.Equal(t, repo.usage["2024-03-01"]["alice"], int64(3))
+	assert.Equal(t, repo.adds, 1)
+}
+
+func TestQuotaFailsOpen(t *testing.T) {
+	repo := &mockUsageRepository{err: errors.New("connection refused")}
+	quotas, _ := NewQuotaTracker(repo, 1, time.Minute)
+
+	router := SetupRouter(RouterConfig{})
+	router.Use(withPrincipal(), quotas.Middleware())
+	router.GET("/users/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, 200)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	repo := &mockUsageRepository{usage: map[string]map[string]int64{"2024-03-01": {"apikey:bootstrap": 4}}}
+	quotas, _ := NewQuotaTracker(repo, 5, time.Minute)
+	quotas.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	router := SetupRouter(RouterConfig{})
+	router.Use(withPrincipal(), quotas.Middleware())
+	RegisterUsageRoutes(router, quotas)
+
+	request := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/usage", nil)
+		req.Header.Set("X-Test-Client", "apikey:bootstrap")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request()
+	assert.Equal(t, w.Code, 200)
+	var report usageReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, report, usageReport{
+		Client:    "apikey:bootstrap",
+		Day:       "2024-03-01",
+		Used:      5,
+		Limit:     5,
+		Remaining: 0,
+		ResetsAt:  time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+	})
+	assert.Equal(t, request().Code, 429)
+}
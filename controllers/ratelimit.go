@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitSweepInterval is how often buckets that have refilled completely are dropped, so memory use follows the
+// number of active clients rather than every client ever seen
+const rateLimitSweepInterval = time.Minute
+
+// RateLimitRule is a token bucket refilled at Rate requests per second that holds at most Burst requests. Route is
+// the method and route template the rule applies to, e.g. "GET /addresses/", and is empty for the default rule
+type RateLimitRule struct {
+	Route string  `mapstructure:"route"`
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// policy describes the rule in the RateLimit-Policy header format: the quota and the window it refills over
+func (r RateLimitRule) policy() string {
+	return fmt.Sprintf("%d;w=%d", r.Burst, int(math.Ceil(float64(r.Burst)/r.Rate)))
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	//rule is the rule the bucket refills by, kept here as client keys may contain the | the bucket key is joined with
+	rule RateLimitRule
+}
+
+// RateLimiter throttles each client with a token bucket per route rule. Routes without a rule of their own share
+// the default rule's bucket
+type RateLimiter struct {
+	defaultRule RateLimitRule
+	routes      map[string]RateLimitRule
+	now         func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter checks the rules and builds a limiter enforcing them
+func NewRateLimiter(defaultRule RateLimitRule, routes []RateLimitRule) (*RateLimiter, error) {
+	var problems []string
+	check := func(name string, rule RateLimitRule) {
+		if rule.Rate <= 0 {
+			problems = append(problems, fmt.Sprintf("%s rate must be positive, got %v", name, rule.Rate))
+		}
+		if rule.Burst < 1 {
+			problems = append(problems, fmt.Sprintf("%s burst must be at least 1, got %d", name, rule.Burst))
+		}
+	}
+
+	check("default", defaultRule)
+	defaultRule.Route = ""
+	limiter := &RateLimiter{
+		defaultRule: defaultRule,
+		routes:      make(map[string]RateLimitRule, len(routes)),
+		now:         time.Now,
+		buckets:     make(map[string]*tokenBucket),
+	}
+	for _, rule := range routes {
+		method, path, found := strings.Cut(rule.Route, " ")
+		if !found || method == "" || !strings.HasPrefix(path, "/") {
+			problems = append(problems, fmt.Sprintf("route [%s] must be a method and path such as \"GET /addresses/\"", rule.Route))
+			continue
+		}
+		check("route ["+rule.Route+"]", rule)
+		//buckets are keyed by the rule's route and swept by looking it up here, so both use the normalized form
+		rule.Route = strings.ToUpper(method) + " " + path
+		limiter.routes[rule.Route] = rule
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid rate limit config:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return limiter, nil
+}
+
+// take removes a token from the client's bucket for rule, returning the tokens left and, when the bucket was
+// empty, how long until the next token is available
+func (l *RateLimiter) take(client string, rule RateLimitRule) (remaining float64, wait time.Duration, ok bool) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	key := client + "|" + rule.Route
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(rule.Burst), updated: now, rule: rule}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(rule.Burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*rule.Rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return bucket.tokens, time.Duration((1 - bucket.tokens) / rule.Rate * float64(time.Second)), false
+	}
+	bucket.tokens--
+	return bucket.tokens, 0, true
+}
+
+// refund puts back a token take removed from the client's bucket for rule
+func (l *RateLimiter) refund(client string, rule RateLimitRule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bucket, found := l.buckets[client+"|"+rule.Route]; found {
+		bucket.tokens = math.Min(float64(rule.Burst), bucket.tokens+1)
+	}
+}
+
+// sweep drops buckets that have been idle long enough to have refilled, they are recreated full when needed
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		rule := bucket.rule
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*rule.Rate >= float64(rule.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// limit takes a token from the client's bucket for rule, returning the RateLimit-* headers to report as name and
+// value pairs, and a TooManyRequestsError along with Retry-After once the bucket is empty
+func (l *RateLimiter) limit(client string, rule RateLimitRule) ([]string, error) {
+	remaining, wait, allowed := l.take(client, rule)
+	headers := []string{
+		"RateLimit-Policy", rule.policy(),
+		"RateLimit-Limit", strconv.Itoa(rule.Burst),
+		"RateLimit-Remaining", strconv.Itoa(int(math.Floor(remaining))),
+		"RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(rule.Burst) - remaining) / rule.Rate))),
+	}
+	if allowed {
+		return headers, nil
+	}
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	return append(headers, "Retry-After", strconv.Itoa(retryAfter)), &TooManyRequestsError{
+		Code:   ErrCodeRateLimited,
+		Detail: fmt.Sprintf("Rate limit of %d requests exceeded, retry in %d seconds", rule.Burst, retryAfter),
+	}
+}
+
+// failedAuthKey is the bucket requests with rejected credentials from ip are counted in
+func failedAuthKey(ip string) string {
+	return "auth-failures:" + ip
+}
+
+// limitFailedAuth takes a token from the failed credentials bucket for ip, returning Retry-After as a name and
+// value pair along with a TooManyRequestsError once the bucket is empty
+func (l *RateLimiter) limitFailedAuth(ip string) ([]string, error) {
+	if _, wait, allowed := l.take(failedAuthKey(ip), l.defaultRule); !allowed {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		return []string{"Retry-After", strconv.Itoa(retryAfter)}, &TooManyRequestsError{
+			Code:   ErrCodeRateLimited,
+			Detail: fmt.Sprintf("Too many requests with invalid credentials, retry in %d seconds", retryAfter),
+		}
+	}
+	return nil, nil
+}
+
+// LimitFailedAuth holds each IP to the default rule for requests that Authenticate rejects, so guessing credentials
+// is throttled and an IP that keeps failing is turned away before its credentials are looked up again. Requests
+// that authenticate get their token back and are limited by Middleware instead. It must be added just before
+// Authenticate
+func (l *RateLimiter) LimitFailedAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		headers, err := l.limitFailedAuth(c.ClientIP())
+		setHeaders(c, headers)
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+		var unauthorized *UnauthorizedError
+		if last := c.Errors.Last(); last == nil || !errors.As(last.Err, &unauthorized) {
+			l.refund(failedAuthKey(c.ClientIP()), l.defaultRule)
+		}
+	}
+}
+
+// Middleware rejects requests with a 429 once the client has used up its bucket for the route, and reports the
+// limit on every response in the RateLimit-* headers. It must be added after Authenticate so clients are told
+// apart by API key or token rather than only by IP
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return handle(func(c *gin.Context) error {
+		rule, ok := l.routes[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			rule = l.defaultRule
+		}
+		headers, err := l.limit(clientKey(c), rule)
+		setHeaders(c, headers)
+		return err
+	})
+}
+
+// setHeaders sets response headers given as name and value pairs
+func setHeaders(c *gin.Context, headers []string) {
+	for i := 0; i+1 < len(headers); i += 2 {
+		c.Header(headers[i], headers[i+1])
+	}
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+// withPrincipal authenticates every request as the principal named in the X-Test-Client header, or leaves it
+// anonymous when the header is missing
+func withPrincipal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id := c.GetHeader("X-Test-Client"); id != "" {
+			c.Set(principalContextKey, auth.Principal{Id: id, Name: id, Roles: []auth.Role{auth.RoleAdmin}})
+		}
+		c.Next()
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimitRule{Rate: 1, Burst: 2}, []RateLimitRule{{Route: "GET /addresses/", Rate: 0.5, Burst: 1}})
+	assert.Equal(t, err, nil)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	router := SetupRouter(RouterConfig{})
+	router.Use(withPrincipal(), limiter.Middleware())
+	router.GET("/users/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/addresses/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	type test struct {
+		client          string
+		path            string
+		advance         time.Duration
+		wantedCode      int
+		wantedRemaining string
+		wantedRetry     string
+	}
+
+	tests := []test{
+		{client: "alice", path: "/users/", wantedCode: 200, wantedRemaining: "1"},
+		{client: "alice", path: "/users/", wantedCode: 200, wantedRemaining: "0"},
+		{client: "alice", path: "/users/", wantedCode: 429, wantedRemaining: "0", wantedRetry: "1"},
+		//other clients and routes with their own rule have separate buckets
+		{client: "bob", path: "/users/", wantedCode: 200, wantedRemaining: "1"},
+		{client: "alice", path: "/addresses/", wantedCode: 200, wantedRemaining: "0"},
+		{client: "alice", path: "/addresses/", wantedCode: 429, wantedRemaining: "0", wantedRetry: "2"},
+		//anonymous clients are limited by IP
+		{path: "/users/", wantedCode: 200, wantedRemaining: "1"},
+		//tokens refill over time
+		{client: "alice", path: "/users/", advance: 1500 * time.Millisecond, wantedCode: 200, wantedRemaining: "0"},
+		{client: "alice", path: "/addresses/", wantedCode: 429, wantedRemaining: "0", wantedRetry: "1"},
+		{client: "alice", path: "/addresses/", advance: time.Minute, wantedCode: 200, wantedRemaining: "0"},
+	}
+
+	for _, testCase := range tests {
+		now = now.Add(testCase.advance)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", testCase.path, nil)
+		if testCase.client != "" {
+			req.Header.Set("X-Test-Client", testCase.client)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		assert.Equal(t, w.Header().Get("RateLimit-Remaining"), testCase.wantedRemaining)
+		assert.Equal(t, w.Header().Get("Retry-After"), testCase.wantedRetry)
+		if testCase.wantedCode == 429 {
+			assert.Equal(t, parseProblem(t, w).Code, ErrCodeRateLimited.Code)
+		}
+	}
+
+	//idle buckets are dropped once they have refilled
+	now = now.Add(time.Hour)
+	limiter.take("carol", limiter.defaultRule)
+	assert.Equal(t, len(limiter.buckets), 1)
+}
+
+func TestRateLimiterConfig(t *testing.T) {
+	_, err := NewRateLimiter(RateLimitRule{Rate: 0, Burst: 0}, []RateLimitRule{{Route: "/addresses/", Rate: 1, Burst: 1}, {Route: "get /users/", Rate: -1, Burst: 5}})
+	assert.Equal(t, err.Error(), "invalid rate limit config:\n"+
+		"  - default rate must be positive, got 0\n"+
+		"  - default burst must be at least 1, got 0\n"+
+		"  - route [/addresses/] must be a method and path such as \"GET /addresses/\"\n"+
+		"  - route [get /users/] rate must be positive, got -1")
+}
+
+func TestRateLimiterLowerCaseRoute(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimitRule{Rate: 100, Burst: 100}, []RateLimitRule{{Route: "get /addresses/", Rate: 0.001, Burst: 1}})
+	assert.Equal(t, err, nil)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	rule := limiter.routes["GET /addresses/"]
+	assert.Equal(t, rule.Route, "GET /addresses/")
+
+	_, _, allowed := limiter.take("alice", rule)
+	assert.Equal(t, allowed, true)
+	//a sweep keeps the emptied bucket instead of dropping it and handing out a full one
+	now = now.Add(2 * rateLimitSweepInterval)
+	_, _, allowed = limiter.take("alice", rule)
+	assert.Equal(t, allowed, false)
+}
+
+func TestRateLimiterFailedAuth(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimitRule{Rate: 1, Burst: 2}, nil)
+	assert.Equal(t, err, nil)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	//requests with the X-Test-Bad header are rejected the way Authenticate rejects unknown keys
+	lookups := 0
+	router := SetupRouter(RouterConfig{})
+	router.Use(limiter.LimitFailedAuth(), func(c *gin.Context) {
+		lookups++
+		if c.GetHeader("X-Test-Bad") != "" {
+			c.Error(&UnauthorizedError{Detail: "Invalid API key"})
+			c.Abort()
+		}
+	})
+	router.GET("/users/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	type test struct {
+		bad          bool
+		wantedCode   int
+		wantedLookup int
+		wantedRetry  string
+	}
+
+	tests := []test{
+		//requests that authenticate give their token back, so they never run the IP out
+		{wantedCode: 200, wantedLookup: 1},
+		{wantedCode: 200, wantedLookup: 2},
+		{wantedCode: 200, wantedLookup: 3},
+		{bad: true, wantedCode: 401, wantedLookup: 4},
+		{bad: true, wantedCode: 401, wantedLookup: 5},
+		//once the failures use up the burst the credentials are not looked up at all
+		{bad: true, wantedCode: 429, wantedLookup: 5, wantedRetry: "1"},
+		{wantedCode: 429, wantedLookup: 5, wantedRetry: "1"},
+	}
+
+	for _, testCase := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		if testCase.bad {
+			req.Header.Set("X-Test-Bad", "true")
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		assert.Equal(t, lookups, testCase.wantedLookup)
+		assert.Equal(t, w.Header().Get("Retry-After"), testCase.wantedRetry)
+		if testCase.wantedCode == 429 {
+			assert.Equal(t, parseProblem(t, w).Code, ErrCodeRateLimited.Code)
+		}
+	}
+}
+
+func TestRateLimiterClientWithSeparator(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimitRule{Rate: 0.001, Burst: 1}, []RateLimitRule{{Route: "GET /addresses/", Rate: 0.001, Burst: 1}})
+	assert.Equal(t, err, nil)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	//subjects such as Auth0's contain the | bucket keys are joined with
+	client := "jwt:auth0|abc"
+	for _, rule := range []RateLimitRule{limiter.defaultRule, limiter.routes["GET /addresses/"]} {
+		_, _, allowed := limiter.take(client, rule)
+		assert.Equal(t, allowed, true)
+	}
+	//a sweep keeps the emptied buckets instead of dropping them and handing out full ones
+	now = now.Add(2 * rateLimitSweepInterval)
+	for _, rule := range []RateLimitRule{limiter.defaultRule, limiter.routes["GET /addresses/"]} {
+		_, _, allowed := limiter.take(client, rule)
+		assert.Equal(t, allowed, false)
+	}
+}
@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"gopkg.in/yaml.v3"
+)
+
+// Media types the API reads and writes. The first of each group is the one responses are labelled with, the rest
+// are accepted as aliases
+var (
+	jsonMediaTypes    = []string{"application/json"}
+	xmlMediaTypes     = []string{"application/xml", "text/xml"}
+	yamlMediaTypes    = []string{"application/yaml", "application/x-yaml", "text/yaml"}
+	csvMediaTypes     = []string{"text/csv"}
+	msgpackMediaTypes = []string{"application/msgpack", "application/x-msgpack"}
+)
+
+// responseFormat is a representation responses can be rendered in
+type responseFormat struct {
+	mediaTypes []string
+	//listsOnly formats can only represent lists of flat records
+	listsOnly bool
+	render    func(c *gin.Context, status int, data any) error
+}
+
+// responseFormats in order of preference, the first is picked when the client accepts several equally
+var responseFormats = []responseFormat{
+	{mediaTypes: jsonMediaTypes, render: renderJSON},
+	{mediaTypes: xmlMediaTypes, render: renderXML},
+	{mediaTypes: yamlMediaTypes, render: renderYAML},
+	{mediaTypes: csvMediaTypes, listsOnly: true, render: renderCSV},
+	{mediaTypes: msgpackMediaTypes, render: renderMsgPack},
+}
+
+// mediaRange is one entry of an Accept header, such as text/* or application/json;q=0.5
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept reads the media ranges of an Accept header. A missing header accepts anything
+func parseAccept(header string) []mediaRange {
+	if strings.TrimSpace(header) == "" {
+		return []mediaRange{{mediaType: "*/*", q: 1}}
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		entry := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(mediaType)), q: 1}
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(name) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					entry.q = q
+				}
+			}
+		}
+		if entry.mediaType != "" {
+			ranges = append(ranges, entry)
+		}
+	}
+	return ranges
+}
+
+// quality is the q value ranges give mediaType, taken from the most specific range that matches it
+func quality(ranges []mediaRange, mediaType string) float64 {
+	kind, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		matched := -1
+		switch r.mediaType {
+		case mediaType:
+			matched = 2
+		case kind + "/*":
+			matched = 1
+		case "*/*":
+			matched = 0
+		}
+		if matched > specificity {
+			q, specificity = r.q, matched
+		}
+	}
+	return q
+}
+
+// preference is the best q value ranges give any of mediaTypes
+func preference(ranges []mediaRange, mediaTypes []string) float64 {
+	best := 0.0
+	for _, mediaType := range mediaTypes {
+		best = max(best, quality(ranges, mediaType))
+	}
+	return best
+}
+
+// negotiate picks the format the client prefers out of those able to represent the response
+func negotiate(c *gin.Context, tabular bool) (responseFormat, bool) {
+	ranges := parseAccept(c.GetHeader("Accept"))
+	var chosen responseFormat
+	best := 0.0
+	for _, format := range responseFormats {
+		if format.listsOnly && !tabular {
+			continue
+		}
+		if q := preference(ranges, format.mediaTypes); q > best {
+			chosen, best = format, q
+		}
+	}
+	return chosen, best > 0
+}
+
+func notAcceptable() error {
+	return &NotAcceptableError{Detail: "None of the media types in the Accept header can be produced. Supported types are " +
+		"application/json, application/xml, application/yaml, application/msgpack and, for lists, text/csv"}
+}
+
+// negotiateContent rejects requests whose Accept header rules out every format up front, so the handler never runs
+// for a response the client cannot read. Only GET requests return lists, so CSV only counts for those
+func negotiateContent() gin.HandlerFunc {
+	return handle(func(c *gin.Context) error {
+		if _, ok := negotiate(c, c.Request.Method == http.MethodGet); !ok {
+			return notAcceptable()
+		}
+		return nil
+	})
+}
+
+// isList reports whether data is a slice of records
+func isList(data any) bool {
+	t := reflect.TypeOf(data)
+	return t != nil && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct
+}
+
+// isTabular reports whether data is a list of flat records, the only shape that can be written as CSV. Records with
+// embedded lists, such as users with ?include=addresses, are not
+func isTabular(data any) bool {
+	if !isList(data) {
+		return false
+	}
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(data).Elem()) {
+		if jsonName(field) != "" && field.Type.Kind() == reflect.Slice {
+			return false
+		}
+	}
+	return true
+}
+
+// respond writes data with status in the format negotiated from the Accept header
+func respond(c *gin.Context, status int, data any) error {
+	format, ok := negotiate(c, isTabular(data))
+	if !ok {
+		return notAcceptable()
+	}
+	return format.render(c, status, data)
+}
+
+// renderJSON writes indented JSON, or compact JSON when the request sets ?pretty=false
+func renderJSON(c *gin.Context, status int, data any) error {
+	if pretty, err := strconv.ParseBool(c.Query("pretty")); err == nil && !pretty {
+		c.JSON(status, data)
+	} else {
+		c.IndentedJSON(status, data)
+	}
+	return nil
+}
+
+// xmlList wraps a list so it is written as a single document, e.g. <users><user>...</user></users>
+type xmlList struct {
+	XMLName xml.Name
+	Items   any
+}
+
+// xmlListName pluralizes the element name of the list's records, falling back to items
+func xmlListName(list any) string {
+	field, ok := reflect.TypeOf(list).Elem().FieldByName("XMLName")
+	name, _, _ := strings.Cut(field.Tag.Get("xml"), ",")
+	if !ok || name == "" {
+		return "items"
+	}
+	if strings.HasSuffix(name, "s") {
+		return name + "es"
+	}
+	return name + "s"
+}
+
+func renderXML(c *gin.Context, status int, data any) error {
+	if isList(data) {
+		data = xmlList{XMLName: xml.Name{Local: xmlListName(data)}, Items: data}
+	}
+	c.XML(status, data)
+	return nil
+}
+
+// renderYAML converts the JSON representation, so field names and values match JSON responses exactly
+func renderYAML(c *gin.Context, status int, data any) error {
+	doc, err := json.Marshal(data)
+	if err != nil {
+		return &InternalError{Detail: "Error encoding response", Err: err}
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(doc, &node); err != nil {
+		return &InternalError{Detail: "Error encoding response", Err: err}
+	}
+	blockStyle(&node)
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		return &InternalError{Detail: "Error encoding response", Err: err}
+	}
+	c.Data(status, yamlMediaTypes[0]+"; charset=utf-8", out)
+	return nil
+}
+
+// blockStyle clears the flow and quoting styles a node picks up from being parsed as JSON, leaving the encoder to
+// quote only the values that need it
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// renderMsgPack converts the JSON representation, so field names and values match JSON responses exactly
+func renderMsgPack(c *gin.Context, status int, data any) error {
+	value, err := jsonValue(data)
+	if err != nil {
+		return &InternalError{Detail: "Error encoding response", Err: err}
+	}
+	c.Header("Content-Type", msgpackMediaTypes[0])
+	c.Render(status, render.MsgPack{Data: value})
+	return nil
+}
+
+// jsonValue round trips data through JSON into maps, slices and scalars, keeping whole numbers as integers
+func jsonValue(data any) (any, error) {
+	doc, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return numbers(value), nil
+}
+
+func numbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = numbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = numbers(item)
+		}
+	}
+	return value
+}
+
+// renderCSV writes a list of records with a header row of their JSON field names
+func renderCSV(c *gin.Context, status int, data any) error {
+	list := reflect.ValueOf(data)
+	var fields []reflect.StructField
+	var header []string
+	for _, field := range reflect.VisibleFields(list.Type().Elem()) {
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		fields = append(fields, field)
+		header = append(header, name)
+	}
+
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	writer.Write(header)
+	for i := 0; i < list.Len(); i++ {
+		row := make([]string, len(fields))
+		for j, field := range fields {
+			row[j] = csvValue(list.Index(i).FieldByIndex(field.Index))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return &InternalError{Detail: "Error encoding response", Err: err}
+	}
+	c.Data(status, csvMediaTypes[0]+"; charset=utf-8", out.Bytes())
+	return nil
+}
+
+// csvValue formats a field the way it appears in JSON, with nil pointers left empty
+func csvValue(value reflect.Value) string {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	switch v := value.Interface().(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(value.Interface())
+}
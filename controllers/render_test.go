@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+	"github.com/ugorji/go/codec"
+)
+
+func TestResponseFormats(t *testing.T) {
+	users := []models.User{{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "O'Neil, Jr"}}
+
+	type test struct {
+		path              string
+		accept            string
+		wantedCode        int
+		wantedContentType string
+		wantedBody        string
+	}
+
+	tests := []test{
+		{path: "/users/", accept: "", wantedCode: 200, wantedContentType: "application/json; charset=utf-8",
+			wantedBody: "[\n    {\n        \"id\": \"493adb28-9da1-4db8-893d-73cc2d7bd4ee\",\n        \"firstName\": \"Pat\",\n        \"lastName\": \"O'Neil, Jr\"\n    }\n]"},
+		{path: "/users/?pretty=false", accept: "application/json", wantedCode: 200, wantedContentType: "application/json; charset=utf-8",
+			wantedBody: `[{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","firstName":"Pat","lastName":"O'Neil, Jr"}]`},
+		{path: "/users/", accept: "application/xml", wantedCode: 200, wantedContentType: "application/xml; charset=utf-8",
+			wantedBody: "<users><user><id>493adb28-9da1-4db8-893d-73cc2d7bd4ee</id><firstName>Pat</firstName><lastName>O&#39;Neil, Jr</lastName></user></users>"},
+		{path: "/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee", accept: "text/xml", wantedCode: 200, wantedContentType: "application/xml; charset=utf-8",
+			wantedBody: "<user><id>493adb28-9da1-4db8-893d-73cc2d7bd4ee</id><firstName>Pat</firstName><lastName>O&#39;Neil, Jr</lastName></user>"},
+		{path: "/users/", accept: "application/x-yaml", wantedCode: 200, wantedContentType: "application/yaml; charset=utf-8",
+			wantedBody: "- id: 493adb28-9da1-4db8-893d-73cc2d7bd4ee\n  firstName: Pat\n  lastName: O'Neil, Jr\n"},
+		{path: "/users/", accept: "text/csv", wantedCode: 200, wantedContentType: "text/csv; charset=utf-8",
+			wantedBody: "id,firstName,lastName\n493adb28-9da1-4db8-893d-73cc2d7bd4ee,Pat,\"O'Neil, Jr\"\n"},
+		//preferences are weighed by q value, and ties go to JSON
+		{path: "/users/?pretty=false", accept: "application/xml;q=0.5, application/json", wantedCode: 200, wantedContentType: "application/json; charset=utf-8",
+			wantedBody: `[{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","firstName":"Pat","lastName":"O'Neil, Jr"}]`},
+		{path: "/users/", accept: "application/json;q=0.1, text/*", wantedCode: 200, wantedContentType: "application/xml; charset=utf-8",
+			wantedBody: "<users><user><id>493adb28-9da1-4db8-893d-73cc2d7bd4ee</id><firstName>Pat</firstName><lastName>O&#39;Neil, Jr</lastName></user></users>"},
+		{path: "/users/?pretty=false", accept: "*/*, text/csv;q=0", wantedCode: 200, wantedContentType: "application/json; charset=utf-8",
+			wantedBody: `[{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","firstName":"Pat","lastName":"O'Neil, Jr"}]`},
+		//CSV only represents lists
+		{path: "/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee", accept: "text/csv", wantedCode: 406, wantedContentType: "application/problem+json"},
+		{path: "/users/", accept: "image/png", wantedCode: 406, wantedContentType: "application/problem+json"},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &mockUserRepository{users: users}, nil, RouteVersions{})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", testCase.path, nil)
+		req.Header.Set("Accept", testCase.accept)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		assert.Equal(t, w.Header().Get("Content-Type"), testCase.wantedContentType)
+		if testCase.wantedCode == 406 {
+			assert.Equal(t, parseProblem(t, w).Code, ErrCodeNotAcceptable.Code)
+		} else {
+			assert.Equal(t, w.Body.String(), testCase.wantedBody)
+		}
+	}
+}
+
+func TestMsgPackResponse(t *testing.T) {
+	users := []models.User{{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "Smith"}}
+	router := SetupRouter(RouterConfig{})
+	router.Use(AllowAnonymous())
+	RegisterRoutes(router, &mockUserRepository{users: users}, nil, RouteVersions{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/msgpack")
+	//encoded from the same values a JSON response holds, so the id is a string rather than 16 raw bytes
+	decoded, err := decodeMsgPack(w.Body.Bytes())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, decoded, any([]any{map[string]any{"id": "493adb28-9da1-4db8-893d-73cc2d7bd4ee", "firstName": "Pat", "lastName": "Smith"}}))
+}
+
+func msgpackHandle() *codec.MsgpackHandle {
+	var handle codec.MsgpackHandle
+	handle.RawToString = true
+	handle.MapType = reflect.TypeOf(map[string]any(nil))
+	return &handle
+}
+
+func decodeMsgPack(data []byte) (any, error) {
+	var value any
+	err := codec.NewDecoderBytes(data, msgpackHandle()).Decode(&value)
+	return value, err
+}
+
+func encodeMsgPack(value any) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle()).Encode(value)
+	return data, err
+}
+
+func TestRequestBodyFormats(t *testing.T) {
+	type test struct {
+		contentType   string
+		body          []byte
+		wantedCode    int
+		wantedProblem Problem
+	}
+
+	msgpackBody, err := encodeMsgPack(map[string]any{"firstName": "Pat", "lastName": "Smith"})
+	assert.Equal(t, err, nil)
+
+	tests := []test{
+		{contentType: "application/json", body: []byte(`{"firstName":"Pat","lastName":"Smith"}`), wantedCode: 201},
+		{contentType: "application/xml; charset=utf-8", body: []byte(`<user><firstName>Pat</firstName><lastName> Smith </lastName></user>`), wantedCode: 201},
+		{contentType: "application/yaml", body: []byte("firstName: Pat\nlastName: Smith\n"), wantedCode: 201},
+		{contentType: "application/msgpack", body: msgpackBody, wantedCode: 201},
+		//YAML and MessagePack bodies are checked exactly like JSON
+		{contentType: "text/yaml", body: []byte("firstName: Pat\nlastName: Smith\nmiddleName: Q\n"), wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "middleName", Code: "unknown", Message: "is not a recognized field"})},
+		{contentType: "application/xml", body: []byte(`<user><firstName>Pat</firstName></user>`), wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "lastName", Code: "required", Message: "is required"})},
+		{contentType: "application/yaml", body: []byte(""), wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed: request body is empty")},
+		{contentType: "text/plain", body: []byte("Pat Smith"), wantedCode: 415,
+			wantedProblem: wantProblem(ErrCodeUnsupportedMediaType, "Content-Type [text/plain] is not supported, send application/json, application/xml, application/yaml or application/msgpack")},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &mockUserRepository{users: []models.User{{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee")}}}, nil, RouteVersions{})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/users/", bytes.NewReader(testCase.body))
+		req.Header.Set("Content-Type", testCase.contentType)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		if testCase.wantedCode >= 400 {
+			assert.Equal(t, parseProblem(t, w), testCase.wantedProblem)
+		} else {
+			assert.Equal(t, w.Body.String(), "{\n    \"id\": \"493adb28-9da1-4db8-893d-73cc2d7bd4ee\",\n    \"firstName\": \"Pat\",\n    \"lastName\": \"Smith\"\n}")
+		}
+	}
+}
+
+func TestProblemXML(t *testing.T) {
+	router := SetupRouter(RouterConfig{})
+	router.Use(AllowAnonymous())
+	RegisterRoutes(router, &mockUserRepository{}, nil, RouteVersions{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/not-a-uuid", nil)
+	req.Header.Set("Accept", "application/xml")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 400)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/problem+xml")
+	var problem Problem
+	assert.Equal(t, xml.Unmarshal(w.Body.Bytes(), &problem), nil)
+	assert.Equal(t, problem.XMLName, xml.Name{Space: "urn:ietf:rfc:7807", Local: "problem"})
+	assert.Equal(t, problem.Code, ErrCodeInvalidId.Code)
+	assert.Equal(t, problem.Instance, "/users/not-a-uuid")
+}
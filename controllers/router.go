@@ -1,9 +1,20 @@
 package controllers
 
 import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/db"
+	"github.com/lengebretsen/go-practice/metrics"
 	"github.com/lengebretsen/go-practice/models"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"golang.org/x/net/webdav"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,37 +24,132 @@ type handler struct {
 	addresses models.AddressRepository
 }
 
-func SetupRouter() *gin.Engine {
-	r := gin.Default()
+// readPrimaryHeader lets a client that just wrote data force its reads to the primary database
+const readPrimaryHeader = "X-Read-Primary"
+
+// primaryReads routes every read made while serving the request to the primary when the client asks for it
+func primaryReads() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if forced, _ := strconv.ParseBool(c.GetHeader(readPrimaryHeader)); forced {
+			c.Request = c.Request.WithContext(db.WithPrimaryReads(c.Request.Context()))
+		}
+		c.Next()
+	}
+}
+
+// HealthReporter is implemented by anything that can tell whether the database is currently reachable
+type HealthReporter interface {
+	Degraded() bool
+}
+
+// RequireDatabase rejects requests with a 503 while the database is unreachable, telling clients when to try again.
+// It must be added before RegisterRoutes so it applies to the resource routes but not to /healthz, /metrics or /docs
+func RequireDatabase(health HealthReporter, retryAfter time.Duration) gin.HandlerFunc {
+	seconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+	return func(c *gin.Context) {
+		if health.Degraded() {
+			c.Header("Retry-After", seconds)
+			abortWithProblem(c, ErrCodeUnavailable, "database connection lost")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RouterConfig holds the settings SetupRouter needs from the server and tracing config sections
+type RouterConfig struct {
+	//ServiceName names the service in the spans recorded for requests
+	ServiceName string
+	//Debug includes the internal cause of server errors in problem details, never enable it in production
+	Debug bool
+}
+
+// SetupRouter builds the engine with the middleware every request goes through and the routes that need no
+// repositories
+func SetupRouter(cfg RouterConfig) *gin.Engine {
+	r := gin.New()
+	r.Use(
+		otelgin.Middleware(cfg.ServiceName),
+		requestID(),
+		accessLog(),
+		//outside recovery so requests that panic are counted with the 500 they get
+		metrics.Middleware(),
+		recovery(),
+		primaryReads(),
+		errorResponses(cfg.Debug),
+	)
 
-	r.GET("/ping", func(c *gin.Context) {
-		c.String(200, "pong")
+	r.GET("/healthz", Healthz)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	// docs route, the GraphiQL page and the /v2 swagger UI share it with the /v1 swagger UI as gin cannot route
+	// /docs/graphiql or /docs/v2/*any beside /docs/*any
+	swaggerV1 := ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.InstanceName("v1"))
+	//the UI's files are served by a handler of their own as each wrapper fixes its handler's path prefix
+	swaggerV2 := ginSwagger.WrapHandler(&webdav.Handler{FileSystem: swaggerFiles.FS, LockSystem: webdav.NewMemLS()},
+		ginSwagger.InstanceName("v2"))
+	r.GET("/docs/*any", func(c *gin.Context) {
+		switch {
+		case c.Param("any") == "/graphiql":
+			c.Data(http.StatusOK, "text/html; charset=utf-8", graphiQLPage)
+		case strings.HasPrefix(c.Param("any"), v2Prefix+"/"):
+			swaggerV2(c)
+		default:
+			swaggerV1(c)
+		}
 	})
-	// docs route
-	r.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	return r
 }
 
-// RegisterRoutes initializes the routes and sets up the handler's reference to the model(s) for database access
-func RegisterRoutes(r *gin.Engine, users models.UserRepository, addresses models.AddressRepository) {
+// RegisterRoutes initializes the routes and sets up the handler's reference to the model(s) for database access.
+// The user and address routes are served under /v1, as /v1 aliases at the root for clients written before versioning,
+// and in their newer representation under /v2. versions says which of the older ones to announce as deprecated.
+// Every route requires a role, so Authenticate or AllowAnonymous must have been added first
+func RegisterRoutes(r *gin.Engine, users models.UserRepository, addresses models.AddressRepository, versions RouteVersions) {
 	h := &handler{
 		users:     users,
 		addresses: addresses,
 	}
 
-	userRoutes := r.Group("/users")
-	userRoutes.POST("/", h.AddUser)
-	userRoutes.GET("/", h.FetchUsers)
-	userRoutes.GET("/:id", h.FetchUser)
-	userRoutes.PUT("/:id", h.UpdateUser)
-	userRoutes.DELETE("/:id", h.DeleteUser)
-	userRoutes.GET("/:id/addresses", h.FetchAddressesForUser)
-
-	addressRoutes := r.Group("/addresses")
-	addressRoutes.POST("/", h.AddAddress)
-	addressRoutes.GET("/", h.FetchAddresses)
-	addressRoutes.GET("/:id", h.FetchAddress)
-	addressRoutes.PUT("/:id", h.UpdateAddress)
-	addressRoutes.DELETE("/:id", h.DeleteAddress)
+	registerV1(r.Group("/v1", deprecated(versions.V1, "/v1", v2Prefix)), h)
+	registerV1(r.Group("", deprecated(versions.Unversioned, "", "/v1")), h)
+	registerV2(r.Group(v2Prefix), h)
+}
+
+func registerV1(r *gin.RouterGroup, h *handler) {
+	reader, editor, admin := requireRole(auth.RoleReader), requireRole(auth.RoleEditor), requireRole(auth.RoleAdmin)
+
+	userRoutes := r.Group("/users", negotiateContent())
+	userRoutes.POST("/", editor, handle(h.AddUser))
+	userRoutes.GET("/", reader, handle(h.FetchUsers))
+	userRoutes.GET("/:id", reader, handle(h.FetchUser))
+	userRoutes.PUT("/:id", editor, handle(h.UpdateUser))
+	userRoutes.DELETE("/:id", admin, handle(h.DeleteUser))
+	userRoutes.GET("/:id/addresses", reader, handle(h.FetchAddressesForUser))
+
+	addressRoutes := r.Group("/addresses", negotiateContent())
+	addressRoutes.POST("/", editor, handle(h.AddAddress))
+	addressRoutes.GET("/", reader, handle(h.FetchAddresses))
+	addressRoutes.GET("/:id", reader, handle(h.FetchAddress))
+	addressRoutes.PUT("/:id", editor, handle(h.UpdateAddress))
+	addressRoutes.DELETE("/:id", editor, handle(h.DeleteAddress))
+}
+
+func registerV2(r *gin.RouterGroup, h *handler) {
+	reader, editor, admin := requireRole(auth.RoleReader), requireRole(auth.RoleEditor), requireRole(auth.RoleAdmin)
+
+	userRoutes := r.Group("/users", negotiateContent())
+	userRoutes.POST("/", editor, handle(h.AddUserV2))
+	userRoutes.GET("/", reader, handle(h.FetchUsersV2))
+	userRoutes.GET("/:id", reader, handle(h.FetchUserV2))
+	userRoutes.PUT("/:id", editor, handle(h.UpdateUserV2))
+	userRoutes.DELETE("/:id", admin, handle(h.DeleteUserV2))
+	userRoutes.GET("/:id/addresses", reader, handle(h.FetchAddressesForUserV2))
+
+	addressRoutes := r.Group("/addresses", negotiateContent())
+	addressRoutes.POST("/", editor, handle(h.AddAddressV2))
+	addressRoutes.GET("/", reader, handle(h.FetchAddressesV2))
+	addressRoutes.GET("/:id", reader, handle(h.FetchAddressV2))
+	addressRoutes.PUT("/:id", editor, handle(h.UpdateAddressV2))
+	addressRoutes.DELETE("/:id", editor, handle(h.DeleteAddressV2))
 }
@@ -1,20 +1,326 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/db"
+	"github.com/lengebretsen/go-practice/models"
 	"github.com/lengebretsen/go-practice/testing/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func TestPingRoute(t *testing.T) {
-	router := SetupRouter()
+func TestHealthzRoute(t *testing.T) {
+	router := SetupRouter(RouterConfig{})
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/ping", nil)
+	req, _ := http.NewRequest("GET", "/healthz", nil)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	assert.Equal(t, "pong", w.Body.String())
+	assert.Equal(t, `{"status":"pass"}`, w.Body.String())
+}
+
+func TestReadyzRoute(t *testing.T) {
+	type test struct {
+		checks       []HealthCheck
+		wantedCode   int
+		wantedStatus string
+		wantedChecks map[string]string
+	}
+
+	passing := HealthCheck{Name: "database", Check: func(ctx context.Context) error { return nil }}
+	failing := HealthCheck{Name: "pool", Check: func(ctx context.Context) error { return errors.New("pool saturated") }}
+	slow := HealthCheck{Name: "slow", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	//holding stands in for a check using a connection while it runs, which the check run first must not see
+	var held atomic.Bool
+	holding := HealthCheck{Name: "database", Check: func(ctx context.Context) error {
+		held.Store(true)
+		defer held.Store(false)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}}
+	sampled := HealthCheck{Name: "connectionPool", First: true, Check: func(ctx context.Context) error {
+		if held.Load() {
+			return errors.New("counted a connection held by another check")
+		}
+		return nil
+	}}
+
+	draining := &Draining{}
+	draining.Begin()
+	shutdown := HealthCheck{Name: "shutdown", Check: draining.Check}
+
+	tests := []test{
+		{checks: []HealthCheck{passing}, wantedCode: 200, wantedStatus: "pass", wantedChecks: map[string]string{"database": ""}},
+		{
+			checks:       []HealthCheck{shutdown, passing},
+			wantedCode:   503,
+			wantedStatus: "fail",
+			wantedChecks: map[string]string{"shutdown": "server is shutting down", "database": ""},
+		},
+		{
+			checks:       []HealthCheck{passing, failing},
+			wantedCode:   503,
+			wantedStatus: "fail",
+			wantedChecks: map[string]string{"database": "", "pool": "pool saturated"},
+		},
+		{checks: []HealthCheck{sampled, holding}, wantedCode: 200, wantedStatus: "pass", wantedChecks: map[string]string{"database": "", "connectionPool": ""}},
+		{checks: []HealthCheck{slow}, wantedCode: 503, wantedStatus: "fail", wantedChecks: map[string]string{"slow": "context deadline exceeded"}},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		RegisterReadiness(router, 50*time.Millisecond, testCase.checks...)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, testCase.wantedCode, w.Code)
+
+		parsedResp := readinessReport{}
+		json.Unmarshal(w.Body.Bytes(), &parsedResp)
+		assert.Equal(t, parsedResp.Status, testCase.wantedStatus)
+		gotChecks := map[string]string{}
+		for name, result := range parsedResp.Checks {
+			gotChecks[name] = result.Error
+		}
+		assert.Equal(t, gotChecks, testCase.wantedChecks)
+	}
+}
+
+type mockHealthReporter struct {
+	degraded bool
+}
+
+func (m mockHealthReporter) Degraded() bool {
+	return m.degraded
+}
+
+func TestRequireDatabase(t *testing.T) {
+	type test struct {
+		degraded         bool
+		path             string
+		wantedCode       int
+		wantedRetryAfter string
+		wantedError      Problem
+	}
+
+	tests := []test{
+		{degraded: false, path: "/users/", wantedCode: 200},
+		{
+			degraded:         true,
+			path:             "/users/",
+			wantedCode:       503,
+			wantedRetryAfter: "5",
+			wantedError:      wantProblem(ErrCodeUnavailable, "database connection lost"),
+		},
+		{degraded: true, path: "/healthz", wantedCode: 200},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		router.Use(RequireDatabase(mockHealthReporter{degraded: testCase.degraded}, 5*time.Second))
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &mockUserRepository{users: []models.User{}}, nil, RouteVersions{})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", testCase.path, nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, testCase.wantedCode, w.Code)
+		assert.Equal(t, testCase.wantedRetryAfter, w.Header().Get("Retry-After"))
+		if testCase.wantedCode == 503 {
+			parsedResp := parseProblem(t, w)
+			assert.Equal(t, parsedResp, testCase.wantedError)
+		}
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	router := SetupRouter(RouterConfig{})
+	router.Use(AllowAnonymous())
+	RegisterRoutes(router, &mockUserRepository{users: []models.User{{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee")}}}, nil, RouteVersions{})
+	router.GET("/panics", func(c *gin.Context) { panic("unexpected") })
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/panics", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	//Requests are labelled by route template, not by the raw path
+	assert.Equal(t, strings.Contains(w.Body.String(), `gopractice_http_requests_total{code="200",method="GET",route="/users/:id"}`), true)
+	assert.Equal(t, strings.Contains(w.Body.String(), "493adb28-9da1-4db8-893d-73cc2d7bd4ee"), false)
+	//requests that panic are counted with the status recovery gave them
+	assert.Equal(t, strings.Contains(w.Body.String(), `gopractice_http_requests_total{code="500",method="GET",route="/panics"}`), true)
+}
+
+var (
+	recordSpansOnce sync.Once
+	spanRecorder    = tracetest.NewSpanRecorder()
+)
+
+// spansInTrace installs a tracer provider that records every span, then returns the ended spans belonging to the
+// trace with traceId. The provider is only installed once since tracers made before then, like the package tracers
+// in controllers and db, keep delegating to the first one set
+func spansInTrace(traceId string) []sdktrace.ReadOnlySpan {
+	recordSpansOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range spanRecorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceId {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	spansInTrace("")
+
+	router := SetupRouter(RouterConfig{})
+	router.Use(AllowAnonymous())
+	RegisterRoutes(router, &mockUserRepository{users: []models.User{}}, nil, RouteVersions{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	var server sdktrace.ReadOnlySpan
+	for _, span := range spansInTrace("4bf92f3577b34da6a3ce929d0e0e4736") {
+		if span.SpanKind() == trace.SpanKindServer {
+			server = span
+		}
+	}
+	//The server span joins the caller's trace and is named after the route template
+	assert.Equal(t, server.Name(), "/users/")
+	assert.Equal(t, server.Parent().SpanID().String(), "00f067aa0ba902b7")
+}
+
+// tracedUserRepository and tracedAddressRepository trace their statements the way the MySQL repositories do
+type tracedUserRepository struct {
+	mockUserRepository
+	cluster *db.Cluster
+}
+
+func (m *tracedUserRepository) SelectOneUser(ctx context.Context, id uuid.UUID) (models.User, error) {
+	_, end := m.cluster.TraceStatement(ctx, "SELECT * FROM users WHERE id = ?")
+	end(nil)
+	return m.mockUserRepository.SelectOneUser(ctx, id)
+}
+
+type tracedAddressRepository struct {
+	mockAddressRepository
+	cluster *db.Cluster
+}
+
+func (m *tracedAddressRepository) InsertAddress(ctx context.Context, addr models.Address) (models.Address, error) {
+	_, end := m.cluster.TraceStatement(ctx, "INSERT INTO addresses VALUES (?, ?, ?, ?, ?, ?, ?)")
+	end(nil)
+	return m.mockAddressRepository.InsertAddress(ctx, addr)
+}
+
+func TestHandlerSpans(t *testing.T) {
+	spansInTrace("")
+
+	userId := uuid.MustParse("80e4de8a-91c4-46cc-a66d-23d3cf364036")
+	cluster := &db.Cluster{}
+	users := &tracedUserRepository{mockUserRepository{users: []models.User{{Id: userId}}}, cluster}
+	addresses := &tracedAddressRepository{mockAddressRepository{addrs: []models.Address{{Id: uuid.New(), UserId: userId}}}, cluster}
+
+	router := SetupRouter(RouterConfig{})
+	router.Use(AllowAnonymous())
+	RegisterRoutes(router, users, addresses, RouteVersions{})
+
+	w := httptest.NewRecorder()
+	body := `{"city": "Anytown", "state": "GA", "street": "123 A St.", "type": "HOME", "userId": "` + userId.String() + `", "zip": "30033"}`
+	req, _ := http.NewRequest("POST", "/v1/addresses/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 201)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spansInTrace("0af7651916cd43dd8448eb211c80319c") {
+		spans[span.Name()] = span
+	}
+	server, handler := spans["/v1/addresses/"], spans["handler.AddAddress"]
+	assert.Equal(t, handler.Parent().SpanID(), server.SpanContext().SpanID())
+	//middleware wrapped by handle gets a span of its own beside the handler's
+	assert.Equal(t, spans["requireRole"].Parent().SpanID(), server.SpanContext().SpanID())
+	for _, name := range []string{"SELECT ", "INSERT "} {
+		assert.Equal(t, spans[name].Parent().SpanID(), handler.SpanContext().SpanID())
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	type test struct {
+		requestId string
+		wantedId  string
+	}
+
+	tests := []test{
+		{requestId: "client-supplied-id.1", wantedId: "client-supplied-id.1"},
+		//missing or malformed ids are replaced with a generated one
+		{requestId: ""},
+		{requestId: "bad id\nwith newline"},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &mockUserRepository{err: models.ErrModelNotFound}, nil, RouteVersions{})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee", nil)
+		req.Header.Set("X-Request-ID", testCase.requestId)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 404, w.Code)
+		headerId := w.Header().Get("X-Request-ID")
+		if testCase.wantedId != "" {
+			assert.Equal(t, headerId, testCase.wantedId)
+		} else {
+			_, err := uuid.Parse(headerId)
+			assert.Equal(t, err, nil)
+		}
+
+		//the same id is reported back in the error body
+		parsedResp := Problem{}
+		json.Unmarshal(w.Body.Bytes(), &parsedResp)
+		assert.Equal(t, parsedResp.RequestId, headerId)
+	}
 }
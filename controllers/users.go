@@ -1,7 +1,8 @@
 package controllers
 
 import (
-	"errors"
+	"context"
+	"encoding/xml"
 	"fmt"
 	"net/http"
 
@@ -12,146 +13,243 @@ import (
 )
 
 type addUpdateUserBody struct {
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
+	FirstName string `json:"firstName" xml:"firstName" binding:"required,max=255,personname" maxLength:"255"`
+	LastName  string `json:"lastName" xml:"lastName" binding:"required,max=255,personname" maxLength:"255"`
+}
+
+// userWithAddresses is a user with its addresses embedded, returned for ?include=addresses
+type userWithAddresses struct {
+	XMLName xml.Name `json:"-" xml:"user"`
+	models.User
+	Addresses []models.Address `json:"addresses" xml:"addresses>address"`
 }
 
 // FetchUsers retrieves a list of all users in the system
 // @Summary retrieve a list of all users in the system
 // @Tags users
 // @ID fetch-all-users
-// @Produce json
+// @Produce json,xml,application/yaml,application/msgpack,text/csv
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param fields query string false "comma separated fields to return, e.g. id,lastName"
+// @Param include query string false "related records to embed in each user" Enums(addresses)
 // @Success 200 {object} []models.User
-// @Router /users [get]
-func (h handler) FetchUsers(c *gin.Context) {
-	users, err := h.users.SelectAllUsers()
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v1/users [get]
+func (h handler) FetchUsers(c *gin.Context) error {
+	include, err := parseInclude(c, "addresses")
+	if err != nil {
+		return err
+	}
+	users, err := h.users.SelectAllUsers(c.Request.Context())
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, ApiError{Message: "Error fetching user records", Detail: err.Error()})
-		return
+		return &InternalError{Detail: "Error fetching user records", Err: err}
 	}
-	c.IndentedJSON(http.StatusOK, users)
+
+	var data any = users
+	if include["addresses"] {
+		if data, err = h.withAddresses(c.Request.Context(), users); err != nil {
+			return err
+		}
+	}
+	if data, err = selectFields(c, data, "addresses"); err != nil {
+		return err
+	}
+	return respond(c, http.StatusOK, data)
 }
 
 // FetchUser retrieves a single user by id
 // @Summary retrieve a user by Id
 // @Tags users
 // @ID fetch-user
-// @Produce json
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param id path string true "user ID"
+// @Param fields query string false "comma separated fields to return, e.g. id,lastName"
+// @Param include query string false "related records to embed in the user" Enums(addresses)
 // @Success 200 {object} models.User
-// @Failure 400 {object} ApiError
-// @Failure 404 {object} ApiError
-// @Router /users/{id} [get]
-func (h handler) FetchUser(c *gin.Context) {
-	idParam := c.Param("id")
-	id, err := uuid.Parse(idParam)
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v1/users/{id} [get]
+func (h handler) FetchUser(c *gin.Context) error {
+	id, err := parseId(c, "id")
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Message: fmt.Sprintf("Id [%s] is not a valid UUID", idParam), Detail: err.Error()})
-		return
+		return err
 	}
 
-	user, err := h.users.SelectOneUser(id)
+	include, err := parseInclude(c, "addresses")
 	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.AbortWithStatusJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No user exists with Id [%s]", idParam), Detail: err.Error()})
-			return
-		} else {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error fetching user record with Id [%s]", id), Detail: err.Error()})
-			return
+		return err
+	}
+	user, err := h.users.SelectOneUser(c.Request.Context(), id)
+	if err != nil {
+		return repositoryError(err, "user", id, fmt.Sprintf("Error fetching user record with Id [%s]", id))
+	}
+
+	var data any = user
+	if include["addresses"] {
+		withAddresses, err := h.withAddresses(c.Request.Context(), []models.User{user})
+		if err != nil {
+			return err
 		}
+		data = withAddresses[0]
+	}
+	if data, err = selectFields(c, data, "addresses"); err != nil {
+		return err
 	}
-	c.IndentedJSON(http.StatusOK, user)
+	return respond(c, http.StatusOK, data)
+}
+
+// withAddresses embeds each user's addresses, loading them for every user with a single query
+func (h handler) withAddresses(ctx context.Context, users []models.User) ([]userWithAddresses, error) {
+	ids := make([]uuid.UUID, len(users))
+	for i, user := range users {
+		ids[i] = user.Id
+	}
+	addrs, err := h.addresses.FindAddressesByUserIds(ctx, ids)
+	if err != nil {
+		return nil, &InternalError{Detail: "Error fetching addresses for users", Err: err}
+	}
+
+	byUser := make(map[uuid.UUID][]models.Address, len(users))
+	for _, addr := range addrs {
+		byUser[addr.UserId] = append(byUser[addr.UserId], addr)
+	}
+	result := make([]userWithAddresses, len(users))
+	for i, user := range users {
+		result[i] = userWithAddresses{User: user, Addresses: byUser[user.Id]}
+		if result[i].Addresses == nil {
+			result[i].Addresses = make([]models.Address, 0)
+		}
+	}
+	return result, nil
 }
 
 // AddUser stores a new user
 // @Summary add a new user
 // @Tags users
 // @ID add-user
-// @Produce json
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param data body addUpdateUserBody true "new user data"
+// @Param Idempotency-Key header string false "makes retries of this request safe, see the README"
 // @Success 200 {object} models.User
-// @Failure 400 {object} ApiError
-// @Router /users [post]
-func (h handler) AddUser(c *gin.Context) {
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /v1/users [post]
+func (h handler) AddUser(c *gin.Context) error {
 	var reqBody addUpdateUserBody
-
-	if err := c.BindJSON(&reqBody); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Message: "Invalid request body.", Detail: err.Error()})
-		return
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
 	}
-	newUser, err := h.users.InsertUser(models.User{Id: uuid.New(), FirstName: reqBody.FirstName, LastName: reqBody.LastName})
+
+	newUser, err := h.createUser(c.Request.Context(), reqBody)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, ApiError{Message: "Error creating new user", Detail: err.Error()})
-		return
+		return err
 	}
 
-	c.IndentedJSON(http.StatusCreated, newUser)
+	return respond(c, http.StatusCreated, newUser)
+}
+
+// createUser stores a new user, shared by AddUser and batch operations
+func (h handler) createUser(ctx context.Context, reqBody addUpdateUserBody) (models.User, error) {
+	newUser, err := h.users.InsertUser(ctx, models.User{Id: uuid.New(), FirstName: reqBody.FirstName, LastName: reqBody.LastName})
+	if err != nil {
+		return models.User{}, repositoryError(err, "user", uuid.Nil, "Error creating new user")
+	}
+	return newUser, nil
 }
 
 // UpdateUser modifies an existing user
 // @Summary modify an existing user
 // @Tags users
 // @ID update-user
-// @Produce json
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param id path string true "user ID"
 // @Param data body addUpdateUserBody true "new user data"
 // @Success 200 {object} models.User
-// @Failure 400 {object} ApiError
-// @Failure 404 {object} ApiError
-// @Router /users/{id} [put]
-func (h handler) UpdateUser(c *gin.Context) {
-	var reqBody addUpdateUserBody
-	idParam := c.Param("id")
-	id, err := uuid.Parse(idParam)
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /v1/users/{id} [put]
+func (h handler) UpdateUser(c *gin.Context) error {
+	id, err := parseId(c, "id")
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Message: fmt.Sprintf("Id [%s] is not a valid UUID", idParam), Detail: err.Error()})
-		return
+		return err
 	}
 
-	if err := c.BindJSON(&reqBody); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Message: "Invalid request body.", Detail: err.Error()})
-		return
+	var reqBody addUpdateUserBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
 	}
 
-	updatedUser, err := h.users.UpdateUser(models.User{Id: id, FirstName: reqBody.FirstName, LastName: reqBody.LastName})
+	updatedUser, err := h.updateUser(c.Request.Context(), id, reqBody)
 	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.AbortWithStatusJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No user exists with Id [%s]", idParam), Detail: err.Error()})
-			return
-		} else {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error updating user record with Id [%s]", id), Detail: err.Error()})
-			return
-		}
+		return err
 	}
 
-	c.IndentedJSON(http.StatusOK, updatedUser)
+	return respond(c, http.StatusOK, updatedUser)
+}
+
+// updateUser modifies an existing user, shared by UpdateUser and batch operations
+func (h handler) updateUser(ctx context.Context, id uuid.UUID, reqBody addUpdateUserBody) (models.User, error) {
+	updatedUser, err := h.users.UpdateUser(ctx, models.User{Id: id, FirstName: reqBody.FirstName, LastName: reqBody.LastName})
+	if err != nil {
+		return models.User{}, repositoryError(err, "user", id, fmt.Sprintf("Error updating user record with Id [%s]", id))
+	}
+	return updatedUser, nil
 }
 
 // DeleteUser deletes an existing user, including any addresses associated with the user
 // @Summary delete a user by Id, including any addresses associated with the user
 // @Tags users
 // @ID delete-user
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Param id path string true "user ID"
 // @Success 204
-// @Failure 400 {object} ApiError
-// @Failure 404 {object} ApiError
-// @Router /users/{id} [delete]
-func (h handler) DeleteUser(c *gin.Context) {
-	idParam := c.Param("id")
-	id, err := uuid.Parse(idParam)
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Router /v1/users/{id} [delete]
+func (h handler) DeleteUser(c *gin.Context) error {
+	id, err := parseId(c, "id")
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Message: fmt.Sprintf("Id [%s] is not a valid UUID", idParam), Detail: err.Error()})
-		return
+		return err
 	}
-	err = h.users.DeleteUser(id)
-	if err != nil {
-		if errors.Is(err, models.ErrModelNotFound) {
-			c.AbortWithStatusJSON(http.StatusNotFound, ApiError{Message: fmt.Sprintf("No user exists with Id [%s]", idParam), Detail: err.Error()})
-			return
-		} else {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, ApiError{Message: fmt.Sprintf("Error deleting user record with Id [%s]", id), Detail: err.Error()})
-			return
-		}
+
+	if err := h.deleteUser(c.Request.Context(), id); err != nil {
+		return err
 	}
 	c.Status(http.StatusNoContent)
+	return nil
+}
+
+// deleteUser removes a user and their addresses, shared by DeleteUser and batch operations
+func (h handler) deleteUser(ctx context.Context, id uuid.UUID) error {
+	if err := h.users.DeleteUser(ctx, id); err != nil {
+		return repositoryError(err, "user", id, fmt.Sprintf("Error deleting user record with Id [%s]", id))
+	}
+	return nil
 }
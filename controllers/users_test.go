@@ -2,11 +2,14 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -15,21 +18,22 @@ import (
 )
 
 type mockUserRepository struct {
-	users []models.User
-	err   error
+	users   []models.User
+	err     error
+	batches int
 }
 
-func (m *mockUserRepository) SelectAllUsers() ([]models.User, error) {
+func (m *mockUserRepository) SelectAllUsers(ctx context.Context) ([]models.User, error) {
 	return m.users, m.err
 }
-func (m *mockUserRepository) SelectOneUser(id uuid.UUID) (models.User, error) {
+func (m *mockUserRepository) SelectOneUser(ctx context.Context, id uuid.UUID) (models.User, error) {
 	if len(m.users) > 0 {
 		return m.users[0], m.err
 	} else {
 		return models.User{}, m.err
 	}
 }
-func (m *mockUserRepository) InsertUser(usr models.User) (models.User, error) {
+func (m *mockUserRepository) InsertUser(ctx context.Context, usr models.User) (models.User, error) {
 	if usr.Id == uuid.Nil {
 		log.Fatalln("UUID value for new user was nil")
 	}
@@ -41,23 +45,36 @@ func (m *mockUserRepository) InsertUser(usr models.User) (models.User, error) {
 		return models.User{}, m.err
 	}
 }
-func (m *mockUserRepository) UpdateUser(usr models.User) (models.User, error) {
+func (m *mockUserRepository) UpdateUser(ctx context.Context, usr models.User) (models.User, error) {
 	if len(m.users) > 0 {
 		return usr, m.err
 	} else {
 		return models.User{}, m.err
 	}
 }
-func (m *mockUserRepository) DeleteUser(id uuid.UUID) error {
+func (m *mockUserRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
 	return m.err
 }
+func (m *mockUserRepository) SelectUsersByIds(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+	m.batches++
+	if m.err != nil {
+		return nil, m.err
+	}
+	users := make([]models.User, 0)
+	for _, usr := range m.users {
+		if slices.Contains(ids, usr.Id) {
+			users = append(users, usr)
+		}
+	}
+	return users, nil
+}
 
 func TestFetchUsersRoute(t *testing.T) {
 	type test struct {
 		mockResult  mockUserRepository
 		wantedCode  int
 		wantedBody  []models.User
-		wantedError ApiError
+		wantedError Problem
 	}
 
 	tests := []test{
@@ -82,13 +99,14 @@ func TestFetchUsersRoute(t *testing.T) {
 		{
 			mockResult:  mockUserRepository{users: nil, err: errors.New("Kaboom!")},
 			wantedCode:  500,
-			wantedError: ApiError{Message: "Error fetching user records", Detail: "Kaboom!"},
+			wantedError: wantProblem(ErrCodeInternal, "Error fetching user records"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, &testCase.mockResult, nil)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &testCase.mockResult, nil, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/users/", nil)
@@ -104,9 +122,8 @@ func TestFetchUsersRoute(t *testing.T) {
 			//compare expected slice w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedError)
 		}
 	}
@@ -118,7 +135,7 @@ func TestFetchUserRoute(t *testing.T) {
 		mockResult  mockUserRepository
 		wantedCode  int
 		wantedBody  models.User
-		wantedError ApiError
+		wantedError Problem
 	}
 
 	tests := []test{
@@ -132,25 +149,26 @@ func TestFetchUserRoute(t *testing.T) {
 			userId:      "493adb28-9da1-4db8-893d-73cc2d7bd4ee",
 			mockResult:  mockUserRepository{users: []models.User{}, err: models.ErrModelNotFound},
 			wantedCode:  404,
-			wantedError: ApiError{Message: "No user exists with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]", Detail: "resource not found"},
+			wantedError: wantProblem(ErrCodeUserNotFound, "No user exists with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]"),
 		},
 		{
 			userId:      "bob",
 			mockResult:  mockUserRepository{users: []models.User{}, err: nil},
 			wantedCode:  400,
-			wantedError: ApiError{Message: "Id [bob] is not a valid UUID", Detail: "invalid UUID length: 3"},
+			wantedError: wantProblem(ErrCodeInvalidId, "Id [bob] is not a valid UUID: invalid UUID length: 3"),
 		},
 		{
 			userId:      "493adb28-9da1-4db8-893d-73cc2d7bd4ee",
 			mockResult:  mockUserRepository{users: []models.User{}, err: errors.New("Kaboom!")},
 			wantedCode:  500,
-			wantedError: ApiError{Message: "Error fetching user record with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]", Detail: "Kaboom!"},
+			wantedError: wantProblem(ErrCodeInternal, "Error fetching user record with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, &testCase.mockResult, nil)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &testCase.mockResult, nil, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/users/"+testCase.userId, nil)
@@ -166,9 +184,8 @@ func TestFetchUserRoute(t *testing.T) {
 			//compare expected User w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedError)
 		}
 	}
@@ -180,7 +197,7 @@ func TestAddUserRoute(t *testing.T) {
 		mockResult  mockUserRepository
 		wantedCode  int
 		wantedBody  models.User
-		wantedError ApiError
+		wantedError Problem
 	}
 
 	tests := []test{
@@ -194,19 +211,52 @@ func TestAddUserRoute(t *testing.T) {
 			mockResult:  mockUserRepository{users: []models.User{{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee")}}},
 			requestBody: `{"lastName":42}`,
 			wantedCode:  400,
-			wantedError: ApiError{Message: "Invalid request body.", Detail: "json: cannot unmarshal number into Go struct field addUpdateUserBody.lastName of type string"},
+			wantedError: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation", FieldError{Field: "lastName", Code: "type", Message: "must be a string"}),
 		},
 		{
 			requestBody: `{"firstName":"New", "lastName":"Guy"}`,
 			mockResult:  mockUserRepository{users: []models.User{}, err: errors.New("Kaboom!")},
 			wantedCode:  500,
-			wantedError: ApiError{Message: "Error creating new user", Detail: "Kaboom!"},
+			wantedError: wantProblem(ErrCodeInternal, "Error creating new user"),
+		},
+		{
+			//surrounding whitespace is trimmed before validating and storing
+			mockResult:  mockUserRepository{users: []models.User{{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee")}}},
+			requestBody: `{"firstName":"  Mary-Jo ", "lastName":"\tO'Brien"}`,
+			wantedCode:  201,
+			wantedBody:  models.User{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Mary-Jo", LastName: "O'Brien"},
+		},
+		{
+			//every violation is reported, not just the first
+			requestBody: `{"firstName":"   ", "lastName":"R2D2"}`,
+			wantedCode:  400,
+			wantedError: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "firstName", Code: "required", Message: "is required"},
+				FieldError{Field: "lastName", Code: "personname", Message: "may only contain letters, spaces, apostrophes, hyphens and periods, and must start with a letter"}),
+		},
+		{
+			requestBody: `{"firstName":"` + strings.Repeat("a", 256) + `", "lastName":"User"}`,
+			wantedCode:  400,
+			wantedError: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "firstName", Code: "max", Message: "must be at most 255 characters"}),
+		},
+		{
+			requestBody: `{"firstName":"New", "lastName":"User", "middleName":"Q"}`,
+			wantedCode:  400,
+			wantedError: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation",
+				FieldError{Field: "middleName", Code: "unknown", Message: "is not a recognized field"}),
+		},
+		{
+			requestBody: ``,
+			wantedCode:  400,
+			wantedError: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed: request body is empty"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, &testCase.mockResult, nil)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &testCase.mockResult, nil, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/users/", bytes.NewBuffer([]byte(testCase.requestBody)))
@@ -223,9 +273,8 @@ func TestAddUserRoute(t *testing.T) {
 			//compare expected User w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedError)
 		}
 	}
@@ -238,7 +287,7 @@ func TestUpdateUserRoute(t *testing.T) {
 		mockResult  mockUserRepository
 		wantedCode  int
 		wantedBody  models.User
-		wantedError ApiError
+		wantedError Problem
 	}
 
 	tests := []test{
@@ -254,34 +303,35 @@ func TestUpdateUserRoute(t *testing.T) {
 			mockResult:  mockUserRepository{users: []models.User{}},
 			requestBody: `{"lastName":42}`,
 			wantedCode:  400,
-			wantedError: ApiError{Message: "Invalid request body.", Detail: "json: cannot unmarshal number into Go struct field addUpdateUserBody.lastName of type string"},
+			wantedError: wantProblem(ErrCodeInvalidRequestBody, "Request body is malformed or failed validation", FieldError{Field: "lastName", Code: "type", Message: "must be a string"}),
 		},
 		{
 			userId:      "493adb28-9da1-4db8-893d-73cc2d7bd4ee",
 			requestBody: `{"firstName":"Updated", "lastName":"Name"}`,
 			mockResult:  mockUserRepository{users: []models.User{}, err: models.ErrModelNotFound},
 			wantedCode:  404,
-			wantedError: ApiError{Message: "No user exists with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]", Detail: "resource not found"},
+			wantedError: wantProblem(ErrCodeUserNotFound, "No user exists with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]"),
 		},
 		{
 			userId:      "bob",
 			requestBody: `{"firstName":"Updated", "lastName":"Name"}`,
 			mockResult:  mockUserRepository{users: []models.User{}, err: nil},
 			wantedCode:  400,
-			wantedError: ApiError{Message: "Id [bob] is not a valid UUID", Detail: "invalid UUID length: 3"},
+			wantedError: wantProblem(ErrCodeInvalidId, "Id [bob] is not a valid UUID: invalid UUID length: 3"),
 		},
 		{
 			userId:      "493adb28-9da1-4db8-893d-73cc2d7bd4ee",
 			requestBody: `{"firstName":"Updated", "lastName":"Name"}`,
 			mockResult:  mockUserRepository{users: []models.User{}, err: errors.New("Kaboom!")},
 			wantedCode:  500,
-			wantedError: ApiError{Message: "Error updating user record with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]", Detail: "Kaboom!"},
+			wantedError: wantProblem(ErrCodeInternal, "Error updating user record with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, &testCase.mockResult, nil)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &testCase.mockResult, nil, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("PUT", "/users/"+testCase.userId, bytes.NewBuffer([]byte(testCase.requestBody)))
@@ -298,9 +348,8 @@ func TestUpdateUserRoute(t *testing.T) {
 			//compare expected User w/ unmarshaled response
 			assert.Equal(t, parsedResp, testCase.wantedBody)
 		} else {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedError)
 		}
 	}
@@ -311,7 +360,7 @@ func TestDeleteUserRoute(t *testing.T) {
 		userId      string
 		mockResult  mockUserRepository
 		wantedCode  int
-		wantedError ApiError
+		wantedError Problem
 	}
 
 	tests := []test{
@@ -324,25 +373,26 @@ func TestDeleteUserRoute(t *testing.T) {
 			userId:      "493adb28-9da1-4db8-893d-73cc2d7bd4ee",
 			mockResult:  mockUserRepository{err: models.ErrModelNotFound},
 			wantedCode:  404,
-			wantedError: ApiError{Message: "No user exists with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]", Detail: "resource not found"},
+			wantedError: wantProblem(ErrCodeUserNotFound, "No user exists with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]"),
 		},
 		{
 			userId:      "bob",
 			mockResult:  mockUserRepository{users: []models.User{}, err: nil},
 			wantedCode:  400,
-			wantedError: ApiError{Message: "Id [bob] is not a valid UUID", Detail: "invalid UUID length: 3"},
+			wantedError: wantProblem(ErrCodeInvalidId, "Id [bob] is not a valid UUID: invalid UUID length: 3"),
 		},
 		{
 			userId:      "493adb28-9da1-4db8-893d-73cc2d7bd4ee",
 			mockResult:  mockUserRepository{users: []models.User{}, err: errors.New("Kaboom!")},
 			wantedCode:  500,
-			wantedError: ApiError{Message: "Error deleting user record with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]", Detail: "Kaboom!"},
+			wantedError: wantProblem(ErrCodeInternal, "Error deleting user record with Id [493adb28-9da1-4db8-893d-73cc2d7bd4ee]"),
 		},
 	}
 
 	for _, testCase := range tests {
-		router := SetupRouter()
-		RegisterRoutes(router, &testCase.mockResult, nil)
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &testCase.mockResult, nil, RouteVersions{})
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("DELETE", "/users/"+testCase.userId, nil)
@@ -352,9 +402,8 @@ func TestDeleteUserRoute(t *testing.T) {
 		assert.Equal(t, w.Code, testCase.wantedCode)
 
 		if testCase.wantedCode != 204 {
-			//Unmarshal json resp into ApiError response
-			parsedResp := ApiError{}
-			json.Unmarshal(w.Body.Bytes(), &parsedResp)
+			//Unmarshal json resp into Problem response
+			parsedResp := parseProblem(t, w)
 			assert.Equal(t, parsedResp, testCase.wantedError)
 		}
 	}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// FetchUsersV2 retrieves a page of users
+// @Summary retrieve a page of users
+// @Tags v2 users
+// @ID fetch-all-users-v2
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param limit query int false "users per page, at most 100" default(50)
+// @Param offset query int false "users to skip" default(0)
+// @Param fields query string false "comma separated fields to return, e.g. id,lastName"
+// @Param include query string false "related records to embed in each user" Enums(addresses)
+// @Success 200 {object} pageV2{data=[]userV2}
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v2/users [get]
+func (h handler) FetchUsersV2(c *gin.Context) error {
+	include, err := parseInclude(c, "addresses")
+	if err != nil {
+		return err
+	}
+	users, err := h.users.SelectAllUsers(c.Request.Context())
+	if err != nil {
+		return &InternalError{Detail: "Error fetching user records", Err: err}
+	}
+	page, start, end, err := cutPage(c, len(users))
+	if err != nil {
+		return err
+	}
+
+	var items any = toUsersV2(users[start:end])
+	if include["addresses"] {
+		if items, err = h.withAddressesV2(c.Request.Context(), users[start:end]); err != nil {
+			return err
+		}
+	}
+	return respondPage(c, page, items, "links", "addresses")
+}
+
+// FetchUserV2 retrieves a single user by id
+// @Summary retrieve a user by Id
+// @Tags v2 users
+// @ID fetch-user-v2
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "user ID"
+// @Param fields query string false "comma separated fields to return, e.g. id,lastName"
+// @Param include query string false "related records to embed in the user" Enums(addresses)
+// @Success 200 {object} userV2
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v2/users/{id} [get]
+func (h handler) FetchUserV2(c *gin.Context) error {
+	id, err := parseId(c, "id")
+	if err != nil {
+		return err
+	}
+	include, err := parseInclude(c, "addresses")
+	if err != nil {
+		return err
+	}
+	user, err := h.users.SelectOneUser(c.Request.Context(), id)
+	if err != nil {
+		return repositoryError(err, "user", id, fmt.Sprintf("Error fetching user record with Id [%s]", id))
+	}
+
+	var data any = toUserV2(user)
+	if include["addresses"] {
+		withAddresses, err := h.withAddressesV2(c.Request.Context(), []models.User{user})
+		if err != nil {
+			return err
+		}
+		data = withAddresses[0]
+	}
+	if data, err = selectFields(c, data, "links", "addresses"); err != nil {
+		return err
+	}
+	return respond(c, http.StatusOK, data)
+}
+
+// withAddressesV2 embeds each user's addresses as withAddresses does, in the /v2 representation
+func (h handler) withAddressesV2(ctx context.Context, users []models.User) ([]userWithAddressesV2, error) {
+	withAddresses, err := h.withAddresses(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]userWithAddressesV2, len(withAddresses))
+	for i, user := range withAddresses {
+		result[i] = userWithAddressesV2{userV2: toUserV2(user.User), Addresses: toAddressesV2(user.Addresses)}
+	}
+	return result, nil
+}
+
+// AddUserV2 stores a new user
+// @Summary add a new user
+// @Tags v2 users
+// @ID add-user-v2
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param data body addUpdateUserBody true "new user data"
+// @Param Idempotency-Key header string false "makes retries of this request safe, see the README"
+// @Success 201 {object} userV2
+// @Header 201 {string} Location "path of the new user"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 415 {object} Problem
+// @Failure 422 {object} Problem
+// @Router /v2/users [post]
+func (h handler) AddUserV2(c *gin.Context) error {
+	var reqBody addUpdateUserBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
+	}
+	newUser, err := h.createUser(c.Request.Context(), reqBody)
+	if err != nil {
+		return err
+	}
+	data := toUserV2(newUser)
+	c.Header("Location", data.Links.Self)
+	return respond(c, http.StatusCreated, data)
+}
+
+// UpdateUserV2 modifies an existing user
+// @Summary modify an existing user
+// @Tags v2 users
+// @ID update-user-v2
+// @Accept json,xml,application/yaml,application/msgpack
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "user ID"
+// @Param data body addUpdateUserBody true "new user data"
+// @Success 200 {object} userV2
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 406 {object} Problem
+// @Failure 415 {object} Problem
+// @Router /v2/users/{id} [put]
+func (h handler) UpdateUserV2(c *gin.Context) error {
+	id, err := parseId(c, "id")
+	if err != nil {
+		return err
+	}
+	var reqBody addUpdateUserBody
+	if err := bindBody(c, &reqBody); err != nil {
+		return err
+	}
+	updatedUser, err := h.updateUser(c.Request.Context(), id, reqBody)
+	if err != nil {
+		return err
+	}
+	return respond(c, http.StatusOK, toUserV2(updatedUser))
+}
+
+// DeleteUserV2 deletes an existing user, including any addresses associated with the user. It answers as /v1 does
+// @Summary delete a user by Id, including any addresses associated with the user
+// @Tags v2 users
+// @ID delete-user-v2
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "user ID"
+// @Success 204
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Router /v2/users/{id} [delete]
+func (h handler) DeleteUserV2(c *gin.Context) error {
+	return h.DeleteUser(c)
+}
+
+// FetchAddressesForUserV2 retrieves a page of the addresses associated with a user
+// @Summary retrieve a page of addresses by the user's Id
+// @Tags v2 users, v2 addresses
+// @ID fetch-addrs-for-user-v2
+// @Produce json,xml,application/yaml,application/msgpack
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param id path string true "user ID"
+// @Param limit query int false "addresses per page, at most 100" default(50)
+// @Param offset query int false "addresses to skip" default(0)
+// @Param fields query string false "comma separated fields to return, e.g. id,city"
+// @Success 200 {object} pageV2{data=[]addressV2}
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 406 {object} Problem
+// @Router /v2/users/{id}/addresses [get]
+func (h handler) FetchAddressesForUserV2(c *gin.Context) error {
+	userId, err := parseId(c, "id")
+	if err != nil {
+		return err
+	}
+	addrs, err := h.userAddresses(c.Request.Context(), userId)
+	if err != nil {
+		return err
+	}
+	page, start, end, err := cutPage(c, len(addrs))
+	if err != nil {
+		return err
+	}
+	return respondPage(c, page, toAddressesV2(addrs[start:end]), "links")
+}
+
+func toUsersV2(users []models.User) []userV2 {
+	result := make([]userV2, len(users))
+	for i, user := range users {
+		result[i] = toUserV2(user)
+	}
+	return result
+}
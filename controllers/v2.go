@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+)
+
+// v2Prefix is where the second version of the user and address routes is served
+const v2Prefix = "/v2"
+
+// userLinksV2 points at a user and the records related to it
+type userLinksV2 struct {
+	Self      string `json:"self" xml:"self"`
+	Addresses string `json:"addresses" xml:"addresses"`
+}
+
+// userV2 is how /v2 represents a user
+type userV2 struct {
+	XMLName   xml.Name    `json:"-" xml:"user"`
+	Id        uuid.UUID   `json:"id" xml:"id"`
+	FirstName string      `json:"firstName" xml:"firstName"`
+	LastName  string      `json:"lastName" xml:"lastName"`
+	Links     userLinksV2 `json:"links" xml:"links"`
+}
+
+// userWithAddressesV2 is a /v2 user with its addresses embedded, returned for ?include=addresses
+type userWithAddressesV2 struct {
+	XMLName xml.Name `json:"-" xml:"user"`
+	userV2
+	Addresses []addressV2 `json:"addresses" xml:"addresses>address"`
+}
+
+// addressLinksV2 points at an address and the user it belongs to
+type addressLinksV2 struct {
+	Self string `json:"self" xml:"self"`
+	User string `json:"user" xml:"user"`
+}
+
+// addressV2 is how /v2 represents an address
+type addressV2 struct {
+	XMLName xml.Name       `json:"-" xml:"address"`
+	Id      uuid.UUID      `json:"id" xml:"id"`
+	UserId  uuid.UUID      `json:"userId" xml:"userId"`
+	Street  string         `json:"street" xml:"street"`
+	City    string         `json:"city" xml:"city"`
+	State   string         `json:"state" xml:"state"`
+	Zip     string         `json:"zip" xml:"zip"`
+	Type    string         `json:"type" xml:"type"`
+	Links   addressLinksV2 `json:"links" xml:"links"`
+}
+
+// paginationV2 describes the page of a list a /v2 response holds
+type paginationV2 struct {
+	Limit  int `json:"limit" xml:"limit"`
+	Offset int `json:"offset" xml:"offset"`
+	//Total is the number of records in the whole list
+	Total int `json:"total" xml:"total"`
+}
+
+// pageLinksV2 points at a page of a list and the pages either side of it, when there are any
+type pageLinksV2 struct {
+	Self string `json:"self" xml:"self"`
+	Next string `json:"next,omitempty" xml:"next,omitempty"`
+	Prev string `json:"prev,omitempty" xml:"prev,omitempty"`
+}
+
+// pageV2 is the envelope /v2 returns lists in
+type pageV2 struct {
+	XMLName    xml.Name     `json:"-" xml:"page"`
+	Data       any          `json:"data" xml:"data>item"`
+	Pagination paginationV2 `json:"pagination" xml:"pagination"`
+	Links      pageLinksV2  `json:"links" xml:"links"`
+}
+
+func userLinks(id uuid.UUID) userLinksV2 {
+	self := v2Prefix + "/users/" + id.String()
+	return userLinksV2{Self: self, Addresses: self + "/addresses"}
+}
+
+func toUserV2(user models.User) userV2 {
+	return userV2{Id: user.Id, FirstName: user.FirstName, LastName: user.LastName, Links: userLinks(user.Id)}
+}
+
+func toAddressV2(addr models.Address) addressV2 {
+	return addressV2{
+		Id:     addr.Id,
+		UserId: addr.UserId,
+		Street: addr.Street,
+		City:   addr.City,
+		State:  addr.State,
+		Zip:    addr.Zip,
+		Type:   addr.Type,
+		Links:  addressLinksV2{Self: v2Prefix + "/addresses/" + addr.Id.String(), User: userLinks(addr.UserId).Self},
+	}
+}
+
+func toAddressesV2(addrs []models.Address) []addressV2 {
+	result := make([]addressV2, len(addrs))
+	for i, addr := range addrs {
+		result[i] = toAddressV2(addr)
+	}
+	return result
+}
+
+// cutPage picks the page of a list of total records that the request's ?limit= and ?offset= select, returning its
+// envelope and the range of the list it holds. The page's links keep the request's other query parameters
+func cutPage(c *gin.Context, total int) (page pageV2, start int, end int, err error) {
+	limit, offset, err := parsePage(c)
+	if err != nil {
+		return pageV2{}, 0, 0, err
+	}
+	if start, end, err = pageBounds(limit, offset, total); err != nil {
+		return pageV2{}, 0, 0, err
+	}
+
+	page = pageV2{
+		Pagination: paginationV2{Limit: limit, Offset: offset, Total: total},
+		Links:      pageLinksV2{Self: pageLink(c, limit, offset)},
+	}
+	if end < total {
+		page.Links.Next = pageLink(c, limit, end)
+	}
+	if offset > 0 {
+		page.Links.Prev = pageLink(c, limit, max(offset-limit, 0))
+	}
+	return page, start, end, nil
+}
+
+// pageLink is the request's URL moved to the page of limit records starting at offset
+func pageLink(c *gin.Context, limit int, offset int) string {
+	query := c.Request.URL.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(offset))
+	return c.Request.URL.Path + "?" + query.Encode()
+}
+
+// respondPage writes a page of items, trimmed to ?fields= but keeping keep, in the pageV2 envelope
+func respondPage(c *gin.Context, page pageV2, items any, keep ...string) error {
+	data, err := selectFields(c, items, keep...)
+	if err != nil {
+		return err
+	}
+	page.Data = data
+	return respond(c, http.StatusOK, page)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+func TestV2Routes(t *testing.T) {
+	pat := models.User{Id: uuid.MustParse("493adb28-9da1-4db8-893d-73cc2d7bd4ee"), FirstName: "Pat", LastName: "Smith"}
+	sam := models.User{Id: uuid.MustParse("a3b2c1d0-0000-4000-8000-000000000001"), FirstName: "Sam", LastName: "Jones"}
+	kim := models.User{Id: uuid.MustParse("a3b2c1d0-0000-4000-8000-000000000002"), FirstName: "Kim", LastName: "Lee"}
+	home := models.Address{Id: uuid.MustParse("4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11"), UserId: pat.Id, Street: "1 Main St", City: "Boise", State: "ID", Zip: "83702", Type: "HOME"}
+
+	type test struct {
+		method         string
+		path           string
+		body           string
+		accept         string
+		wantedCode     int
+		wantedLocation string
+		wantedBody     string
+		wantedProblem  Problem
+	}
+
+	tests := []test{
+		{method: "GET", path: "/v2/users/?pretty=false&limit=1&offset=1", wantedCode: 200,
+			wantedBody: `{"data":[{"id":"a3b2c1d0-0000-4000-8000-000000000001","firstName":"Sam","lastName":"Jones","links":{"self":"/v2/users/a3b2c1d0-0000-4000-8000-000000000001","addresses":"/v2/users/a3b2c1d0-0000-4000-8000-000000000001/addresses"}}],` +
+				`"pagination":{"limit":1,"offset":1,"total":3},"links":{"self":"/v2/users/?limit=1\u0026offset=1\u0026pretty=false","next":"/v2/users/?limit=1\u0026offset=2\u0026pretty=false","prev":"/v2/users/?limit=1\u0026offset=0\u0026pretty=false"}}`},
+		//links are kept when fields are picked, so clients can still follow them
+		{method: "GET", path: "/v2/users/?pretty=false&fields=lastName&include=addresses&limit=1", wantedCode: 200,
+			wantedBody: `{"data":[{"lastName":"Smith","links":{"self":"/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee","addresses":"/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee/addresses"},` +
+				`"addresses":[{"id":"4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11","userId":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","street":"1 Main St","city":"Boise","state":"ID","zip":"83702","type":"HOME","links":{"self":"/v2/addresses/4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11","user":"/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee"}}]}],` +
+				`"pagination":{"limit":1,"offset":0,"total":3},"links":{"self":"/v2/users/?fields=lastName\u0026include=addresses\u0026limit=1\u0026offset=0\u0026pretty=false","next":"/v2/users/?fields=lastName\u0026include=addresses\u0026limit=1\u0026offset=1\u0026pretty=false"}}`},
+		{method: "GET", path: "/v2/addresses/?fields=city", accept: "application/xml", wantedCode: 200,
+			wantedBody: `<page><data><address><city>Boise</city><links><self>/v2/addresses/4c7cc4a6-0f1d-4a8b-9b7f-2f5d0f0a9a11</self><user>/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee</user></links></address></data>` +
+				`<pagination><limit>50</limit><offset>0</offset><total>1</total></pagination><links><self>/v2/addresses/?fields=city&amp;limit=50&amp;offset=0</self></links></page>`},
+		{method: "GET", path: "/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee?pretty=false&fields=firstName", wantedCode: 200,
+			wantedBody: `{"firstName":"Pat","links":{"self":"/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee","addresses":"/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee/addresses"}}`},
+		{method: "POST", path: "/v2/users/?pretty=false", body: `{"firstName":"Pat","lastName":"Smith"}`, wantedCode: 201,
+			wantedLocation: "/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee",
+			wantedBody:     `{"id":"493adb28-9da1-4db8-893d-73cc2d7bd4ee","firstName":"Pat","lastName":"Smith","links":{"self":"/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee","addresses":"/v2/users/493adb28-9da1-4db8-893d-73cc2d7bd4ee/addresses"}}`},
+		{method: "GET", path: "/v2/users/?limit=0", wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidQuery, "limit must be between 1 and 100")},
+		{method: "GET", path: "/v2/users/?offset=first", wantedCode: 400,
+			wantedProblem: wantProblem(ErrCodeInvalidQuery, "offset must be a whole number, got [first]")},
+		//pages are enveloped so they cannot be written as CSV
+		{method: "GET", path: "/v2/addresses/", accept: "text/csv", wantedCode: 406,
+			wantedProblem: wantProblem(ErrCodeNotAcceptable, "None of the media types in the Accept header can be produced. Supported types are application/json, application/xml, application/yaml, application/msgpack and, for lists, text/csv")},
+	}
+
+	for _, testCase := range tests {
+		users := &mockUserRepository{users: []models.User{pat, sam, kim}}
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, users, &mockAddressRepository{addrs: []models.Address{home}}, RouteVersions{})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(testCase.method, testCase.path, bytes.NewBufferString(testCase.body))
+		req.Header.Set("Accept", testCase.accept)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, testCase.wantedCode)
+		if testCase.wantedCode >= 400 {
+			assert.Equal(t, parseProblem(t, w), testCase.wantedProblem)
+			continue
+		}
+		assert.Equal(t, w.Header().Get("Location"), testCase.wantedLocation)
+		assert.Equal(t, w.Body.String(), testCase.wantedBody)
+	}
+}
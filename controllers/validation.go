@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	personNamePattern  = regexp.MustCompile(`^[\p{L}\p{M}][\p{L}\p{M} .'-]*$`)
+	addressLinePattern = regexp.MustCompile(`^[\p{L}\p{M}\p{N} .,'#/&()-]+$`)
+	postalCodePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,8}[A-Za-z0-9]$`)
+	batchRefPattern    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// customValidators are the rules request bodies can use in binding tags on top of the validator built-ins
+var customValidators = map[string]validator.Func{
+	"personname":  matches(personNamePattern),
+	"addressline": matches(addressLinePattern),
+	"postalcode":  matches(postalCodePattern),
+	"batchref":    matches(batchRefPattern),
+}
+
+var registerValidatorsOnce sync.Once
+
+// errEmptyBody is reported when a request that requires a body was sent without one
+var errEmptyBody = errors.New("request body is empty")
+
+// unknownFieldError is reported when a request body holds a field the endpoint does not accept
+type unknownFieldError struct {
+	field string
+}
+
+func (e *unknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field [%s]", e.field)
+}
+
+// fieldRuleError is reported when a field breaks a rule that binding tags cannot express
+type fieldRuleError struct {
+	field   string
+	code    string
+	message string
+}
+
+func (e *fieldRuleError) Error() string {
+	return fmt.Sprintf("%s %s", e.field, e.message)
+}
+
+func matches(pattern *regexp.Regexp) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return pattern.MatchString(fl.Field().String())
+	}
+}
+
+// registerValidators adds the custom rules to gin's validator and makes it report fields by their JSON names
+func registerValidators() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		panic("gin binding validator is not go-playground/validator")
+	}
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	for tag, fn := range customValidators {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// bindBody decodes the request body into obj according to its Content-Type, JSON when none is given, trims
+// surrounding whitespace from every string field, then checks the binding rules so all violations are reported
+// together. XML is decoded by the xml tags on obj; YAML and MessagePack are converted to JSON first so field names
+// and unknown field checks match JSON bodies exactly
+func bindBody(c *gin.Context, obj any) error {
+	if c.Request.Body == nil {
+		return &ValidationError{Err: errEmptyBody}
+	}
+	var err error
+	switch mediaType := strings.ToLower(c.ContentType()); {
+	case mediaType == "" || slices.Contains(jsonMediaTypes, mediaType):
+		err = decodeJSON(c.Request.Body, obj)
+	case slices.Contains(xmlMediaTypes, mediaType):
+		if err = xml.NewDecoder(c.Request.Body).Decode(obj); errors.Is(err, io.EOF) {
+			err = errEmptyBody
+		}
+	case slices.Contains(yamlMediaTypes, mediaType):
+		err = decodeConverted(c.Request.Body, obj, func(r io.Reader, value *any) error {
+			return yaml.NewDecoder(r).Decode(value)
+		})
+	case slices.Contains(msgpackMediaTypes, mediaType):
+		err = decodeConverted(c.Request.Body, obj, func(r io.Reader, value *any) error {
+			var handle codec.MsgpackHandle
+			handle.RawToString = true
+			handle.MapType = reflect.TypeOf(map[string]any(nil))
+			return codec.NewDecoder(r, &handle).Decode(value)
+		})
+	default:
+		return &UnsupportedMediaTypeError{ContentType: mediaType}
+	}
+	if err != nil {
+		return &ValidationError{Err: err}
+	}
+	return validateBody(obj)
+}
+
+// validateBody trims surrounding whitespace from every string field of a decoded body, then checks its binding rules
+func validateBody(obj any) error {
+	registerValidatorsOnce.Do(registerValidators)
+
+	trimStrings(obj)
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		return &ValidationError{Err: err}
+	}
+	return nil
+}
+
+// decodeJSON decodes a JSON body, rejecting fields obj does not have
+func decodeJSON(body io.Reader, obj any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(obj)
+	if errors.Is(err, io.EOF) {
+		return errEmptyBody
+	}
+	if field, ok := strings.CutPrefix(fmt.Sprint(err), "json: unknown field "); ok {
+		return &unknownFieldError{field: strings.Trim(field, `"`)}
+	}
+	return err
+}
+
+// decodeConverted decodes a body with decode and re-encodes the result as JSON for decodeJSON
+func decodeConverted(body io.Reader, obj any, decode func(r io.Reader, value *any) error) error {
+	var value any
+	if err := decode(body, &value); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errEmptyBody
+		}
+		return err
+	}
+	if value == nil {
+		return errEmptyBody
+	}
+	doc, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(bytes.NewReader(doc), obj)
+}
+
+// trimStrings strips leading and trailing whitespace from the string fields of the struct obj points to
+func trimStrings(obj any) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); field.Kind() == reflect.String && field.CanSet() {
+			field.SetString(strings.TrimSpace(field.String()))
+		}
+	}
+}
+
+// validationMessage describes a failed rule in terms a client can act on
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required", "required_unless":
+		return "is required"
+	case "max":
+		return fmt.Sprintf("must be at most %s characters", fieldErr.Param())
+	case "min":
+		return fmt.Sprintf("must be at least %s characters", fieldErr.Param())
+	case "gt":
+		if fieldErr.Param() == "" {
+			return "must be in the future"
+		}
+		return fmt.Sprintf("must be greater than %s", fieldErr.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", strings.ReplaceAll(fieldErr.Param(), " ", ", "))
+	case "personname":
+		return "may only contain letters, spaces, apostrophes, hyphens and periods, and must start with a letter"
+	case "addressline":
+		return "may only contain letters, digits, spaces and the punctuation . , ' # / & ( ) -"
+	case "postalcode":
+		return "must be 3 to 10 letters, digits, spaces or hyphens"
+	case "batchref":
+		return "may only contain letters, digits, underscores and hyphens"
+	default:
+		return fmt.Sprintf("failed the %s validation", fieldErr.Tag())
+	}
+}
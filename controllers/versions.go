@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecation is when a version of the routes stopped being recommended and when it is due to be removed. Either
+// may be zero, leaving its header out
+type Deprecation struct {
+	At     time.Time
+	Sunset time.Time
+}
+
+// RouteVersions says which versions of the user and address routes are deprecated
+type RouteVersions struct {
+	//Unversioned is for /users and /addresses, kept as aliases of /v1 for clients written before versioning
+	Unversioned Deprecation
+	V1          Deprecation
+}
+
+// deprecated announces d on every response from routes under prefix with the Deprecation (RFC 9745) and Sunset
+// (RFC 8594) headers, linking to the same path under successor
+func deprecated(d Deprecation, prefix string, successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d.At.IsZero() && d.Sunset.IsZero() {
+			c.Next()
+			return
+		}
+		if !d.At.IsZero() {
+			c.Header("Deprecation", fmt.Sprintf("@%d", d.At.Unix()))
+		}
+		if !d.Sunset.IsZero() {
+			c.Header("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successor, strings.TrimPrefix(c.Request.URL.Path, prefix)))
+		c.Next()
+	}
+}
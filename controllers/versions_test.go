@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+func TestRouteVersions(t *testing.T) {
+	versions := RouteVersions{
+		Unversioned: Deprecation{At: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), Sunset: time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC)},
+		V1:          Deprecation{At: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	type test struct {
+		path              string
+		wantedDeprecation string
+		wantedSunset      string
+		wantedLink        string
+	}
+
+	tests := []test{
+		{path: "/users/", wantedDeprecation: "@1792368000", wantedSunset: "Thu, 01 Apr 2027 00:00:00 GMT", wantedLink: `</v1/users/>; rel="successor-version"`},
+		{path: "/v1/users/", wantedDeprecation: "@1798761600", wantedLink: `</v2/users/>; rel="successor-version"`},
+		{path: "/v1/addresses/", wantedDeprecation: "@1798761600", wantedLink: `</v2/addresses/>; rel="successor-version"`},
+		{path: "/v2/users/"},
+	}
+
+	for _, testCase := range tests {
+		router := SetupRouter(RouterConfig{})
+		router.Use(AllowAnonymous())
+		RegisterRoutes(router, &mockUserRepository{users: []models.User{}}, &mockAddressRepository{addrs: []models.Address{}}, versions)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", testCase.path, nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Equal(t, w.Header().Get("Deprecation"), testCase.wantedDeprecation)
+		assert.Equal(t, w.Header().Get("Sunset"), testCase.wantedSunset)
+		assert.Equal(t, w.Header().Get("Link"), testCase.wantedLink)
+	}
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lengebretsen/go-practice/conf"
+)
+
+// tlsConfigName is the key the custom TLS settings are registered under with the mysql driver
+const tlsConfigName = "go-practice"
+
+type poolConfig struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+}
+
+// loadPoolConfig reads the connection pool sizing from the database config section
+func loadPoolConfig(cfg conf.DatabaseConfig) (poolConfig, []string) {
+	var problems []string
+	pool := poolConfig{
+		maxOpenConns:    cfg.MaxOpenConns,
+		maxIdleConns:    cfg.MaxIdleConns,
+		connMaxLifetime: cfg.ConnMaxLifetime,
+		connMaxIdleTime: cfg.ConnMaxIdleTime,
+	}
+	if pool.maxOpenConns < 1 {
+		problems = append(problems, fmt.Sprintf("database.maxOpenConns must be at least 1, got %d", pool.maxOpenConns))
+	}
+	if pool.maxIdleConns < 0 || pool.maxIdleConns > pool.maxOpenConns {
+		problems = append(problems, fmt.Sprintf("database.maxIdleConns must be between 0 and database.maxOpenConns (%d), got %d", pool.maxOpenConns, pool.maxIdleConns))
+	}
+	if pool.connMaxLifetime < 0 {
+		problems = append(problems, "database.connMaxLifetime must not be negative")
+	}
+	if pool.connMaxIdleTime < 0 {
+		problems = append(problems, "database.connMaxIdleTime must not be negative")
+	}
+	return pool, problems
+}
+
+// checkConnectConfig checks the settings for reaching the database at startup and watching it afterwards
+func checkConnectConfig(cfg conf.DatabaseConfig) []string {
+	var problems []string
+	if cfg.HealthCheckInterval <= 0 {
+		problems = append(problems, fmt.Sprintf("database.healthCheckInterval must be positive, got %s", cfg.HealthCheckInterval))
+	}
+	if cfg.PingTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("database.pingTimeout must be positive, got %s", cfg.PingTimeout))
+	}
+	if cfg.ConnectAttempts < 1 {
+		problems = append(problems, fmt.Sprintf("database.connectAttempts must be at least 1, got %d", cfg.ConnectAttempts))
+	}
+	if cfg.ConnectBackoff < 0 {
+		problems = append(problems, "database.connectBackoff must not be negative")
+	}
+	if cfg.ConnectMaxBackoff < cfg.ConnectBackoff {
+		problems = append(problems, fmt.Sprintf("database.connectMaxBackoff must be at least database.connectBackoff (%s), got %s", cfg.ConnectBackoff, cfg.ConnectMaxBackoff))
+	}
+	return problems
+}
+
+// loadTLSConfig builds the client TLS settings for talking to MySQL, or returns nil when TLS is disabled
+func loadTLSConfig(cfg conf.DatabaseConfig) (*tls.Config, []string) {
+	if !cfg.TLS.Enabled {
+		return nil, nil
+	}
+
+	var problems []string
+	tlsCfg := &tls.Config{
+		ServerName:         cfg.TLS.ServerName,
+		InsecureSkipVerify: cfg.TLS.SkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	//left empty the driver verifies each server by the host it dialed, so replicas are not checked against the
+	//primary's name
+	if tlsCfg.ServerName == "" && cfg.Socket != "" {
+		tlsCfg.ServerName = cfg.Host
+	}
+
+	if caFile := cfg.TLS.CAFile; caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("database.tls.caFile: %v", err))
+		} else {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				problems = append(problems, fmt.Sprintf("database.tls.caFile: no PEM certificates found in [%s]", caFile))
+			}
+			tlsCfg.RootCAs = pool
+		}
+	}
+
+	certFile, keyFile := cfg.TLS.CertFile, cfg.TLS.KeyFile
+	if (certFile == "") != (keyFile == "") {
+		problems = append(problems, "database.tls.certFile and database.tls.keyFile must be set together")
+	} else if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("database.tls client certificate: %v", err))
+		} else {
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return tlsCfg, problems
+}
+
+// loadMySQLConfig assembles the driver config for the primary from the database config section, connecting over a
+// unix socket when one is configured and over TCP otherwise
+func loadMySQLConfig(dbCfg conf.DatabaseConfig) (*mysql.Config, []string) {
+	var problems []string
+
+	cfg := mysql.NewConfig()
+	cfg.User = dbCfg.User
+	cfg.Passwd = dbCfg.Pass
+	cfg.DBName = dbCfg.Name
+	if dbCfg.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = dbCfg.Socket
+	} else {
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(dbCfg.Host, strconv.Itoa(dbCfg.Port))
+	}
+
+	cfg.Timeout = dbCfg.DialTimeout
+	cfg.ReadTimeout = dbCfg.ReadTimeout
+	cfg.WriteTimeout = dbCfg.WriteTimeout
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{{"dialTimeout", dbCfg.DialTimeout}, {"readTimeout", dbCfg.ReadTimeout}, {"writeTimeout", dbCfg.WriteTimeout}} {
+		if timeout.value < 0 {
+			problems = append(problems, fmt.Sprintf("database.%s must not be negative", timeout.name))
+		}
+	}
+
+	if dbCfg.Charset != "" {
+		cfg.Params = map[string]string{"charset": dbCfg.Charset}
+	}
+	cfg.Collation = dbCfg.Collation
+	cfg.ParseTime = dbCfg.ParseTime
+
+	tlsCfg, tlsProblems := loadTLSConfig(dbCfg)
+	problems = append(problems, tlsProblems...)
+	if tlsCfg != nil && len(tlsProblems) == 0 {
+		if err := mysql.RegisterTLSConfig(tlsConfigName, tlsCfg); err != nil {
+			problems = append(problems, fmt.Sprintf("database.tls: %v", err))
+		}
+		cfg.TLSConfig = tlsConfigName
+	}
+
+	if cfg.User == "" {
+		problems = append(problems, "database.user is required")
+	}
+	if cfg.DBName == "" {
+		problems = append(problems, "database.name is required")
+	}
+	return cfg, problems
+}
+
+// replicaConfig is the driver config for the replica dsn points at: the primary's, with only the network, address,
+// user and password taken from dsn, so replicas get the same timeouts, TLS, charset and parseTime as the primary
+func replicaConfig(primary *mysql.Config, dsn string) (*mysql.Config, error) {
+	replica, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg := primary.Clone()
+	cfg.Net = replica.Net
+	cfg.Addr = replica.Addr
+	cfg.User = replica.User
+	cfg.Passwd = replica.Passwd
+	return cfg, nil
+}
+
+// loadClusterConfig reads everything Init needs from the database config section, collecting every problem found
+func loadClusterConfig(dbCfg conf.DatabaseConfig) (*mysql.Config, poolConfig, []string) {
+	cfg, problems := loadMySQLConfig(dbCfg)
+	pool, poolProblems := loadPoolConfig(dbCfg)
+	problems = append(problems, poolProblems...)
+	problems = append(problems, checkConnectConfig(dbCfg)...)
+	for _, dsn := range dbCfg.Replicas {
+		if _, err := mysql.ParseDSN(dsn); err != nil {
+			problems = append(problems, fmt.Sprintf("database.replicas: invalid dsn for [%s]: %v", mysqlAddr(dsn), err))
+		}
+	}
+	return cfg, pool, problems
+}
+
+// CheckConfig validates the database config section without connecting, returning the same error Init would
+func CheckConfig(cfg conf.DatabaseConfig) error {
+	if _, _, problems := loadClusterConfig(cfg); len(problems) > 0 {
+		return invalidConfig(problems)
+	}
+	return nil
+}
+
+// invalidConfig combines every problem found in the database config into a single error
+func invalidConfig(problems []string) error {
+	return errors.New("invalid database config:\n  - " + strings.Join(problems, "\n  - "))
+}
@@ -1,36 +1,223 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"github.com/spf13/viper"
+	"github.com/lengebretsen/go-practice/conf"
 )
 
-func Init() (*sql.DB, error) {
-	cfg := mysql.Config{
-		User:   viper.GetString("database.user"),
-		Passwd: viper.GetString("database.pass"),
-		Net:    "tcp",
-		Addr:   fmt.Sprintf("%s:%s", viper.GetString("database.host"), viper.GetString("database.port")),
-		DBName: viper.GetString("database.name"),
+type ctxKey int
+
+const primaryReadsKey ctxKey = iota
+
+// WithPrimaryReads returns a copy of ctx that routes all reads to the primary, giving the caller read-your-writes consistency
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey, true)
+}
+
+func primaryReadsRequested(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryReadsKey).(bool)
+	return forced
+}
+
+type replica struct {
+	dsn     string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// Cluster holds the primary connection pool used for writes along with any read replicas
+type Cluster struct {
+	Primary  *sql.DB
+	cfg      conf.DatabaseConfig
+	replicas []*replica
+	next     atomic.Uint32
+	degraded atomic.Bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Reader returns the pool a read-only query should run against. Healthy replicas are picked round-robin, falling
+// back to the primary when none are available or when the context asks for primary reads
+func (c *Cluster) Reader(ctx context.Context) *sql.DB {
+	if ctx == nil || primaryReadsRequested(ctx) || len(c.replicas) == 0 {
+		return c.Primary
+	}
+	start := c.next.Add(1)
+	for i := 0; i < len(c.replicas); i++ {
+		r := c.replicas[(int(start)+i)%len(c.replicas)]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return c.Primary
+}
+
+// Writer returns the pool that statements modifying data must run against
+func (c *Cluster) Writer() *sql.DB {
+	return c.Primary
+}
+
+// Replicas returns the pools for every configured read replica, healthy or not
+func (c *Cluster) Replicas() []*sql.DB {
+	pools := make([]*sql.DB, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		pools = append(pools, r.db)
+	}
+	return pools
+}
+
+// Degraded reports whether the last health check failed to reach the primary
+func (c *Cluster) Degraded() bool {
+	return c.degraded.Load()
+}
+
+// Close stops health checking and closes every pool in the cluster
+func (c *Cluster) Close() error {
+	if c.stop != nil {
+		close(c.stop)
+		c.wg.Wait()
+	}
+	for _, r := range c.replicas {
+		r.db.Close()
+	}
+	return c.Primary.Close()
+}
+
+func (p poolConfig) apply(db *sql.DB) {
+	db.SetConnMaxLifetime(p.connMaxLifetime)
+	db.SetConnMaxIdleTime(p.connMaxIdleTime)
+	db.SetMaxOpenConns(p.maxOpenConns)
+	db.SetMaxIdleConns(p.maxIdleConns)
+}
+
+func ping(db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+func (c *Cluster) checkPrimary() {
+	err := ping(c.Primary, c.cfg.PingTimeout)
+	degraded := err != nil
+	if c.degraded.Swap(degraded) != degraded {
+		if degraded {
+			slog.Error("lost connection to primary database, serving in degraded mode", "host", c.cfg.Host, "error", err)
+		} else {
+			slog.Info("reconnected to primary database", "host", c.cfg.Host)
+		}
+	}
+}
+
+func (c *Cluster) checkReplicas() {
+	for _, r := range c.replicas {
+		err := ping(r.db, c.cfg.PingTimeout)
+		healthy := err == nil
+		if r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				slog.Info("replica is healthy, resuming reads", "replica", mysqlAddr(r.dsn))
+			} else {
+				slog.Warn("replica failed health check, routing reads elsewhere", "replica", mysqlAddr(r.dsn), "error", err)
+			}
+		}
 	}
+}
+
+func (c *Cluster) monitor(interval time.Duration) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkPrimary()
+			c.checkReplicas()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// mysqlAddr pulls the host out of a DSN so credentials never end up in the logs
+func mysqlAddr(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "unparseable dsn"
+	}
+	return cfg.Addr
+}
+
+// connectWithRetry pings the primary until it answers, backing off exponentially between attempts so the app can
+// start before MySQL has finished booting
+func connectWithRetry(db *sql.DB, cfg conf.DatabaseConfig) error {
+	attempts := cfg.ConnectAttempts
+	backoff := cfg.ConnectBackoff
+	maxBackoff := cfg.ConnectMaxBackoff
+
 	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = ping(db, cfg.PingTimeout); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		slog.Warn("database not ready, retrying", "attempt", attempt, "maxAttempts", attempts, "retryIn", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return fmt.Errorf("unable to reach database after %d attempts: %w", attempts, err)
+}
+
+// Init opens the primary and replica pools dbCfg describes, waits for the primary to come up, and starts background health checks
+func Init(dbCfg conf.DatabaseConfig) (*Cluster, error) {
+	cfg, pool, problems := loadClusterConfig(dbCfg)
+	if len(problems) > 0 {
+		return nil, invalidConfig(problems)
+	}
+
 	db, err := sql.Open("mysql", cfg.FormatDSN())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	pool.apply(db)
 
-	db.SetConnMaxLifetime(time.Minute * 3)
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(10)
+	if err := connectWithRetry(db, dbCfg); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	pingErr := db.Ping()
-	if pingErr != nil {
-		log.Fatal(pingErr)
+	cluster := &Cluster{Primary: db, cfg: dbCfg}
+	for _, dsn := range dbCfg.Replicas {
+		replicaCfg, err := replicaConfig(cfg, dsn)
+		if err != nil {
+			cluster.Close()
+			return nil, fmt.Errorf("invalid replica dsn for [%s]: %w", mysqlAddr(dsn), err)
+		}
+		replicaDB, err := sql.Open("mysql", replicaCfg.FormatDSN())
+		if err != nil {
+			cluster.Close()
+			return nil, fmt.Errorf("invalid replica dsn for [%s]: %w", mysqlAddr(dsn), err)
+		}
+		pool.apply(replicaDB)
+		cluster.replicas = append(cluster.replicas, &replica{dsn: dsn, db: replicaDB})
 	}
-	return db, err
+
+	//A replica that is down at startup just means reads go to the primary until it recovers
+	cluster.checkReplicas()
+	cluster.stop = make(chan struct{})
+	cluster.wg.Add(1)
+	go cluster.monitor(dbCfg.HealthCheckInterval)
+
+	return cluster, nil
 }
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/lengebretsen/go-practice/conf"
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+func openUnconnected(t *testing.T, addr string) *sql.DB {
+	//sql.Open never dials, so these pools are safe to build without a running MySQL
+	db, err := sql.Open("mysql", "gousr:gopass@tcp("+addr+")/go-practice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestClusterReader(t *testing.T) {
+	primary := openUnconnected(t, "primary:3306")
+	replicaA := &replica{db: openUnconnected(t, "replica-a:3306")}
+	replicaB := &replica{db: openUnconnected(t, "replica-b:3306")}
+	cluster := &Cluster{Primary: primary, replicas: []*replica{replicaA, replicaB}}
+	ctx := context.Background()
+
+	//No healthy replicas, reads fall back to the primary
+	assert.Equal(t, cluster.Reader(ctx) == primary, true)
+
+	//Healthy replicas are used round-robin
+	replicaA.healthy.Store(true)
+	replicaB.healthy.Store(true)
+	first, second := cluster.Reader(ctx), cluster.Reader(ctx)
+	assert.Equal(t, first != second, true)
+	assert.Equal(t, first != primary && second != primary, true)
+
+	//Unhealthy replicas are skipped
+	replicaA.healthy.Store(false)
+	assert.Equal(t, cluster.Reader(ctx) == replicaB.db, true)
+	assert.Equal(t, cluster.Reader(ctx) == replicaB.db, true)
+
+	//Callers can force the primary for read-your-writes
+	assert.Equal(t, cluster.Reader(WithPrimaryReads(ctx)) == primary, true)
+	assert.Equal(t, cluster.Writer() == primary, true)
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	dbCfg := conf.DatabaseConfig{
+		User:         "gousr",
+		Name:         "go-practice",
+		Socket:       "/var/run/mysqld/mysqld.sock",
+		Charset:      "utf8mb4",
+		ParseTime:    true,
+		DialTimeout:  2 * time.Second,
+		MaxOpenConns: 20,
+		MaxIdleConns: 5,
+	}
+
+	cfg, problems := loadMySQLConfig(dbCfg)
+	assert.Equal(t, len(problems), 0)
+	assert.Equal(t, cfg.Net, "unix")
+	assert.Equal(t, cfg.Addr, "/var/run/mysqld/mysqld.sock")
+	assert.Equal(t, cfg.Params["charset"], "utf8mb4")
+	assert.Equal(t, cfg.ParseTime, true)
+	assert.Equal(t, cfg.Timeout, 2*time.Second)
+
+	_, problems = loadPoolConfig(dbCfg)
+	assert.Equal(t, len(problems), 0)
+
+	//Every problem is reported, not just the first one found
+	dbCfg.User = ""
+	dbCfg.ReadTimeout = -time.Second
+	dbCfg.MaxIdleConns = 50
+	dbCfg.TLS = conf.DatabaseTLSConfig{Enabled: true, CertFile: "client.pem"}
+
+	_, problems = loadMySQLConfig(dbCfg)
+	_, poolProblems := loadPoolConfig(dbCfg)
+	assert.Equal(t, append(problems, poolProblems...), []string{
+		"database.readTimeout must not be negative",
+		"database.tls.certFile and database.tls.keyFile must be set together",
+		"database.user is required",
+		"database.maxIdleConns must be between 0 and database.maxOpenConns (20), got 50",
+	})
+
+	//a zero interval or attempt count would panic in the health check or never try to connect
+	assert.Equal(t, checkConnectConfig(conf.DatabaseConfig{
+		HealthCheckInterval: 5 * time.Second,
+		PingTimeout:         time.Second,
+		ConnectAttempts:     1,
+		ConnectMaxBackoff:   time.Second,
+	}), []string(nil))
+	assert.Equal(t, checkConnectConfig(conf.DatabaseConfig{
+		ConnectAttempts:   0,
+		ConnectBackoff:    -time.Second,
+		ConnectMaxBackoff: -2 * time.Second,
+	}), []string{
+		"database.healthCheckInterval must be positive, got 0s",
+		"database.pingTimeout must be positive, got 0s",
+		"database.connectAttempts must be at least 1, got 0",
+		"database.connectBackoff must not be negative",
+		"database.connectMaxBackoff must be at least database.connectBackoff (-1s), got -2s",
+	})
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := "CREATE TABLE IF NOT EXISTS users (\n    Id BINARY(16) PRIMARY KEY\n);\n\nCREATE TABLE IF NOT EXISTS\n  api_usage (Day date NOT NULL);\n"
+	assert.Equal(t, splitStatements(script), []string{
+		"CREATE TABLE IF NOT EXISTS users (\n    Id BINARY(16) PRIMARY KEY\n)",
+		"CREATE TABLE IF NOT EXISTS\n  api_usage (Day date NOT NULL)",
+	})
+	assert.Equal(t, len(splitStatements(" \n;\n")), 0)
+}
+
+func TestReplicaConfig(t *testing.T) {
+	primary, problems := loadMySQLConfig(conf.DatabaseConfig{
+		User:        "gousr",
+		Pass:        "gopass",
+		Name:        "go-practice",
+		Host:        "primary",
+		Port:        3306,
+		Charset:     "utf8mb4",
+		ParseTime:   true,
+		DialTimeout: 2 * time.Second,
+		ReadTimeout: 5 * time.Second,
+	})
+	assert.Equal(t, len(problems), 0)
+
+	//only the address and credentials come from the replica's dsn
+	cfg, err := replicaConfig(primary, "reader:readpass@tcp(replica-a:3307)/other")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, cfg.Addr, "replica-a:3307")
+	assert.Equal(t, cfg.User, "reader")
+	assert.Equal(t, cfg.Passwd, "readpass")
+	assert.Equal(t, cfg.DBName, "go-practice")
+	assert.Equal(t, cfg.ParseTime, true)
+	assert.Equal(t, cfg.Params["charset"], "utf8mb4")
+	assert.Equal(t, cfg.Timeout, 2*time.Second)
+	assert.Equal(t, cfg.ReadTimeout, 5*time.Second)
+	//the primary's config is left alone
+	assert.Equal(t, primary.Addr, "primary:3306")
+
+	_, err = replicaConfig(primary, "replica-a:3306")
+	assert.Equal(t, err != nil, true)
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// requiredTables are the tables created by scripts/db/init.sql that the models depend on
+var requiredTables = []string{"users", "addresses", "api_keys", "api_usage", "idempotency_keys"}
+
+// Ping checks that the primary accepts connections
+func (c *Cluster) Ping(ctx context.Context) error {
+	return c.Primary.PingContext(ctx)
+}
+
+// CheckMigrations reports an error when any table the models rely on has not been created yet, or migrate has not
+// recorded the schema version this build needs. A newer version passes, so instances still running the previous
+// build stay ready while a deploy migrates the schema ahead of them
+func (c *Cluster) CheckMigrations(ctx context.Context) error {
+	rows, err := c.Primary.QueryContext(ctx,
+		"SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE()")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		found[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, table := range requiredTables {
+		if !found[table] {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("pending migrations, missing tables: %s", strings.Join(missing, ", "))
+	}
+
+	version := 0
+	if found["schema_version"] {
+		if version, err = c.schemaVersion(ctx); err != nil {
+			return err
+		}
+	}
+	if version < SchemaVersion {
+		return fmt.Errorf("pending migrations, the schema is at version %d of %d", version, SchemaVersion)
+	}
+	return nil
+}
+
+// CheckPoolSaturation reports an error when the share of open connections in use on the primary reaches the
+// configured database.poolSaturationThreshold
+func (c *Cluster) CheckPoolSaturation(ctx context.Context) error {
+	stats := c.Primary.Stats()
+	if stats.MaxOpenConnections <= 0 {
+		return nil
+	}
+	threshold := c.cfg.PoolSaturationThreshold
+	usage := float64(stats.InUse) / float64(stats.MaxOpenConnections)
+	if usage >= threshold {
+		return fmt.Errorf("connection pool saturated: %d of %d connections in use, %d callers waited for a connection",
+			stats.InUse, stats.MaxOpenConnections, stats.WaitCount)
+	}
+	return nil
+}
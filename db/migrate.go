@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SchemaVersion is the schema version this build needs, one for each script passed to Migrate. Bump it when adding
+// a script
+const SchemaVersion = 2
+
+// createSchemaVersionTable records each schema version migrate has brought the database to. It is created by Migrate
+// rather than a script, so databases made by the MySQL container from init.sql have no version until migrate runs
+const createSchemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+    Version int NOT NULL PRIMARY KEY,
+    AppliedAt timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// Migrate runs each of scripts past the version recorded in the schema_version table against the primary in order,
+// recording each version once every statement of its script has run, and returns the versions the schema went from
+// and to. scripts[i] moves the schema to version i+1. Databases created before versions were recorded start at 0;
+// the first script only creates what does not exist yet, so running it on them is safe
+func (c *Cluster) Migrate(ctx context.Context, scripts []string) (from, to int, err error) {
+	if len(scripts) != SchemaVersion {
+		return 0, 0, fmt.Errorf("got %d schema scripts, this build needs %d", len(scripts), SchemaVersion)
+	}
+	if _, err := c.Primary.ExecContext(ctx, createSchemaVersionTable); err != nil {
+		return 0, 0, fmt.Errorf("failed to create the schema_version table: %w", err)
+	}
+	from, err = c.schemaVersion(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for version := from + 1; version <= len(scripts); version++ {
+		for i, statement := range splitStatements(scripts[version-1]) {
+			if _, err := c.Primary.ExecContext(ctx, statement); err != nil {
+				return from, version - 1, fmt.Errorf("statement %d of schema version %d failed: %w", i+1, version, err)
+			}
+		}
+		if _, err := c.Primary.ExecContext(ctx, "INSERT INTO schema_version (Version) VALUES (?)", version); err != nil {
+			return from, version - 1, fmt.Errorf("failed to record schema version %d: %w", version, err)
+		}
+	}
+	to = max(from, len(scripts))
+	return from, to, c.CheckMigrations(ctx)
+}
+
+// schemaVersion is the latest version recorded in the schema_version table, 0 when none is
+func (c *Cluster) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := c.Primary.QueryRowContext(ctx, "SELECT COALESCE(MAX(Version), 0) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	return version, nil
+}
+
+// splitStatements breaks a SQL script into its statements, which the driver only accepts one at a time. The schema
+// scripts have no semicolons inside strings or comments, so splitting on them is enough
+func splitStatements(script string) []string {
+	var statements []string
+	for _, statement := range strings.Split(script, ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
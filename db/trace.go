@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/lengebretsen/go-practice/db")
+
+// TraceStatement starts a client span for a single SQL statement, named after its operation (e.g. "SELECT"), and
+// returns a func that ends the span, recording err if the statement failed
+func (c *Cluster) TraceStatement(ctx context.Context, query string) (context.Context, func(err error)) {
+	operation := strings.ToUpper(strings.SplitN(strings.TrimSpace(query), " ", 2)[0])
+	ctx, span := tracer.Start(ctx, operation+" "+c.cfg.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMySQL,
+			semconv.DBNameKey.String(c.cfg.Name),
+			semconv.DBOperationKey.String(operation),
+			semconv.DBStatementKey.String(query),
+		),
+	)
+	return ctx, func(err error) {
+		//No rows is an expected outcome of a lookup, not a failed statement
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// QueryContext runs a read-only query against the pool picked by Reader, or the transaction ctx carries
+func (c *Cluster) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, end := c.TraceStatement(ctx, query)
+	rows, err := c.reader(ctx).QueryContext(ctx, query, args...)
+	end(err)
+	return rows, err
+}
+
+// QueryRowContext runs a read-only query expected to return at most one row against the pool picked by Reader, or
+// the transaction ctx carries
+func (c *Cluster) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, end := c.TraceStatement(ctx, query)
+	row := c.reader(ctx).QueryRowContext(ctx, query, args...)
+	end(row.Err())
+	return row
+}
+
+// ExecContext runs a statement that modifies data against the primary, or the transaction ctx carries
+func (c *Cluster) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, end := c.TraceStatement(ctx, query)
+	result, err := c.writer(ctx).ExecContext(ctx, query, args...)
+	end(err)
+	return result, err
+}
+
+func (c *Cluster) reader(ctx context.Context) conn {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx
+	}
+	return c.Reader(ctx)
+}
+
+func (c *Cluster) writer(ctx context.Context) conn {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx
+	}
+	return c.Writer()
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lengebretsen/go-practice/logging"
+)
+
+const txKey ctxKey = iota + 1
+
+// conn is the part of *sql.DB and *sql.Tx statements are run through
+type conn interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func txFromContext(ctx context.Context) *sql.Tx {
+	if ctx == nil {
+		return nil
+	}
+	tx, _ := ctx.Value(txKey).(*sql.Tx)
+	return tx
+}
+
+// InTx runs fn in a transaction on the primary, committing when fn succeeds and rolling back when it returns an
+// error. Every statement the Cluster runs with the ctx passed to fn, reads included, goes through the transaction.
+// When ctx already carries a transaction fn joins it instead, so models can group their own statements and still be
+// composed into a larger transaction by the caller
+func (c *Cluster) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if txFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	tx, err := c.Writer().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(context.WithValue(ctx, txKey, tx)); err != nil {
+		//the error that caused the rollback is the one reported to the caller
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			logging.FromContext(ctx).Error("failed to roll back transaction", "error", rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/conf"
+	"github.com/lengebretsen/go-practice/db"
+	"github.com/spf13/cobra"
+)
+
+// errSkipped marks a check that could not run because one it depends on failed
+var errSkipped = errors.New("skipped")
+
+func newDoctorCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the config, the database connection and the schema",
+		Long: "Check that the config is valid, that the database accepts connections and that migrate has created " +
+			"every table the API needs and recorded the schema version this build needs, reporting each check. The " +
+			"database is tried once rather than waited for as serve does. Exits non-zero when any check fails.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			configErr := checkConfig(a.cfg)
+			report(a.out, "config", configErr)
+
+			var database *db.Cluster
+			dbErr := errSkipped
+			if configErr == nil {
+				dbCfg := a.cfg.Database
+				dbCfg.ConnectAttempts = 1
+				if database, dbErr = openDatabase(dbCfg); dbErr == nil {
+					defer database.Close()
+					dbErr = database.Ping(cmd.Context())
+				}
+			}
+			report(a.out, "database", dbErr)
+
+			schemaErr := errSkipped
+			if dbErr == nil {
+				schemaErr = database.CheckMigrations(cmd.Context())
+			}
+			report(a.out, "schema", schemaErr)
+
+			failed := 0
+			for _, err := range []error{configErr, dbErr, schemaErr} {
+				if err != nil && err != errSkipped {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of 3 checks failed", failed)
+			}
+			return nil
+		},
+	}
+}
+
+// report writes the outcome of one check
+func report(out io.Writer, check string, err error) {
+	switch {
+	case err == nil:
+		fmt.Fprintf(out, "ok    %s\n", check)
+	case err == errSkipped:
+		fmt.Fprintf(out, "skip  %s: an earlier check failed\n", check)
+	default:
+		//multi-line errors, like the aggregated config problems, stay indented under their check
+		fmt.Fprintf(out, "FAIL  %s: %s\n", check, strings.ReplaceAll(err.Error(), "\n", "\n      "))
+	}
+}
+
+// checkConfig validates the parts of the config that serve would otherwise only reject once it is starting up.
+// Loading the config has already checked the addresses
+func checkConfig(cfg conf.Config) error {
+	var problems []string
+	if err := db.CheckConfig(cfg.Database); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if cfg.Auth.Enabled {
+		if _, err := auth.LoadTokenVerifier(cfg.Auth.JWT); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
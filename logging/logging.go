@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/lengebretsen/go-practice/conf"
+)
+
+type ctxKey int
+
+const loggerKey ctxKey = iota
+
+// Init builds the logger cfg describes and installs it as the slog default. The returned
+// closer releases the log file when output is not stdout or stderr
+func Init(cfg conf.LogConfig) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, nil, fmt.Errorf("invalid log.level: %w", err)
+	}
+
+	var out io.WriteCloser
+	switch output := cfg.Output; output {
+	case "stdout":
+		out = nopCloser{os.Stdout}
+	case "stderr":
+		out = nopCloser{os.Stderr}
+	default:
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid log.output: %w", err)
+		}
+		out = file
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := strings.ToLower(cfg.Format); format {
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	case "text":
+		handler = slog.NewTextHandler(out, opts)
+	default:
+		out.Close()
+		return nil, nil, fmt.Errorf("invalid log.format [%s], expected json or text", format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger, out, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// WithContext returns a copy of ctx carrying logger
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger stored in ctx by WithContext, falling back to the default logger so callers
+// outside a request can always log
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
@@ -1,22 +1,24 @@
 package main
 
 import (
+	_ "embed"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
-	"strconv"
 
 	"github.com/lengebretsen/go-practice/conf"
-	"github.com/lengebretsen/go-practice/controllers"
 	"github.com/lengebretsen/go-practice/db"
-	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/logging"
 
 	_ "github.com/lengebretsen/go-practice/docs"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/spf13/viper"
+	"github.com/spf13/cobra"
 )
 
+// General API info for the /v1 swagger docs, the /v2 docs take theirs from swagger_v2.go
+
 // @title Go + Gin API Practice
 // @version 1.0
 // @description A small go/gin web app providing a simple REST API for managing users and addresses
@@ -25,23 +27,92 @@ import (
 // @BasePath /
 // @query.collection.format multi
 
-func main() {
-	conf.LoadConfig() //Load viper config
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 
-	database, err := db.Init()
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT or API key sent as "Bearer <token>"
+
+func main() {
+	a := &app{out: os.Stdout}
+	err := a.rootCommand().Execute()
+	if a.logOutput != nil {
+		a.logOutput.Close()
+	}
 	if err != nil {
-		log.Fatal("Failed to initialize database")
+		slog.Error("command failed", "error", err)
+		os.Exit(1)
 	}
-	defer database.Close()
+}
 
-	//Write PID file for make down target
-	pid := os.Getpid()
-	err = os.WriteFile("./GINSVR.pid", []byte(strconv.Itoa(pid)), 0644)
-	if err != nil {
-		log.Fatal("Failed to write PID file.")
+// schema is version 1 of the schema, creating every table, also run by the MySQL container on its first start
+//
+//go:embed scripts/db/init.sql
+var schema string
+
+// idempotencyLocation adds the Location header to the responses stored for idempotent replays
+//
+//go:embed scripts/db/002_idempotency_location.sql
+var idempotencyLocation string
+
+// schemaScripts move the schema to each version in turn, schemaScripts[i] to version i+1
+var schemaScripts = []string{schema, idempotencyLocation}
+
+// app holds what every command shares: the config, where reports are written and the log output opened for the
+// command
+type app struct {
+	cfg       conf.Config
+	out       io.Writer
+	logOutput io.Closer
+}
+
+func (a *app) rootCommand() *cobra.Command {
+	serve := newServeCommand(a)
+	root := &cobra.Command{
+		Use:   "go-practice",
+		Short: "A small go/gin web app providing a simple REST API for managing users and addresses",
+		Long: "A small go/gin web app providing a simple REST API for managing users and addresses. Every command " +
+			"reads ./config.yml, or the file --config names, over the built-in defaults, with GOPRACTICE_ environment " +
+			"variables and the flags below taking precedence. Without a command the API is served, as serve does.",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := conf.Load(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			a.cfg = cfg
+
+			_, logOutput, err := logging.Init(cfg.Log)
+			if err != nil {
+				return fmt.Errorf("failed to initialize logging: %w", err)
+			}
+			a.logOutput = logOutput
+			return nil
+		},
+		RunE: serve.RunE,
 	}
+	root.SetOut(a.out)
+	conf.RegisterFlags(root.PersistentFlags())
+	root.AddCommand(
+		serve,
+		newMigrateCommand(a),
+		newSeedCommand(a),
+		newDoctorCommand(a),
+		newConfigCommand(a),
+	)
+	return root
+}
 
-	router := controllers.SetupRouter()
-	controllers.RegisterRoutes(router, models.UserModel{DB: database}, models.AddressModel{DB: database})
-	router.Run(fmt.Sprintf("%s:%s", viper.Get("server.host"), viper.Get("server.port")))
+// openDatabase connects to the database cfg describes, for the commands that need it
+func openDatabase(cfg conf.DatabaseConfig) (*db.Cluster, error) {
+	database, err := db.Init(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return database, nil
 }
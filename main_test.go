@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lengebretsen/go-practice/testing/assert"
+)
+
+func TestRedact(t *testing.T) {
+	settings := map[string]any{
+		"database": map[string]any{
+			"user": "gousr",
+			"pass": "gopass",
+			"tls":  map[string]any{"keyfile": "/etc/mysql/client-key.pem"},
+			"replicas": []any{
+				"gousr:gopass@tcp(replica-a:3306)/go-practice",
+				"gousr@tcp(replica-b:3306)/go-practice",
+				"not a dsn",
+			},
+		},
+		"auth": map[string]any{
+			"bootstrapkey": "",
+			"jwt": map[string]any{
+				"hmacsecrets": []any{map[string]any{"kid": "2024", "secret": "s3cr3t"}},
+				"jwksfiles":   []any{"jwks.json"},
+			},
+		},
+	}
+
+	assert.Equal(t, redact(settings), map[string]any{
+		"database": map[string]any{
+			"user": "gousr",
+			"pass": redacted,
+			"tls":  map[string]any{"keyfile": "/etc/mysql/client-key.pem"},
+			"replicas": []string{
+				"gousr:REDACTED@tcp(replica-a:3306)/go-practice",
+				"gousr@tcp(replica-b:3306)/go-practice",
+				redacted,
+			},
+		},
+		"auth": map[string]any{
+			//unset secrets are shown so it is clear they are missing
+			"bootstrapkey": "",
+			"jwt": map[string]any{
+				"hmacsecrets": redacted,
+				"jwksfiles":   []any{"jwks.json"},
+			},
+		},
+	})
+}
+
+func TestFaker(t *testing.T) {
+	//the same seed generates the same records
+	first, second := newFaker(42), newFaker(42)
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, first.user(), second.user())
+		assert.Equal(t, first.addresses(3), second.addresses(3))
+	}
+
+	f := newFaker(7)
+	counts := map[int]bool{}
+	for i := 0; i < 200; i++ {
+		addrs := f.addresses(2)
+		counts[len(addrs)] = true
+		for _, addr := range addrs {
+			assert.Equal(t, len(addr.Zip), 5)
+			assert.Equal(t, len(addr.State), 2)
+			assert.Equal(t, addr.Type == "HOME" || addr.Type == "WORK" || addr.Type == "OTHER", true)
+		}
+	}
+	assert.Equal(t, counts, map[int]bool{0: true, 1: true, 2: true})
+	assert.Equal(t, len(f.addresses(0)), 0)
+}
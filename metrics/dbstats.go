@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/lengebretsen/go-practice/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// RegisterDBStats exposes the sql.DBStats of every pool in the cluster as gauges, labelled db_name="primary" for the
+// primary and "replica-N" for each replica
+func RegisterDBStats(cluster *db.Cluster) error {
+	if err := prometheus.Register(collectors.NewDBStatsCollector(cluster.Primary, "primary")); err != nil {
+		return err
+	}
+	for i, replica := range cluster.Replicas() {
+		if err := prometheus.Register(collectors.NewDBStatsCollector(replica, fmt.Sprintf("replica-%d", i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	repositoryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gopractice",
+		Subsystem: "repository",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of repository method calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"repository", "method"})
+
+	repositoryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gopractice",
+		Subsystem: "repository",
+		Name:      "errors_total",
+		Help:      "Count of repository method calls that failed, excluding lookups that found no record.",
+	}, []string{"repository", "method"})
+)
+
+// observe records the latency of a repository call and counts it as an error unless it succeeded or simply found
+// nothing, since not found is an expected outcome rather than a failure
+func observe(repository, method string, start time.Time, err error) {
+	repositoryDuration.WithLabelValues(repository, method).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, models.ErrModelNotFound) {
+		repositoryErrors.WithLabelValues(repository, method).Inc()
+	}
+}
+
+type userRepository struct {
+	next models.UserRepository
+}
+
+// NewUserRepository wraps a UserRepository so every call is timed and failures are counted
+func NewUserRepository(next models.UserRepository) models.UserRepository {
+	return userRepository{next: next}
+}
+
+func (r userRepository) SelectAllUsers(ctx context.Context) (users []models.User, err error) {
+	defer func(start time.Time) { observe("users", "SelectAllUsers", start, err) }(time.Now())
+	return r.next.SelectAllUsers(ctx)
+}
+
+func (r userRepository) SelectUsersByIds(ctx context.Context, ids []uuid.UUID) (users []models.User, err error) {
+	defer func(start time.Time) { observe("users", "SelectUsersByIds", start, err) }(time.Now())
+	return r.next.SelectUsersByIds(ctx, ids)
+}
+
+func (r userRepository) SelectOneUser(ctx context.Context, id uuid.UUID) (user models.User, err error) {
+	defer func(start time.Time) { observe("users", "SelectOneUser", start, err) }(time.Now())
+	return r.next.SelectOneUser(ctx, id)
+}
+
+func (r userRepository) InsertUser(ctx context.Context, usr models.User) (user models.User, err error) {
+	defer func(start time.Time) { observe("users", "InsertUser", start, err) }(time.Now())
+	return r.next.InsertUser(ctx, usr)
+}
+
+func (r userRepository) UpdateUser(ctx context.Context, usr models.User) (user models.User, err error) {
+	defer func(start time.Time) { observe("users", "UpdateUser", start, err) }(time.Now())
+	return r.next.UpdateUser(ctx, usr)
+}
+
+func (r userRepository) DeleteUser(ctx context.Context, id uuid.UUID) (err error) {
+	defer func(start time.Time) { observe("users", "DeleteUser", start, err) }(time.Now())
+	return r.next.DeleteUser(ctx, id)
+}
+
+type addressRepository struct {
+	next models.AddressRepository
+}
+
+// NewAddressRepository wraps an AddressRepository so every call is timed and failures are counted
+func NewAddressRepository(next models.AddressRepository) models.AddressRepository {
+	return addressRepository{next: next}
+}
+
+func (r addressRepository) FetchAddresses(ctx context.Context) (addrs []models.Address, err error) {
+	defer func(start time.Time) { observe("addresses", "FetchAddresses", start, err) }(time.Now())
+	return r.next.FetchAddresses(ctx)
+}
+
+func (r addressRepository) FetchOneAddress(ctx context.Context, id uuid.UUID) (addr models.Address, err error) {
+	defer func(start time.Time) { observe("addresses", "FetchOneAddress", start, err) }(time.Now())
+	return r.next.FetchOneAddress(ctx, id)
+}
+
+func (r addressRepository) InsertAddress(ctx context.Context, a models.Address) (addr models.Address, err error) {
+	defer func(start time.Time) { observe("addresses", "InsertAddress", start, err) }(time.Now())
+	return r.next.InsertAddress(ctx, a)
+}
+
+func (r addressRepository) UpdateAddress(ctx context.Context, a models.Address) (addr models.Address, err error) {
+	defer func(start time.Time) { observe("addresses", "UpdateAddress", start, err) }(time.Now())
+	return r.next.UpdateAddress(ctx, a)
+}
+
+func (r addressRepository) DeleteAddress(ctx context.Context, id uuid.UUID) (err error) {
+	defer func(start time.Time) { observe("addresses", "DeleteAddress", start, err) }(time.Now())
+	return r.next.DeleteAddress(ctx, id)
+}
+
+func (r addressRepository) FindAddressesByUserId(ctx context.Context, userId uuid.UUID) (addrs []models.Address, err error) {
+	defer func(start time.Time) { observe("addresses", "FindAddressesByUserId", start, err) }(time.Now())
+	return r.next.FindAddressesByUserId(ctx, userId)
+}
+
+func (r addressRepository) FindAddressesByUserIds(ctx context.Context, userIds []uuid.UUID) (addrs []models.Address, err error) {
+	defer func(start time.Time) { observe("addresses", "FindAddressesByUserIds", start, err) }(time.Now())
+	return r.next.FindAddressesByUserIds(ctx, userIds)
+}
+
+type apiKeyRepository struct {
+	next models.APIKeyRepository
+}
+
+// NewAPIKeyRepository wraps an APIKeyRepository so every call is timed and failures are counted
+func NewAPIKeyRepository(next models.APIKeyRepository) models.APIKeyRepository {
+	return apiKeyRepository{next: next}
+}
+
+func (r apiKeyRepository) SelectAllAPIKeys(ctx context.Context) (keys []models.APIKey, err error) {
+	defer func(start time.Time) { observe("apiKeys", "SelectAllAPIKeys", start, err) }(time.Now())
+	return r.next.SelectAllAPIKeys(ctx)
+}
+
+func (r apiKeyRepository) SelectOneAPIKey(ctx context.Context, id uuid.UUID) (key models.APIKey, err error) {
+	defer func(start time.Time) { observe("apiKeys", "SelectOneAPIKey", start, err) }(time.Now())
+	return r.next.SelectOneAPIKey(ctx, id)
+}
+
+func (r apiKeyRepository) SelectAPIKeyByHash(ctx context.Context, hash []byte) (key models.APIKey, err error) {
+	defer func(start time.Time) { observe("apiKeys", "SelectAPIKeyByHash", start, err) }(time.Now())
+	return r.next.SelectAPIKeyByHash(ctx, hash)
+}
+
+func (r apiKeyRepository) InsertAPIKey(ctx context.Context, k models.APIKey, hash []byte) (key models.APIKey, err error) {
+	defer func(start time.Time) { observe("apiKeys", "InsertAPIKey", start, err) }(time.Now())
+	return r.next.InsertAPIKey(ctx, k, hash)
+}
+
+func (r apiKeyRepository) RevokeAPIKey(ctx context.Context, id uuid.UUID, at time.Time) (err error) {
+	defer func(start time.Time) { observe("apiKeys", "RevokeAPIKey", start, err) }(time.Now())
+	return r.next.RevokeAPIKey(ctx, id, at)
+}
+
+func (r apiKeyRepository) RotateAPIKey(ctx context.Context, id uuid.UUID, replacement models.APIKey, hash []byte) (key models.APIKey, err error) {
+	defer func(start time.Time) { observe("apiKeys", "RotateAPIKey", start, err) }(time.Now())
+	return r.next.RotateAPIKey(ctx, id, replacement, hash)
+}
+
+func (r apiKeyRepository) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) (err error) {
+	defer func(start time.Time) { observe("apiKeys", "TouchAPIKey", start, err) }(time.Now())
+	return r.next.TouchAPIKey(ctx, id, at)
+}
+
+type usageRepository struct {
+	next models.UsageRepository
+}
+
+// NewUsageRepository wraps a UsageRepository so every call is timed and failures are counted
+func NewUsageRepository(next models.UsageRepository) models.UsageRepository {
+	return usageRepository{next: next}
+}
+
+func (r usageRepository) AddUsage(ctx context.Context, day string, deltas map[string]int64) (totals map[string]int64, err error) {
+	defer func(start time.Time) { observe("usage", "AddUsage", start, err) }(time.Now())
+	return r.next.AddUsage(ctx, day, deltas)
+}
+
+func (r usageRepository) SelectUsage(ctx context.Context, client string, day string) (requests int64, err error) {
+	defer func(start time.Time) { observe("usage", "SelectUsage", start, err) }(time.Now())
+	return r.next.SelectUsage(ctx, client, day)
+}
+
+type idempotencyRepository struct {
+	next models.IdempotencyRepository
+}
+
+// NewIdempotencyRepository wraps an IdempotencyRepository so every call is timed and failures are counted
+func NewIdempotencyRepository(next models.IdempotencyRepository) models.IdempotencyRepository {
+	return idempotencyRepository{next: next}
+}
+
+func (r idempotencyRepository) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (existing models.IdempotencyRecord, reserved bool, err error) {
+	defer func(start time.Time) { observe("idempotency", "ReserveIdempotencyKey", start, err) }(time.Now())
+	return r.next.ReserveIdempotencyKey(ctx, record)
+}
+
+func (r idempotencyRepository) CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (err error) {
+	defer func(start time.Time) { observe("idempotency", "CompleteIdempotencyKey", start, err) }(time.Now())
+	return r.next.CompleteIdempotencyKey(ctx, record)
+}
+
+func (r idempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, clientKey string, key string) (err error) {
+	defer func(start time.Time) { observe("idempotency", "ReleaseIdempotencyKey", start, err) }(time.Now())
+	return r.next.ReleaseIdempotencyKey(ctx, clientKey, key)
+}
+
+func (r idempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (count int64, err error) {
+	defer func(start time.Time) { observe("idempotency", "DeleteExpiredIdempotencyKeys", start, err) }(time.Now())
+	return r.next.DeleteExpiredIdempotencyKeys(ctx, before)
+}
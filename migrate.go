@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newMigrateCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "Bring the schema up to the version this build needs",
+		Long: "Bring the schema up to the version this build needs by running each schema script built into the " +
+			"binary that the schema_version table does not record as applied yet, starting with scripts/db/init.sql. " +
+			"Existing tables and their rows are left alone, so migrate is safe to run on every deploy.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			database, err := openDatabase(a.cfg.Database)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+			from, to, err := database.Migrate(cmd.Context(), schemaScripts)
+			if from != to {
+				fmt.Fprintf(a.out, "migrated the schema from version %d to %d\n", from, to)
+			}
+			if err != nil {
+				return err
+			}
+			if from == to {
+				fmt.Fprintf(a.out, "schema is already at version %d\n", to)
+			}
+			return nil
+		},
+	}
+}
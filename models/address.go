@@ -1,38 +1,44 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"encoding/xml"
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/db"
 )
 
 type Address struct {
-	Id     uuid.UUID `json:"id"`
-	UserId uuid.UUID `json:"userId"`
-	Street string    `json:"street"`
-	City   string    `json:"city"`
-	State  string    `json:"state"`
-	Zip    string    `json:"zip"`
-	Type   string    `json:"type"`
+	XMLName xml.Name  `json:"-" xml:"address"`
+	Id      uuid.UUID `json:"id" xml:"id"`
+	UserId  uuid.UUID `json:"userId" xml:"userId"`
+	Street  string    `json:"street" xml:"street"`
+	City    string    `json:"city" xml:"city"`
+	State   string    `json:"state" xml:"state"`
+	Zip     string    `json:"zip" xml:"zip"`
+	Type    string    `json:"type" xml:"type"`
 }
 
 type AddressModel struct {
-	DB *sql.DB
+	DB *db.Cluster
 }
 
 type AddressRepository interface {
-	FetchAddresses() ([]Address, error)
-	FetchOneAddress(id uuid.UUID) (Address, error)
-	InsertAddress(addr Address) (Address, error)
-	UpdateAddress(addr Address) (Address, error)
-	DeleteAddress(id uuid.UUID) error
-	FindAddressesByUserId(userId uuid.UUID) ([]Address, error)
+	FetchAddresses(ctx context.Context) ([]Address, error)
+	FetchOneAddress(ctx context.Context, id uuid.UUID) (Address, error)
+	InsertAddress(ctx context.Context, addr Address) (Address, error)
+	UpdateAddress(ctx context.Context, addr Address) (Address, error)
+	DeleteAddress(ctx context.Context, id uuid.UUID) error
+	FindAddressesByUserId(ctx context.Context, userId uuid.UUID) ([]Address, error)
+	FindAddressesByUserIds(ctx context.Context, userIds []uuid.UUID) ([]Address, error)
 }
 
-func (m AddressModel) queryForAddresses(query string, args ...any) ([]Address, error) {
+func (m AddressModel) queryForAddresses(ctx context.Context, query string, args ...any) ([]Address, error) {
 	var addrs []Address = make([]Address, 0)
-	rows, err := m.DB.Query(query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -51,18 +57,31 @@ func (m AddressModel) queryForAddresses(query string, args ...any) ([]Address, e
 	return addrs, err
 }
 
-func (m AddressModel) FetchAddresses() ([]Address, error) {
-	return m.queryForAddresses("SELECT * FROM addresses")
+func (m AddressModel) FetchAddresses(ctx context.Context) ([]Address, error) {
+	return m.queryForAddresses(ctx, "SELECT * FROM addresses")
 }
 
-func (m AddressModel) FindAddressesByUserId(userId uuid.UUID) ([]Address, error) {
-	return m.queryForAddresses("SELECT * FROM addresses WHERE UserId = UUID_TO_BIN(?)", userId)
+func (m AddressModel) FindAddressesByUserId(ctx context.Context, userId uuid.UUID) ([]Address, error) {
+	return m.queryForAddresses(ctx, "SELECT * FROM addresses WHERE UserId = UUID_TO_BIN(?)", userId)
 }
 
-func (m AddressModel) FetchOneAddress(id uuid.UUID) (Address, error) {
+// FindAddressesByUserIds loads the addresses of several users with a single query
+func (m AddressModel) FindAddressesByUserIds(ctx context.Context, userIds []uuid.UUID) ([]Address, error) {
+	if len(userIds) == 0 {
+		return make([]Address, 0), nil
+	}
+	args := make([]any, len(userIds))
+	for i, id := range userIds {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("UUID_TO_BIN(?), ", len(userIds)), ", ")
+	return m.queryForAddresses(ctx, "SELECT * FROM addresses WHERE UserId IN ("+placeholders+")", args...)
+}
+
+func (m AddressModel) FetchOneAddress(ctx context.Context, id uuid.UUID) (Address, error) {
 	var addr Address
 
-	row := m.DB.QueryRow("SELECT * FROM addresses WHERE Id = UUID_TO_BIN(?)", id)
+	row := m.DB.QueryRowContext(ctx, "SELECT * FROM addresses WHERE Id = UUID_TO_BIN(?)", id)
 	err := row.Scan(&addr.Id, &addr.UserId, &addr.Street, &addr.City, &addr.State, &addr.Zip, &addr.Type)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -73,8 +92,9 @@ func (m AddressModel) FetchOneAddress(id uuid.UUID) (Address, error) {
 	return addr, err
 }
 
-func (m AddressModel) InsertAddress(addr Address) (Address, error) {
-	result, err := m.DB.Exec(
+func (m AddressModel) InsertAddress(ctx context.Context, addr Address) (Address, error) {
+	result, err := m.DB.ExecContext(
+		ctx,
 		"INSERT INTO addresses (id, userId, street, city, state, zip, type) VALUES (UUID_TO_BIN(?), UUID_TO_BIN(?), ?, ?, ?, ?, ?)",
 		addr.Id,
 		addr.UserId,
@@ -85,7 +105,7 @@ func (m AddressModel) InsertAddress(addr Address) (Address, error) {
 		addr.Type,
 	)
 	if err != nil {
-		return Address{}, err
+		return Address{}, translateError(err)
 	}
 	count, err := result.RowsAffected()
 	if err != nil {
@@ -97,8 +117,9 @@ func (m AddressModel) InsertAddress(addr Address) (Address, error) {
 	return addr, err
 }
 
-func (m AddressModel) UpdateAddress(addr Address) (Address, error) {
-	result, err := m.DB.Exec(
+func (m AddressModel) UpdateAddress(ctx context.Context, addr Address) (Address, error) {
+	result, err := m.DB.ExecContext(
+		ctx,
 		"UPDATE addresses set UserId = UUID_TO_BIN(?), Street = ?, City = ?, State = ?, Zip = ?, Type = ? WHERE Id = UUID_TO_BIN(?)",
 		addr.UserId,
 		addr.State,
@@ -109,7 +130,7 @@ func (m AddressModel) UpdateAddress(addr Address) (Address, error) {
 		addr.Id,
 	)
 	if err != nil {
-		return Address{}, err
+		return Address{}, translateError(err)
 	}
 	count, err := result.RowsAffected()
 	if err != nil {
@@ -121,8 +142,8 @@ func (m AddressModel) UpdateAddress(addr Address) (Address, error) {
 	return addr, err
 }
 
-func (m AddressModel) DeleteAddress(id uuid.UUID) error {
-	result, err := m.DB.Exec("DELETE FROM addresses WHERE Id = UUID_TO_BIN(?)", id)
+func (m AddressModel) DeleteAddress(ctx context.Context, id uuid.UUID) error {
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM addresses WHERE Id = UUID_TO_BIN(?)", id)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/db"
+)
+
+// APIKey describes a key clients authenticate with. Only a hash of the secret is stored, so the secret itself is
+// shown once when the key is created and can never be read back
+type APIKey struct {
+	XMLName    xml.Name   `json:"-" xml:"apiKey"`
+	Id         uuid.UUID  `json:"id" xml:"id"`
+	Name       string     `json:"name" xml:"name"`
+	Prefix     string     `json:"prefix" xml:"prefix"`
+	Admin      bool       `json:"admin" xml:"admin"`
+	CreatedAt  time.Time  `json:"createdAt" xml:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" xml:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" xml:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty" xml:"revokedAt,omitempty"`
+}
+
+type APIKeyModel struct {
+	DB *db.Cluster
+}
+
+type APIKeyRepository interface {
+	SelectAllAPIKeys(ctx context.Context) ([]APIKey, error)
+	SelectOneAPIKey(ctx context.Context, id uuid.UUID) (APIKey, error)
+	SelectAPIKeyByHash(ctx context.Context, hash []byte) (APIKey, error)
+	InsertAPIKey(ctx context.Context, key APIKey, hash []byte) (APIKey, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error
+	RotateAPIKey(ctx context.Context, id uuid.UUID, replacement APIKey, hash []byte) (APIKey, error)
+	TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error
+}
+
+const apiKeyColumns = "Id, Name, Prefix, `Admin`, CreatedAt, ExpiresAt, LastUsedAt, RevokedAt"
+
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(&key.Id, &key.Name, &key.Prefix, &key.Admin, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+	return key, err
+}
+
+func (m APIKeyModel) SelectAllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey = make([]APIKey, 0)
+	rows, err := m.DB.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY CreatedAt")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m APIKeyModel) SelectOneAPIKey(ctx context.Context, id uuid.UUID) (APIKey, error) {
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE Id = UUID_TO_BIN(?)", id))
+	if err == sql.ErrNoRows {
+		return APIKey{}, ErrModelNotFound
+	}
+	return key, err
+}
+
+// SelectAPIKeyByHash looks up the key a client presented. It always reads from the primary so new keys work and
+// revoked keys stop working straight away, rather than once the change reaches the replicas
+func (m APIKeyModel) SelectAPIKeyByHash(ctx context.Context, hash []byte) (APIKey, error) {
+	key, err := scanAPIKey(m.DB.QueryRowContext(db.WithPrimaryReads(ctx), "SELECT "+apiKeyColumns+" FROM api_keys WHERE KeyHash = ?", hash))
+	if err == sql.ErrNoRows {
+		return APIKey{}, ErrModelNotFound
+	}
+	return key, err
+}
+
+func (m APIKeyModel) InsertAPIKey(ctx context.Context, key APIKey, hash []byte) (APIKey, error) {
+	result, err := m.DB.ExecContext(ctx,
+		"INSERT INTO api_keys (Id, Name, Prefix, KeyHash, `Admin`, CreatedAt, ExpiresAt) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?)",
+		key.Id,
+		key.Name,
+		key.Prefix,
+		hash,
+		key.Admin,
+		key.CreatedAt,
+		key.ExpiresAt,
+	)
+	if err != nil {
+		return APIKey{}, translateError(err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return APIKey{}, err
+	}
+	if count != 1 {
+		return APIKey{}, fmt.Errorf("invalid number of rows written: %d", count)
+	}
+	return key, nil
+}
+
+// RevokeAPIKey disables a key, returning ErrModelNotFound when no active key has the given id
+func (m APIKeyModel) RevokeAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	result, err := m.DB.ExecContext(ctx, "UPDATE api_keys SET RevokedAt = ? WHERE Id = UUID_TO_BIN(?) AND RevokedAt IS NULL", at, id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrModelNotFound
+	}
+	return nil
+}
+
+// RotateAPIKey revokes the key with the given id and stores its replacement in a single transaction, so a key is
+// never left revoked without a replacement
+func (m APIKeyModel) RotateAPIKey(ctx context.Context, id uuid.UUID, replacement APIKey, hash []byte) (APIKey, error) {
+	err := m.DB.InTx(ctx, func(ctx context.Context) error {
+		res, err := m.DB.ExecContext(ctx, "UPDATE api_keys SET RevokedAt = ? WHERE Id = UUID_TO_BIN(?) AND RevokedAt IS NULL", replacement.CreatedAt, id)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrModelNotFound
+		}
+
+		_, err = m.DB.ExecContext(ctx,
+			"INSERT INTO api_keys (Id, Name, Prefix, KeyHash, `Admin`, CreatedAt, ExpiresAt) VALUES (UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?)",
+			replacement.Id,
+			replacement.Name,
+			replacement.Prefix,
+			hash,
+			replacement.Admin,
+			replacement.CreatedAt,
+			replacement.ExpiresAt,
+		)
+		return translateError(err)
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+	return replacement, nil
+}
+
+// TouchAPIKey records when a key was last used to authenticate
+func (m APIKeyModel) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	_, err := m.DB.ExecContext(ctx, "UPDATE api_keys SET LastUsedAt = ? WHERE Id = UUID_TO_BIN(?)", at, id)
+	return err
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lengebretsen/go-practice/db"
+)
+
+// IdempotencyRecord is the stored outcome of a request sent with an Idempotency-Key header. StatusCode is zero while
+// the original request is still being handled
+type IdempotencyRecord struct {
+	ClientKey   string
+	Key         string
+	RequestHash []byte
+	StatusCode  int
+	ContentType string
+	//Location is the Location header of the response, empty when it had none
+	Location  string
+	Body      []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// InProgress reports whether the request that reserved the key has not finished yet
+func (r IdempotencyRecord) InProgress() bool {
+	return r.StatusCode == 0
+}
+
+type IdempotencyModel struct {
+	DB *db.Cluster
+}
+
+type IdempotencyRepository interface {
+	ReserveIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, record IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, clientKey string, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+const idempotencyColumns = "ClientKey, IdemKey, RequestHash, StatusCode, ContentType, Location, Body, CreatedAt, ExpiresAt"
+
+// ReserveIdempotencyKey stores record as in progress unless the client already has an unexpired record under the same
+// key, in which case that record is returned and the reservation reports false
+func (m IdempotencyModel) ReserveIdempotencyKey(ctx context.Context, record IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE ClientKey = ? AND IdemKey = ? AND ExpiresAt <= ?",
+		record.ClientKey, record.Key, record.CreatedAt)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	_, err = m.DB.ExecContext(ctx, "INSERT INTO idempotency_keys ("+idempotencyColumns+") VALUES (?, ?, ?, 0, '', '', '', ?, ?)",
+		record.ClientKey, record.Key, record.RequestHash, record.CreatedAt, record.ExpiresAt)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		//read from the primary, the competing reservation may have been made moments ago
+		var existing IdempotencyRecord
+		err = m.DB.QueryRowContext(db.WithPrimaryReads(ctx),
+			"SELECT "+idempotencyColumns+" FROM idempotency_keys WHERE ClientKey = ? AND IdemKey = ?", record.ClientKey, record.Key,
+		).Scan(&existing.ClientKey, &existing.Key, &existing.RequestHash, &existing.StatusCode, &existing.ContentType,
+			&existing.Location, &existing.Body, &existing.CreatedAt, &existing.ExpiresAt)
+		if err == sql.ErrNoRows {
+			//the other reservation was released in between, the client can simply retry
+			return IdempotencyRecord{}, false, ErrModelConflict
+		}
+		return existing, false, err
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+// CompleteIdempotencyKey saves the response to a reserved key so retries can be answered with it until ExpiresAt
+func (m IdempotencyModel) CompleteIdempotencyKey(ctx context.Context, record IdempotencyRecord) error {
+	result, err := m.DB.ExecContext(ctx,
+		"UPDATE idempotency_keys SET StatusCode = ?, ContentType = ?, Location = ?, Body = ?, ExpiresAt = ? WHERE ClientKey = ? AND IdemKey = ?",
+		record.StatusCode, record.ContentType, record.Location, record.Body, record.ExpiresAt, record.ClientKey, record.Key)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrModelNotFound
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey drops a reservation whose request failed, so the client can retry with the same key
+func (m IdempotencyModel) ReleaseIdempotencyKey(ctx context.Context, clientKey string, key string) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE ClientKey = ? AND IdemKey = ? AND StatusCode = 0", clientKey, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes records that expired before the given time, returning how many were removed
+func (m IdempotencyModel) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE ExpiresAt <= ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
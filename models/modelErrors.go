@@ -1,5 +1,32 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
 
 var ErrModelNotFound = errors.New("resource not found")
+
+// ErrModelConflict is returned when a write violates a uniqueness or foreign key constraint
+var ErrModelConflict = errors.New("resource conflicts with existing data")
+
+// MySQL error numbers for constraint violations, see https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	mysqlDuplicateEntry  = 1062
+	mysqlRowIsReferenced = 1451
+	mysqlNoReferencedRow = 1452
+)
+
+// translateError converts MySQL constraint violations into ErrModelConflict, keeping the driver's message
+func translateError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry, mysqlRowIsReferenced, mysqlNoReferencedRow:
+			return fmt.Errorf("%w: %s", ErrModelConflict, mysqlErr.Message)
+		}
+	}
+	return err
+}
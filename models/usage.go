@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lengebretsen/go-practice/db"
+)
+
+// UsageDayFormat is the layout of the day strings usage is recorded under, usage is always counted per UTC day
+const UsageDayFormat = "2006-01-02"
+
+type UsageModel struct {
+	DB *db.Cluster
+}
+
+type UsageRepository interface {
+	AddUsage(ctx context.Context, day string, deltas map[string]int64) (map[string]int64, error)
+	SelectUsage(ctx context.Context, client string, day string) (int64, error)
+}
+
+// AddUsage adds each client's request count for day to the stored totals and returns the new totals, which include
+// requests counted by every other instance of the app
+func (m UsageModel) AddUsage(ctx context.Context, day string, deltas map[string]int64) (map[string]int64, error) {
+	totals := make(map[string]int64, len(deltas))
+	if len(deltas) == 0 {
+		return totals, nil
+	}
+
+	rows := make([]string, 0, len(deltas))
+	args := make([]any, 0, 3*len(deltas))
+	clients := make([]any, 0, len(deltas))
+	for client, delta := range deltas {
+		rows = append(rows, "(?, ?, ?)")
+		args = append(args, client, day, delta)
+		clients = append(clients, client)
+	}
+	_, err := m.DB.ExecContext(ctx,
+		"INSERT INTO api_usage (ClientKey, `Day`, Requests) VALUES "+strings.Join(rows, ", ")+
+			" AS new ON DUPLICATE KEY UPDATE Requests = api_usage.Requests + new.Requests",
+		args...)
+	if err != nil {
+		return nil, err
+	}
+
+	//read back from the primary, replicas may not have the increment yet
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(clients)), ", ")
+	result, err := m.DB.QueryContext(db.WithPrimaryReads(ctx),
+		"SELECT ClientKey, Requests FROM api_usage WHERE `Day` = ? AND ClientKey IN ("+placeholders+")",
+		append([]any{day}, clients...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var client string
+		var requests int64
+		if err := result.Scan(&client, &requests); err != nil {
+			return nil, err
+		}
+		totals[client] = requests
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// SelectUsage returns how many requests client has made on day, which is zero when none have been recorded
+func (m UsageModel) SelectUsage(ctx context.Context, client string, day string) (int64, error) {
+	var requests int64
+	err := m.DB.QueryRowContext(db.WithPrimaryReads(ctx),
+		"SELECT COALESCE(SUM(Requests), 0) FROM api_usage WHERE ClientKey = ? AND `Day` = ?", client, day).Scan(&requests)
+	return requests, err
+}
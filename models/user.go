@@ -3,32 +3,37 @@ package models
 import (
 	"context"
 	"database/sql"
+	"encoding/xml"
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/db"
 )
 
 type User struct {
-	Id        uuid.UUID `json:"id"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
+	XMLName   xml.Name  `json:"-" xml:"user"`
+	Id        uuid.UUID `json:"id" xml:"id"`
+	FirstName string    `json:"firstName" xml:"firstName"`
+	LastName  string    `json:"lastName" xml:"lastName"`
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB *db.Cluster
 }
 
 type UserRepository interface {
-	SelectAllUsers() ([]User, error)
-	SelectOneUser(id uuid.UUID) (User, error)
-	InsertUser(usr User) (User, error)
-	UpdateUser(usr User) (User, error)
-	DeleteUser(id uuid.UUID) error
+	SelectAllUsers(ctx context.Context) ([]User, error)
+	SelectOneUser(ctx context.Context, id uuid.UUID) (User, error)
+	InsertUser(ctx context.Context, usr User) (User, error)
+	UpdateUser(ctx context.Context, usr User) (User, error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	SelectUsersByIds(ctx context.Context, ids []uuid.UUID) ([]User, error)
 }
 
-func (m UserModel) SelectAllUsers() ([]User, error) {
+func (m UserModel) queryForUsers(ctx context.Context, query string, args ...any) ([]User, error) {
 	var users []User = make([]User, 0)
-	rows, err := m.DB.Query("SELECT * FROM users")
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,10 +52,27 @@ func (m UserModel) SelectAllUsers() ([]User, error) {
 	return users, err
 }
 
-func (m UserModel) SelectOneUser(id uuid.UUID) (User, error) {
+func (m UserModel) SelectAllUsers(ctx context.Context) ([]User, error) {
+	return m.queryForUsers(ctx, "SELECT * FROM users")
+}
+
+// SelectUsersByIds loads several users with a single query, leaving out ids that do not exist
+func (m UserModel) SelectUsersByIds(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	if len(ids) == 0 {
+		return make([]User, 0), nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("UUID_TO_BIN(?), ", len(ids)), ", ")
+	return m.queryForUsers(ctx, "SELECT * FROM users WHERE Id IN ("+placeholders+")", args...)
+}
+
+func (m UserModel) SelectOneUser(ctx context.Context, id uuid.UUID) (User, error) {
 	var user User
 
-	row := m.DB.QueryRow("SELECT * FROM users WHERE Id = UUID_TO_BIN(?)", id)
+	row := m.DB.QueryRowContext(ctx, "SELECT * FROM users WHERE Id = UUID_TO_BIN(?)", id)
 	err := row.Scan(&user.Id, &user.FirstName, &user.LastName)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -61,10 +83,10 @@ func (m UserModel) SelectOneUser(id uuid.UUID) (User, error) {
 	return user, err
 }
 
-func (m UserModel) InsertUser(usr User) (User, error) {
-	result, err := m.DB.Exec("INSERT INTO users (id, firstname, lastname) VALUES (UUID_TO_BIN(?), ?, ?)", usr.Id, usr.FirstName, usr.LastName)
+func (m UserModel) InsertUser(ctx context.Context, usr User) (User, error) {
+	result, err := m.DB.ExecContext(ctx, "INSERT INTO users (id, firstname, lastname) VALUES (UUID_TO_BIN(?), ?, ?)", usr.Id, usr.FirstName, usr.LastName)
 	if err != nil {
-		return User{}, err
+		return User{}, translateError(err)
 	}
 	count, err := result.RowsAffected()
 	if err != nil {
@@ -76,10 +98,10 @@ func (m UserModel) InsertUser(usr User) (User, error) {
 	return usr, err
 }
 
-func (m UserModel) UpdateUser(usr User) (User, error) {
-	result, err := m.DB.Exec("UPDATE users set FirstName = ?, LastName = ? WHERE Id = UUID_TO_BIN(?)", usr.FirstName, usr.LastName, usr.Id)
+func (m UserModel) UpdateUser(ctx context.Context, usr User) (User, error) {
+	result, err := m.DB.ExecContext(ctx, "UPDATE users set FirstName = ?, LastName = ? WHERE Id = UUID_TO_BIN(?)", usr.FirstName, usr.LastName, usr.Id)
 	if err != nil {
-		return User{}, err
+		return User{}, translateError(err)
 	}
 	count, err := result.RowsAffected()
 	if err != nil {
@@ -91,37 +113,26 @@ func (m UserModel) UpdateUser(usr User) (User, error) {
 	return usr, err
 }
 
-func (m UserModel) DeleteUser(id uuid.UUID) error {
-	//Start new db transaction
-	ctx := context.Background()
-	tx, err := m.DB.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-
-	//Delete address records
-	_, err = tx.ExecContext(ctx, "DELETE FROM addresses WHERE UserId = UUID_TO_BIN(?)", id.String())
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	//Delete user record
-	res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE Id = UUID_TO_BIN(?)", id.String())
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	rows, err := res.RowsAffected()
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	if rows == 0 {
-		tx.Rollback()
-		return ErrModelNotFound
-	}
-
-	//Commit transaction
-	err = tx.Commit()
-	return err
+// DeleteUser removes the user and their addresses in a single transaction
+func (m UserModel) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return m.DB.InTx(ctx, func(ctx context.Context) error {
+		//Delete address records
+		_, err := m.DB.ExecContext(ctx, "DELETE FROM addresses WHERE UserId = UUID_TO_BIN(?)", id.String())
+		if err != nil {
+			return err
+		}
+		//Delete user record
+		res, err := m.DB.ExecContext(ctx, "DELETE FROM users WHERE Id = UUID_TO_BIN(?)", id.String())
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrModelNotFound
+		}
+		return nil
+	})
 }
@@ -0,0 +1,2 @@
+ALTER TABLE idempotency_keys
+  ADD COLUMN Location varchar(2048) NOT NULL DEFAULT '' AFTER ContentType;
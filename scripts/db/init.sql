@@ -1,3 +1,6 @@
+-- Version 1 of the schema, also run by the MySQL container. Later changes go in numbered scripts beside it that
+-- migrate applies in order, so this script is never edited
+
 CREATE TABLE IF NOT EXISTS users (
     Id BINARY(16) PRIMARY KEY,
     FirstName VARCHAR(255),
@@ -16,4 +19,41 @@ CREATE TABLE IF NOT EXISTS
     PRIMARY KEY (Id),
     KEY addresses_users (UserId),
     CONSTRAINT addresses_users FOREIGN KEY (UserId) REFERENCES users (Id)
-  )
\ No newline at end of file
+  );
+
+CREATE TABLE IF NOT EXISTS
+  api_keys (
+    Id binary(16) NOT NULL,
+    Name varchar(255) NOT NULL,
+    Prefix varchar(16) NOT NULL,
+    KeyHash binary(32) NOT NULL,
+    `Admin` boolean NOT NULL DEFAULT FALSE,
+    CreatedAt datetime(6) NOT NULL,
+    ExpiresAt datetime(6) DEFAULT NULL,
+    LastUsedAt datetime(6) DEFAULT NULL,
+    RevokedAt datetime(6) DEFAULT NULL,
+    PRIMARY KEY (Id),
+    UNIQUE KEY api_keys_hash (KeyHash)
+  );
+
+CREATE TABLE IF NOT EXISTS
+  api_usage (
+    ClientKey varchar(255) NOT NULL,
+    `Day` date NOT NULL,
+    Requests bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (ClientKey, `Day`)
+  );
+
+CREATE TABLE IF NOT EXISTS
+  idempotency_keys (
+    ClientKey varchar(255) NOT NULL,
+    IdemKey varchar(255) NOT NULL,
+    RequestHash binary(32) NOT NULL,
+    StatusCode smallint NOT NULL DEFAULT 0,
+    ContentType varchar(255) NOT NULL DEFAULT '',
+    Body mediumblob NOT NULL,
+    CreatedAt datetime(6) NOT NULL,
+    ExpiresAt datetime(6) NOT NULL,
+    PRIMARY KEY (ClientKey, IdemKey),
+    KEY idempotency_keys_expires (ExpiresAt)
+  );
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lengebretsen/go-practice/metrics"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/spf13/cobra"
+)
+
+func newSeedCommand(a *app) *cobra.Command {
+	var users, maxAddresses int
+	var seed int64
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Fill the database with realistic fake users and addresses",
+		Long: "Insert fake users, each with between zero and --max-addresses addresses, for trying out the API " +
+			"locally. Each user is inserted with their addresses in one transaction. The same --seed always generates " +
+			"the same records, ids included.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if users < 1 {
+				return fmt.Errorf("--users must be at least 1, got %d", users)
+			}
+			if maxAddresses < 0 {
+				return fmt.Errorf("--max-addresses must not be negative, got %d", maxAddresses)
+			}
+			if !cmd.Flags().Changed("seed") {
+				seed = time.Now().UnixNano()
+			}
+
+			database, err := openDatabase(a.cfg.Database)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+			userRepo := metrics.NewUserRepository(models.UserModel{DB: database})
+			addressRepo := metrics.NewAddressRepository(models.AddressModel{DB: database})
+
+			generated := newFaker(seed)
+			var addressCount int
+			for i := 0; i < users; i++ {
+				user, addrs := generated.user(), generated.addresses(maxAddresses)
+				err := database.InTx(cmd.Context(), func(ctx context.Context) error {
+					created, err := userRepo.InsertUser(ctx, user)
+					if err != nil {
+						return err
+					}
+					for _, addr := range addrs {
+						addr.UserId = created.Id
+						if _, err := addressRepo.InsertAddress(ctx, addr); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					fmt.Fprintf(a.out, "seeded %d users and %d addresses before failing\n", i, addressCount)
+					return err
+				}
+				addressCount += len(addrs)
+			}
+			fmt.Fprintf(a.out, "seeded %d users and %d addresses with --seed %d\n", users, addressCount, seed)
+			return nil
+		},
+	}
+	cmd.Flags().IntVarP(&users, "users", "n", 10, "number of users to insert")
+	cmd.Flags().IntVar(&maxAddresses, "max-addresses", 3, "most addresses any one user gets")
+	cmd.Flags().Int64Var(&seed, "seed", 0, "seed for the generator, random when not given")
+	return cmd
+}
+
+var (
+	firstNames = []string{"James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda", "David",
+		"Elizabeth", "William", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah", "Carlos",
+		"Karen", "Daniel", "Lisa", "Matthew", "Nancy", "Anthony", "Sofia", "Wei", "Priya", "Hiroshi", "Amara"}
+	lastNames = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez",
+		"Martinez", "Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson",
+		"Martin", "Lee", "Perez", "Thompson", "White", "Harris", "Nguyen", "Chen", "Patel", "Kim", "Okafor"}
+	streetNames = []string{"Main", "Oak", "Pine", "Maple", "Cedar", "Elm", "Washington", "Lake", "Hill", "Park",
+		"Sunset", "Lincoln", "Jackson", "Church", "River", "Highland", "Meadow", "Forest", "Willow", "Spring"}
+	streetSuffixes = []string{"St", "Ave", "Blvd", "Rd", "Ln", "Dr", "Ct", "Way", "Pl"}
+	//places pair each city with its state and the first three digits of its zip codes
+	places = []struct{ city, state, zipPrefix string }{
+		{"Boise", "ID", "837"}, {"Seattle", "WA", "981"}, {"Portland", "OR", "972"}, {"Denver", "CO", "802"},
+		{"Austin", "TX", "787"}, {"Chicago", "IL", "606"}, {"Boston", "MA", "021"}, {"Atlanta", "GA", "303"},
+		{"Phoenix", "AZ", "850"}, {"Nashville", "TN", "372"}, {"Minneapolis", "MN", "554"}, {"Miami", "FL", "331"},
+		{"Salt Lake City", "UT", "841"}, {"Columbus", "OH", "432"}, {"Sacramento", "CA", "958"},
+	}
+	addressTypes = []string{"HOME", "WORK", "OTHER"}
+)
+
+// faker generates users and addresses from a seeded source, so a seed always gives the same records
+type faker struct {
+	r *rand.Rand
+}
+
+func newFaker(seed int64) faker {
+	return faker{r: rand.New(rand.NewSource(seed))}
+}
+
+func (f faker) id() uuid.UUID {
+	//reading from the seeded source keeps ids reproducible, math/rand never fails to read
+	id, _ := uuid.NewRandomFromReader(f.r)
+	return id
+}
+
+func (f faker) pick(values []string) string {
+	return values[f.r.Intn(len(values))]
+}
+
+func (f faker) user() models.User {
+	return models.User{Id: f.id(), FirstName: f.pick(firstNames), LastName: f.pick(lastNames)}
+}
+
+// addresses generates between zero and most addresses for a user yet to be assigned
+func (f faker) addresses(most int) []models.Address {
+	count := f.r.Intn(most + 1)
+	addrs := make([]models.Address, count)
+	for i := range addrs {
+		place := places[f.r.Intn(len(places))]
+		addrs[i] = models.Address{
+			Id:     f.id(),
+			Street: fmt.Sprintf("%d %s %s", 100+f.r.Intn(9900), f.pick(streetNames), f.pick(streetSuffixes)),
+			City:   place.city,
+			State:  place.state,
+			Zip:    fmt.Sprintf("%s%02d", place.zipPrefix, f.r.Intn(100)),
+			Type:   f.pick(addressTypes),
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lengebretsen/go-practice/auth"
+	"github.com/lengebretsen/go-practice/conf"
+	"github.com/lengebretsen/go-practice/controllers"
+	"github.com/lengebretsen/go-practice/metrics"
+	"github.com/lengebretsen/go-practice/models"
+	"github.com/lengebretsen/go-practice/tracing"
+	"github.com/spf13/cobra"
+)
+
+func newServeCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the REST, GraphQL and gRPC APIs until interrupted",
+		Long: "Serve the REST, GraphQL and gRPC APIs until SIGINT or SIGTERM is received, then drain in-flight " +
+			"requests and exit. A second signal exits without waiting for the drain.",
+		Args: cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return run(a.cfg)
+		},
+	}
+}
+
+// run serves the API until SIGINT or SIGTERM is received, then drains in-flight requests and tears everything down
+func run(cfg conf.Config) error {
+	shutdownTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	//Deferred first so it runs last, flushing spans recorded during the drain
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	database, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	//Closing the cluster stops its health monitor before closing the pools, so it is always the last thing torn down
+	defer database.Close()
+	if err := metrics.RegisterDBStats(database); err != nil {
+		return fmt.Errorf("failed to register database metrics: %w", err)
+	}
+
+	draining := &controllers.Draining{}
+	router := controllers.SetupRouter(controllers.RouterConfig{ServiceName: cfg.Tracing.ServiceName, Debug: cfg.Server.Debug})
+	controllers.RegisterReadiness(router, cfg.Server.ReadinessTimeout,
+		controllers.HealthCheck{Name: "shutdown", Check: draining.Check},
+		controllers.HealthCheck{Name: "database", Check: database.Ping},
+		controllers.HealthCheck{Name: "migrations", Check: database.CheckMigrations},
+		controllers.HealthCheck{Name: "connectionPool", Check: database.CheckPoolSaturation, First: true},
+	)
+	router.Use(controllers.RequireDatabase(database, cfg.Database.HealthCheckInterval))
+	//left nil when rate limiting is off
+	var limiter *controllers.RateLimiter
+	if cfg.RateLimit.Enabled {
+		routeLimits := make([]controllers.RateLimitRule, len(cfg.RateLimit.Routes))
+		for i, route := range cfg.RateLimit.Routes {
+			routeLimits[i] = controllers.RateLimitRule{Route: route.Route, Rate: route.Rate, Burst: route.Burst}
+		}
+		limiter, err = controllers.NewRateLimiter(controllers.RateLimitRule{
+			Rate:  cfg.RateLimit.Rate,
+			Burst: cfg.RateLimit.Burst,
+		}, routeLimits)
+		if err != nil {
+			return err
+		}
+	}
+	//shared with the gRPC server, left nil when authentication is off
+	var authConfig *controllers.AuthConfig
+	var keys models.APIKeyRepository
+	if cfg.Auth.Enabled {
+		if cfg.Auth.BootstrapKey != "" {
+			slog.Warn("auth.bootstrapKey is set and grants admin access to anyone holding it, unset it once real admin keys exist")
+		}
+		keys = metrics.NewAPIKeyRepository(models.APIKeyModel{DB: database})
+		authConfig = &controllers.AuthConfig{
+			APIKeys:       keys,
+			BootstrapKey:  cfg.Auth.BootstrapKey,
+			TouchInterval: cfg.Auth.LastUsedInterval,
+		}
+		tokens, err := auth.LoadTokenVerifier(cfg.Auth.JWT)
+		if err != nil {
+			return err
+		}
+		//assigned only when set so a nil verifier does not become a non-nil interface
+		if tokens != nil {
+			authConfig.Tokens = tokens
+		}
+		//rejected credentials are limited by IP before they are looked up, the rest once the client is known
+		if limiter != nil {
+			router.Use(limiter.LimitFailedAuth())
+		}
+		router.Use(controllers.Authenticate(*authConfig))
+	} else {
+		slog.Warn("authentication is disabled, every route is public")
+		router.Use(controllers.AllowAnonymous())
+	}
+	if limiter != nil {
+		router.Use(limiter.Middleware())
+	}
+	//left nil when quotas are off, shared with the gRPC server like the limiter
+	var quotas *controllers.QuotaTracker
+	if cfg.Quota.Enabled {
+		quotas, err = controllers.NewQuotaTracker(metrics.NewUsageRepository(models.UsageModel{DB: database}),
+			cfg.Quota.Daily, cfg.Quota.FlushInterval)
+		if err != nil {
+			return err
+		}
+		quotas.Start()
+		//Deferred after the database so it runs before the pools close, saving the last interval's counts
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+			defer cancel()
+			if err := quotas.Stop(ctx); err != nil {
+				slog.Error("failed to save request quota usage", "error", err)
+			}
+		}()
+		router.Use(quotas.Middleware())
+		controllers.RegisterUsageRoutes(router, quotas)
+	}
+	//registered behind the rate limit and quota but not idempotency, so created key secrets are never stored
+	if keys != nil {
+		controllers.RegisterAPIKeyRoutes(router, keys)
+	}
+	if cfg.Idempotency.Enabled {
+		idempotency, err := controllers.NewIdempotency(metrics.NewIdempotencyRepository(models.IdempotencyModel{DB: database}),
+			cfg.Idempotency.Window, cfg.Idempotency.LockTimeout, cfg.Idempotency.MaxBodyBytes)
+		if err != nil {
+			return err
+		}
+		router.Use(idempotency.Middleware())
+	}
+	users := metrics.NewUserRepository(models.UserModel{DB: database})
+	addresses := metrics.NewAddressRepository(models.AddressModel{DB: database})
+	controllers.RegisterRoutes(router, users, addresses, controllers.RouteVersions{
+		Unversioned: controllers.Deprecation{At: cfg.API.Unversioned.DeprecatedAt, Sunset: cfg.API.Unversioned.Sunset},
+		V1:          controllers.Deprecation{At: cfg.API.V1.DeprecatedAt, Sunset: cfg.API.V1.Sunset},
+	})
+	if err := controllers.RegisterBatchRoutes(router, database, users, addresses, cfg.Batch.MaxOperations); err != nil {
+		return err
+	}
+	if err := controllers.RegisterGraphQLRoutes(router, users, addresses, cfg.GraphQL.MaxDepth); err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.Server.Addr(),
+		Handler: router,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("server listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	var grpcServer *controllers.GRPCServer
+	grpcErr := make(chan error, 1)
+	if cfg.GRPC.Enabled {
+		grpcServer = controllers.NewGRPCServer(users, addresses, controllers.GRPCConfig{
+			Auth:        authConfig,
+			Database:    database,
+			RateLimiter: limiter,
+			Quota:       quotas,
+			Debug:       cfg.Server.Debug,
+			Reflection:  cfg.GRPC.Reflection,
+		})
+		lis, err := net.Listen("tcp", cfg.GRPC.Addr())
+		if err != nil {
+			return fmt.Errorf("failed to listen for gRPC: %w", err)
+		}
+		go func() {
+			slog.Info("gRPC server listening", "addr", lis.Addr().String())
+			//Serve returns nil once Shutdown has stopped it
+			if err := grpcServer.Serve(lis); err != nil {
+				grpcErr <- err
+			}
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("server stopped unexpectedly: %w", err)
+	case err := <-grpcErr:
+		return fmt.Errorf("gRPC server stopped unexpectedly: %w", err)
+	case <-ctx.Done():
+	}
+	//A second signal kills the process immediately instead of waiting for the drain
+	stop()
+
+	slog.Info("shutdown signal received, draining connections")
+	draining.Begin()
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+	defer cancel()
+	//The gRPC server drains alongside the HTTP server, sharing the drain timeout, and both finish before the
+	//deferred teardown closes what their calls use
+	grpcDrained := make(chan struct{})
+	go func() {
+		defer close(grpcDrained)
+		if grpcServer == nil {
+			return
+		}
+		if err := grpcServer.Shutdown(drainCtx); err != nil {
+			slog.Error("failed to drain in-flight gRPC calls", "error", err)
+		}
+	}()
+	err = srv.Shutdown(drainCtx)
+	<-grpcDrained
+	if err != nil {
+		return fmt.Errorf("failed to drain in-flight requests: %w", err)
+	}
+	slog.Info("server stopped")
+	return nil
+}
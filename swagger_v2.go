@@ -0,0 +1,21 @@
+package main
+
+// General API info for the /v2 swagger docs, read by swag init --generalInfo swagger_v2.go as make update-swagger
+// runs it. It matches main.go's but for the version
+
+// @title Go + Gin API Practice
+// @version 2.0
+// @description A small go/gin web app providing a simple REST API for managing users and addresses
+
+// @host localhost:8080
+// @BasePath /
+// @query.collection.format multi
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT or API key sent as "Bearer <token>"
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// fileClient writes each batch of spans as a line of OTLP/JSON, the same format the OpenTelemetry collector's file
+// exporter produces, so the file can be replayed into any OTLP backend
+type fileClient struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileClient(path string) *fileClient {
+	return &fileClient{path: path}
+}
+
+func (f *fileClient) Start(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.openLocked()
+}
+
+func (f *fileClient) Stop(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *fileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	line, err := protojson.Marshal(&collectortracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	_, err = f.file.Write(append(line, '\n'))
+	return err
+}
+
+func (f *fileClient) openLocked() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return nil
+}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/lengebretsen/go-practice/conf"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+const (
+	ExporterNone     = "none"
+	ExporterStdout   = "stdout"
+	ExporterOTLPFile = "otlpfile"
+)
+
+// ShutdownFunc flushes any buffered spans and releases the exporter
+type ShutdownFunc func(ctx context.Context) error
+
+func newExporter(cfg conf.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch exporter := cfg.Exporter; exporter {
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterOTLPFile:
+		return otlptrace.NewUnstarted(newFileClient(cfg.File)), nil
+	default:
+		return nil, fmt.Errorf("unknown tracing.exporter [%s], expected one of %s, %s or %s", exporter, ExporterNone, ExporterStdout, ExporterOTLPFile)
+	}
+}
+
+// Init installs the global tracer provider cfg describes and W3C trace context propagator. With the "none" exporter spans are
+// never recorded, but incoming traceparent headers are still propagated
+func Init(cfg conf.TracingConfig) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.Exporter == ExporterNone {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(cfg.ServiceName),
+		semconv.HostNameKey.String(hostname),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}